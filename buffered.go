@@ -0,0 +1,128 @@
+package serialport
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// A BufferedWriter coalesces small writes to a SerialPort, sending the buffered data
+// when the buffer is full, flushEvery after the first unsent write, or on Flush and Close.
+// Like bufio.Writer, once a write to the serial port fails every later call returns that error.
+type BufferedWriter struct {
+	sp         *SerialPort
+	flushEvery time.Duration
+
+	mu     sync.Mutex // guards everything below
+	buf    []byte
+	timer  *time.Timer // pending timed flush
+	err    error
+	closed bool
+}
+
+// NewBufferedWriter returns a BufferedWriter for sp with a buffer of size bytes (4096 if size <= 0).
+// If flushEvery > 0, buffered data is sent at most flushEvery after it was written, bounding latency.
+func NewBufferedWriter(sp *SerialPort, size int, flushEvery time.Duration) *BufferedWriter {
+	if size <= 0 {
+		size = 4096
+	}
+	return &BufferedWriter{sp: sp, flushEvery: flushEvery, buf: make([]byte, 0, size)}
+}
+
+// Write buffers p, sending the buffer to the serial port each time it fills up.
+// Writes of at least a whole buffer go straight to the serial port when the buffer is empty.
+func (bw *BufferedWriter) Write(p []byte) (n int, err error) {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+
+	if bw.closed {
+		return 0, fmt.Errorf("serialport: write to closed BufferedWriter")
+	}
+	if bw.err != nil {
+		return 0, bw.err
+	}
+
+	for len(p) > 0 {
+		if len(bw.buf) == 0 && len(p) >= cap(bw.buf) {
+			m, err := bw.sp.Write(p)
+			n += m
+			if err == nil && m < len(p) {
+				err = io.ErrShortWrite
+			}
+			bw.err = err
+			return n, err
+		}
+
+		m := copy(bw.buf[len(bw.buf):cap(bw.buf)], p)
+		bw.buf = bw.buf[:len(bw.buf)+m]
+		n += m
+		p = p[m:]
+		if len(bw.buf) == cap(bw.buf) {
+			if err := bw.flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+
+	if len(bw.buf) > 0 && bw.timer == nil && bw.flushEvery > 0 {
+		bw.timer = time.AfterFunc(bw.flushEvery, bw.timedFlush)
+	}
+	return n, nil
+}
+
+// Flush sends any buffered data to the serial port.
+func (bw *BufferedWriter) Flush() error {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+
+	if bw.err != nil {
+		return bw.err
+	}
+	return bw.flush()
+}
+
+// Close flushes any buffered data and stops the BufferedWriter. It does not close the serial port.
+func (bw *BufferedWriter) Close() error {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+
+	if bw.closed {
+		return nil
+	}
+	bw.closed = true
+	if bw.err != nil {
+		return bw.err
+	}
+	return bw.flush()
+}
+
+func (bw *BufferedWriter) timedFlush() {
+	bw.mu.Lock()
+	defer bw.mu.Unlock()
+
+	bw.timer = nil
+	if bw.closed || bw.err != nil {
+		return
+	}
+	bw.flush()
+}
+
+// flush writes the buffer to the serial port, keeping what could not be written. mu must be held.
+func (bw *BufferedWriter) flush() error {
+	if bw.timer != nil {
+		bw.timer.Stop()
+		bw.timer = nil
+	}
+	if len(bw.buf) == 0 {
+		return nil
+	}
+
+	n, err := bw.sp.Write(bw.buf)
+	if err == nil && n < len(bw.buf) {
+		err = io.ErrShortWrite
+	}
+	bw.buf = bw.buf[:copy(bw.buf, bw.buf[n:])]
+	bw.err = err
+	return err
+}
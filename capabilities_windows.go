@@ -0,0 +1,209 @@
+package serialport
+
+import (
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Reference https://docs.microsoft.com/en-us/windows/win32/api/winbase/ns-winbase-commprop
+type win32COMMPROP struct {
+	PacketLength       uint16
+	PacketVersion      uint16
+	ServiceMask        uint32
+	Reserved1          uint32
+	MaxTxQueue         uint32
+	MaxRxQueue         uint32
+	MaxBaud            uint32
+	ProvSubType        uint32
+	ProvCapabilities   uint32
+	SettableParams     uint32
+	SettableBaud       uint32
+	SettableData       uint16
+	SettableStopParity uint16
+	CurrentTxQueue     uint32
+	CurrentRxQueue     uint32
+	ProvSpec1          uint32
+	ProvSpec2          uint32
+	ProvChar           [1]uint16
+}
+
+const win32BAUD_USER = 0x10000000
+
+// COMMPROP.dwSettableBaud bits
+var win32SettableBauds = []struct {
+	bit  uint32
+	baud int
+}{
+	{0x00000001, 75},
+	{0x00000002, BR110},
+	{0x00000004, 134},
+	{0x00000008, 150},
+	{0x00000010, BR300},
+	{0x00000020, BR600},
+	{0x00000040, BR1200},
+	{0x00000080, 1800},
+	{0x00000100, BR2400},
+	{0x00000200, BR4800},
+	{0x00000400, 7200},
+	{0x00000800, BR9600},
+	{0x00001000, BR14400},
+	{0x00002000, BR19200},
+	{0x00004000, BR38400},
+	{0x00008000, 56000},
+	{0x00040000, BR57600},
+	{0x00020000, BR115200},
+	{0x00010000, BR128000},
+}
+
+// COMMPROP.wSettableData bits
+var win32SettableData = []struct {
+	bit      uint16
+	dataBits DataBits
+}{
+	{0x0001, DB5},
+	{0x0002, DB6},
+	{0x0004, DB7},
+	{0x0008, DB8},
+	{0x0010, 16},
+}
+
+// COMMPROP.wSettableStopParity bits
+var win32SettableStopBits = []struct {
+	bit      uint16
+	stopBits StopBits
+}{
+	{0x0001, SB1},
+	{0x0002, SB1_5},
+	{0x0004, SB2},
+}
+
+var win32SettableParity = []struct {
+	bit    uint16
+	parity Parity
+}{
+	{0x0100, PN},
+	{0x0200, PO},
+	{0x0400, PE},
+	{0x0800, PM},
+	{0x1000, PS},
+}
+
+var procGetCommProperties = modkernel32.NewProc("GetCommProperties")
+
+func win32GetCommProperties(handle windows.Handle, prop *win32COMMPROP) error {
+	r1, _, err := syscall.Syscall(procGetCommProperties.Addr(), 2, uintptr(handle), uintptr(unsafe.Pointer(prop)), 0)
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+// Capabilities describes the settings the serial port driver accepts.
+// An empty slice means the driver does not report that capability.
+type Capabilities struct {
+	BaudRates  []int      // settable standard baud rates
+	CustomBaud bool       // arbitrary baud rates are accepted
+	DataBits   []DataBits // settable data bits
+	StopBits   []StopBits // settable stop bits
+	Parities   []Parity   // settable parities
+	MaxTxQueue int        // maximum driver output buffer size in bytes, 0 means no limit
+	MaxRxQueue int        // maximum driver input buffer size in bytes, 0 means no limit
+}
+
+// Capabilities returns the settings the serial port driver accepts, as reported by GetCommProperties.
+func (sp *SerialPort) Capabilities() (caps Capabilities, err error) {
+	prop := win32COMMPROP{}
+	if err = win32GetCommProperties(sp.handle, &prop); err != nil {
+		return
+	}
+	return decodeCommProp(&prop), nil
+}
+
+func decodeCommProp(prop *win32COMMPROP) (caps Capabilities) {
+	for _, b := range win32SettableBauds {
+		if prop.SettableBaud&b.bit != 0 {
+			caps.BaudRates = append(caps.BaudRates, b.baud)
+		}
+	}
+	caps.CustomBaud = prop.SettableBaud&win32BAUD_USER != 0
+
+	for _, d := range win32SettableData {
+		if prop.SettableData&d.bit != 0 {
+			caps.DataBits = append(caps.DataBits, d.dataBits)
+		}
+	}
+	for _, s := range win32SettableStopBits {
+		if prop.SettableStopParity&s.bit != 0 {
+			caps.StopBits = append(caps.StopBits, s.stopBits)
+		}
+	}
+	for _, p := range win32SettableParity {
+		if prop.SettableStopParity&p.bit != 0 {
+			caps.Parities = append(caps.Parities, p.parity)
+		}
+	}
+
+	caps.MaxTxQueue = int(prop.MaxTxQueue)
+	caps.MaxRxQueue = int(prop.MaxRxQueue)
+
+	return
+}
+
+// checkCapabilities reports a precise error if cfg uses a setting the driver does not accept.
+func checkCapabilities(cfg Config, caps Capabilities) error {
+	if len(caps.BaudRates) > 0 && !caps.CustomBaud && !containsInt(caps.BaudRates, cfg.BaudRate) {
+		return configErrorf("BaudRate", "driver does not support %v baud, only the standard rates %v", cfg.BaudRate, caps.BaudRates)
+	}
+
+	if len(caps.DataBits) > 0 && !containsDataBits(caps.DataBits, cfg.DataBits) {
+		return configErrorf("DataBits", "driver does not support %v data bits", cfg.DataBits)
+	}
+
+	if len(caps.StopBits) > 0 && !containsStopBits(caps.StopBits, cfg.StopBits) {
+		return configErrorf("StopBits", "driver does not support Config.StopBits %v", cfg.StopBits)
+	}
+
+	if len(caps.Parities) > 0 && !containsParity(caps.Parities, cfg.Parity) {
+		return configErrorf("Parity", "driver does not support Config.Parity %v", cfg.Parity)
+	}
+
+	return nil
+}
+
+func containsInt(s []int, v int) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsDataBits(s []DataBits, v DataBits) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsStopBits(s []StopBits, v StopBits) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
+
+func containsParity(s []Parity, v Parity) bool {
+	for _, x := range s {
+		if x == v {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,24 @@
+package serialport
+
+// CommErrorFlags is a set of line errors reported by CommErrors.
+type CommErrorFlags uint8
+
+const (
+	CommRxOver  CommErrorFlags = 1 << iota // input buffer overflow, received data was lost
+	CommOverrun                            // UART overrun, a character was not read before the next one arrived
+	CommParity                             // parity error
+	CommFrame                              // framing error, usually a baud rate mismatch
+	CommBreak                              // break condition received
+	CommTxFull                             // output buffer full when a character was queued (Windows only)
+)
+
+// Has reports whether all of flags are set in f.
+func (f CommErrorFlags) Has(flags CommErrorFlags) bool { return f&flags == flags }
+
+// LineErrorStats counts the line errors of a serial port since Open, as returned by LineErrors.
+type LineErrorStats struct {
+	Parity  int // parity errors
+	Framing int // framing errors, usually a baud rate mismatch
+	Overrun int // received data lost to a UART overrun or a full input buffer
+	Break   int // break conditions received
+}
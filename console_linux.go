@@ -0,0 +1,64 @@
+package serialport
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// procConsoles lists the registered kernel consoles.
+var procConsoles = "/proc/consoles"
+
+// ConsolePort returns the device name of the serial port the kernel console is on, e.g. /dev/ttyS0,
+// /dev/ttyAMA0 or /dev/ttyGS0, so that tools can attach to a board console without knowing the board.
+// Virtual terminals (tty0, tty1, ...) are skipped; if several serial consoles are active,
+// the one backing /dev/console is returned.
+func ConsolePort() (string, error) {
+	// The last active console is the one backing /dev/console.
+	if active, err := readSysfsString(filepath.Join(sysClassTTY, "console", "active")); err == nil {
+		names := strings.Fields(active)
+		for i := len(names) - 1; i >= 0; i-- {
+			if !isVirtualTerminal(names[i]) {
+				return "/dev/" + names[i], nil
+			}
+		}
+	}
+
+	// Older kernels only have /proc/consoles, where C marks the preferred console.
+	f, err := os.Open(procConsoles)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	name := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || isVirtualTerminal(fields[0]) || !strings.Contains(fields[2], "E") {
+			continue
+		}
+		if name == "" || strings.Contains(fields[2], "C") {
+			name = fields[0]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	if name == "" {
+		return "", fmt.Errorf("serialport: no serial console found")
+	}
+	return "/dev/" + name, nil
+}
+
+// isVirtualTerminal reports whether the tty name is a virtual terminal (tty, tty0, tty1, ...).
+func isVirtualTerminal(name string) bool {
+	if !strings.HasPrefix(name, "tty") {
+		return false
+	}
+	_, err := strconv.Atoi(name[3:])
+	return name == "tty" || err == nil
+}
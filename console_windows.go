@@ -0,0 +1,7 @@
+package serialport
+
+// ConsolePort returns the device name of the serial port the kernel console is on.
+// Windows has no serial kernel console, so it always returns ErrUnsupported.
+func ConsolePort() (string, error) {
+	return "", ErrUnsupported
+}
@@ -0,0 +1,204 @@
+package serialport
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// readContext is read, returning ctx.Err() as soon as ctx is done.
+// It waits for data in poll(2) together with the wake pipe of reads, which Close and a goroutine
+// watching ctx write to, then reads what is available, so that the fd is never left in the middle of a read.
+// A ctx that can never be done, as that of Read, costs no goroutine.
+func (sp *SerialPort) readContext(ctx context.Context, b []byte) (n int, err error) {
+	if err = sp.waitIOContext(ctx, unix.POLLIN, sp.readTimeoutBudget()); err != nil {
+		if err == ErrTimeout {
+			err = nil
+		}
+		return
+	}
+	return sp.read(b)
+}
+
+// writeContext is write, returning ctx.Err() as soon as ctx is done.
+// A blocking write cannot be interrupted, so b is written in chunks of about 100 ms of transmission,
+// each once there is room in the output buffer.
+func (sp *SerialPort) writeContext(ctx context.Context, b []byte) (n int, err error) {
+	if ctx.Done() == nil {
+		return sp.write(b)
+	}
+	// Close waits for the write to return before closing the wake pipe.
+	if !sp.acquire() {
+		return 0, ErrClosed
+	}
+	defer sp.release()
+
+	cfg := sp.config()
+	timeout := cfg.WriteTimeout
+	if timeout <= 0 {
+		timeout = -1
+	}
+	deadline := deadlineAfter(timeout)
+	chunk := progressChunkSize(cfg)
+	for n < len(b) {
+		if err = sp.waitIOContext(ctx, unix.POLLOUT, remaining(deadline)); err != nil {
+			return
+		}
+		end := n + chunk
+		if end > len(b) {
+			end = len(b)
+		}
+		var m int
+		m, err = unix.Write(sp.fd, b[n:end])
+		if err == unix.EAGAIN {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	return
+}
+
+// A wakePipe interrupts a poll(2) on the fd: Close writes to it for good, and a goroutine watching
+// the context of a wait writes to it until the wait returns, which drains it.
+type wakePipe [2]int
+
+// newWakePipe creates a non-blocking wakePipe.
+func newWakePipe() (*wakePipe, error) {
+	var p wakePipe
+	if err := unix.Pipe2(p[:], unix.O_CLOEXEC|unix.O_NONBLOCK); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+func (p *wakePipe) signal() {
+	unix.Write(p[1], []byte{0})
+}
+
+func (p *wakePipe) drain() {
+	var buf [16]byte
+	for {
+		if n, _ := unix.Read(p[0], buf[:]); n <= 0 {
+			return
+		}
+	}
+}
+
+func (p *wakePipe) close() {
+	unix.Close(p[0])
+	unix.Close(p[1])
+}
+
+// openWake creates the wake pipes of reads and writes of a serial port being opened.
+func (sp *SerialPort) openWake() (err error) {
+	if sp.rwake, err = newWakePipe(); err != nil {
+		return
+	}
+	if sp.wwake, err = newWakePipe(); err != nil {
+		sp.rwake.close()
+		sp.rwake = nil
+	}
+	return
+}
+
+// closeWake closes the wake pipes once nothing waits on them any more.
+func (sp *SerialPort) closeWake() {
+	if sp.rwake != nil {
+		sp.rwake.close()
+		sp.wwake.close()
+	}
+}
+
+// interrupt wakes the waits of reads and of writes with a context, called by Close
+// before it waits for them to return.
+func (sp *SerialPort) interrupt() {
+	if sp.rwake != nil {
+		sp.rwake.signal()
+		sp.wwake.signal()
+	}
+}
+
+// waitIOContext is waitIO, also returning ctx.Err() as soon as ctx is done.
+// It returns ErrClosed once the serial port is closed. Only a ctx that can be done costs a goroutine,
+// which writes to the wake pipe of the direction of events.
+func (sp *SerialPort) waitIOContext(ctx context.Context, events int16, timeout time.Duration) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if sp.isClosed() {
+		return ErrClosed
+	}
+	var wake *wakePipe
+	switch {
+	case events&unix.POLLIN != 0:
+		wake = sp.rwake
+	case ctx.Done() != nil:
+		// Only a write with a context keeps Close waiting, see writeContext,
+		// so only it may use the wake pipe, which Close closes.
+		wake = sp.wwake
+	}
+
+	if done := ctx.Done(); done != nil && wake != nil {
+		stop := make(chan struct{})
+		stopped := make(chan struct{})
+		go func() {
+			defer close(stopped)
+			select {
+			case <-done:
+				wake.signal()
+			case <-stop:
+			}
+		}()
+		// The goroutine must be gone before its wakeup is drained, so that it does not wake the next wait.
+		defer func() {
+			close(stop)
+			<-stopped
+			if ctx.Err() != nil && !sp.isClosed() {
+				wake.drain()
+			}
+		}()
+	}
+
+	deadline := deadlineAfter(timeout)
+	fds := []unix.PollFd{{Fd: int32(sp.fd), Events: events}}
+	if wake != nil {
+		fds = append(fds, unix.PollFd{Fd: int32(wake[0]), Events: unix.POLLIN})
+	}
+	for {
+		ms := -1
+		if timeout >= 0 {
+			ms = int((remaining(deadline) + time.Millisecond - 1) / time.Millisecond)
+		}
+		n, err := unix.Poll(fds, ms)
+		if err == unix.EINTR {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if wake != nil && fds[1].Revents != 0 {
+			if sp.isClosed() {
+				return ErrClosed
+			}
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			// A stale wakeup: drop it, unless Close wrote it meanwhile.
+			wake.drain()
+			if sp.isClosed() {
+				return ErrClosed
+			}
+			continue
+		}
+		if n > 0 {
+			return nil
+		}
+		if ms == 0 {
+			return ErrTimeout
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package serialport
+
+import (
+	"bytes"
+	"context"
+)
+
+// Windows has no line discipline, so cooked mode is emulated by read and write.
+
+// SetRawMode switches the serial port to raw mode, in which data passes through unchanged.
+// This is the mode Open sets. Input of an incomplete cooked line is kept for the next read.
+func (sp *SerialPort) SetRawMode() error {
+	sp.cmu.Lock()
+	sp.cooked = false
+	sp.cmu.Unlock()
+
+	sp.bmu.Lock()
+	sp.rbuf = append(sp.rbuf, sp.line...)
+	sp.line = nil
+	sp.bmu.Unlock()
+	return nil
+}
+
+// SetCookedMode switches the serial port to cooked (canonical) mode, as used by interactive terminals:
+// Read returns whole lines, received data is echoed, CR is read as NL and NL is written as CRLF.
+// Windows has no line discipline, so this is emulated in software; line editing (erase, kill) is not.
+func (sp *SerialPort) SetCookedMode() error {
+	sp.cmu.Lock()
+	sp.cooked = true
+	sp.cmu.Unlock()
+	return nil
+}
+
+// readCooked returns at most one line, up to and including its NL, once the line is complete.
+// Like a raw read, it returns no data if the line is not complete before the read times out.
+// The echo is written without holding wmu, as taking it under rmu would invert the lock order.
+func (sp *SerialPort) readCooked(ctx context.Context, b []byte) (n int, err error) {
+	buf := make([]byte, 256)
+
+	for {
+		sp.bmu.Lock()
+		if i := bytes.IndexByte(sp.line, '\n'); i >= 0 {
+			n = copy(b, sp.line[:i+1])
+			sp.line = sp.line[n:]
+			sp.bmu.Unlock()
+			return n, nil
+		}
+		sp.bmu.Unlock()
+
+		m, err := sp.readRaw(ctx, buf)
+		if m == 0 {
+			return 0, err
+		}
+		in := buf[:m]
+		for i, c := range in {
+			if c == '\r' {
+				in[i] = '\n'
+			}
+		}
+		sp.bmu.Lock()
+		sp.line = append(sp.line, in...)
+		sp.bmu.Unlock()
+
+		// Input received together with an error is kept for the next read.
+		if _, werr := sp.writeRaw(ctx, expandNL(in)); err == nil {
+			err = werr
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// writeCooked writes b with each NL expanded to CRLF.
+// It returns the number of bytes of b whose translation was written completely.
+func (sp *SerialPort) writeCooked(ctx context.Context, b []byte) (n int, err error) {
+	out := expandNL(b)
+	m, err := sp.writeRaw(ctx, out)
+	if m == len(out) {
+		return len(b), err
+	}
+	for _, c := range b {
+		w := 1
+		if c == '\n' {
+			w = 2
+		}
+		if m < w {
+			break
+		}
+		m -= w
+		n++
+	}
+	return n, err
+}
+
+// discardLine drops cooked mode input not yet returned by read.
+func (sp *SerialPort) discardLine() {
+	sp.bmu.Lock()
+	sp.line = nil
+	sp.bmu.Unlock()
+}
+
+// expandNL returns b with each NL replaced by CRLF.
+func expandNL(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{'\n'}, []byte{'\r', '\n'})
+}
@@ -0,0 +1,59 @@
+package serialport
+
+import (
+	"context"
+	"time"
+)
+
+// SetDeadline sets both the read and the write deadline, see SetReadDeadline and SetWriteDeadline.
+func (sp *SerialPort) SetDeadline(t time.Time) error {
+	sp.cmu.Lock()
+	sp.rdeadline = t
+	sp.wdeadline = t
+	sp.cmu.Unlock()
+	return nil
+}
+
+// SetReadDeadline sets the deadline for Read and ReadContext calls, like net.Conn: once t has passed,
+// they return ErrTimeout, whose Timeout method reports true, instead of waiting any longer.
+// A zero t means no deadline. The deadline applies to the reads started after it is set.
+// Config.Timeout still ends each read that gets no data in time; set it to 0 to wait for the deadline only.
+func (sp *SerialPort) SetReadDeadline(t time.Time) error {
+	sp.cmu.Lock()
+	sp.rdeadline = t
+	sp.cmu.Unlock()
+	return nil
+}
+
+// SetWriteDeadline sets the deadline for Write and WriteContext calls, like net.Conn: once t has passed,
+// they return ErrTimeout, together with the number of bytes written until then.
+// A zero t means no deadline. The deadline applies to the writes started after it is set.
+func (sp *SerialPort) SetWriteDeadline(t time.Time) error {
+	sp.cmu.Lock()
+	sp.wdeadline = t
+	sp.cmu.Unlock()
+	return nil
+}
+
+// deadlines returns the read and write deadlines.
+func (sp *SerialPort) deadlines() (read, write time.Time) {
+	sp.cmu.Lock()
+	defer sp.cmu.Unlock()
+	return sp.rdeadline, sp.wdeadline
+}
+
+// withDeadline bounds ctx by the deadline d, if set. The returned function translates the error
+// of an operation run with the bounded context, reporting d passing as ErrTimeout.
+func withDeadline(ctx context.Context, d time.Time) (context.Context, context.CancelFunc, func(error) error) {
+	if d.IsZero() {
+		return ctx, func() {}, func(err error) error { return err }
+	}
+	parent := ctx
+	ctx, cancel := context.WithDeadline(parent, d)
+	return ctx, cancel, func(err error) error {
+		if err != nil && err == ctx.Err() && parent.Err() == nil {
+			return ErrTimeout
+		}
+		return err
+	}
+}
@@ -0,0 +1,66 @@
+package serialport
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// Bus types reported by SerialPort.BusType.
+const (
+	BusUSB      = "usb"      // USB adapter
+	BusPCI      = "pci"      // PCI or PCIe card
+	BusPlatform = "platform" // UART built into the SoC or chipset, described by the device tree or ACPI
+	BusPNP      = "pnp"      // legacy ISA/ACPI enumerated UART (COM1-COM4)
+)
+
+// DriverName returns the name of the kernel driver bound to the serial port,
+// e.g. "ftdi_sio", "cp210x", "ch341", "cdc_acm" or "serial8250".
+func (sp *SerialPort) DriverName() (string, error) {
+	dir, ok, err := ttyDevice(sp.name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("serialport: %v has no backing device", sp.name)
+	}
+
+	driver, err := os.Readlink(filepath.Join(dir, "driver"))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Base(driver), nil
+}
+
+// BusType returns the bus the serial port hardware is attached to: BusUSB, BusPCI, BusPlatform, BusPNP,
+// or the name of the sysfs subsystem for other buses.
+func (sp *SerialPort) BusType() (string, error) {
+	dir, ok, err := ttyDevice(sp.name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("serialport: %v has no backing device", sp.name)
+	}
+
+	// The tty device may sit on an intermediate bus (usb-serial, serial-base), so walk up to a known one.
+	first := ""
+	for ; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		subsystem, err := os.Readlink(filepath.Join(dir, "subsystem"))
+		if err != nil {
+			continue
+		}
+		bus := filepath.Base(subsystem)
+		switch bus {
+		case BusUSB, BusPCI, BusPlatform, BusPNP:
+			return bus, nil
+		}
+		if first == "" {
+			first = bus
+		}
+	}
+	if first == "" {
+		return "", fmt.Errorf("serialport: cannot determine the bus of %v", sp.name)
+	}
+	return first, nil
+}
@@ -0,0 +1,48 @@
+package serialport
+
+import (
+	"errors"
+	"syscall"
+)
+
+// ErrRetry may be returned by an error filter, see SetErrorFilter, to retry the failed Read or Write.
+var ErrRetry = errors.New("serialport: retry")
+
+// DefaultErrorFilter is the error filter used unless SetErrorFilter installs another one:
+//     EAGAIN (no data or no room in non-blocking mode) becomes a zero-byte Read without error,
+//                   or a Write that stops short with io.ErrShortWrite;
+//     EINTR (interrupted by a signal) retries the operation;
+//     any other error is returned unchanged.
+func DefaultErrorFilter(err error) error {
+	switch {
+	case errors.Is(err, syscall.EAGAIN):
+		return nil
+	case errors.Is(err, syscall.EINTR):
+		return ErrRetry
+	}
+	return err
+}
+
+// SetErrorFilter sets the function that every Read and Write error is passed through before it is returned,
+// to translate or suppress conditions that are benign for the application.
+// The filter returns the error to report, nil to report none, or ErrRetry to retry the operation.
+// A Write whose error is filtered to nil before all of b was written still returns io.ErrShortWrite,
+// as io.Writer requires.
+// A nil filter restores DefaultErrorFilter; to chain with it, call it from the new filter.
+func (sp *SerialPort) SetErrorFilter(filter func(error) error) {
+	sp.cmu.Lock()
+	sp.errFilter = filter
+	sp.cmu.Unlock()
+}
+
+// filterError passes err, which must not be nil, through the error filter.
+func (sp *SerialPort) filterError(err error) error {
+	sp.cmu.Lock()
+	filter := sp.errFilter
+	sp.cmu.Unlock()
+
+	if filter == nil {
+		filter = DefaultErrorFilter
+	}
+	return filter(err)
+}
@@ -0,0 +1,81 @@
+package serialport
+
+import (
+	"bufio"
+	"bytes"
+)
+
+// A FrameAssembler splits a byte stream into frames.
+// It buffers partial frames independently of any SerialPort, so the stream state
+// survives a port being closed and reopened: pump Read output into Feed and pull complete frames with Frames.
+type FrameAssembler struct {
+	split  bufio.SplitFunc
+	buf    []byte
+	frames [][]byte
+}
+
+// NewFrameAssembler returns a FrameAssembler that splits frames with split,
+// e.g. DelimiterSplit, FixedLengthSplit or bufio.ScanLines.
+func NewFrameAssembler(split bufio.SplitFunc) *FrameAssembler {
+	return &FrameAssembler{split: split}
+}
+
+// Feed appends b to the stream and queues every frame it completes.
+// If split returns an error, the buffered partial frame is discarded.
+func (fa *FrameAssembler) Feed(b []byte) {
+	fa.buf = append(fa.buf, b...)
+
+	for len(fa.buf) > 0 {
+		advance, token, err := fa.split(fa.buf, false)
+		if err != nil {
+			fa.buf = fa.buf[:0]
+			return
+		}
+		if token != nil {
+			fa.frames = append(fa.frames, append([]byte(nil), token...))
+		}
+		if advance <= 0 || advance > len(fa.buf) {
+			return
+		}
+		fa.buf = fa.buf[advance:]
+	}
+}
+
+// Frames returns the complete frames queued since the last call.
+func (fa *FrameAssembler) Frames() [][]byte {
+	frames := fa.frames
+	fa.frames = nil
+	return frames
+}
+
+// Buffered returns the number of bytes of the pending partial frame.
+func (fa *FrameAssembler) Buffered() int {
+	return len(fa.buf)
+}
+
+// Reset discards the pending partial frame and any queued frames.
+func (fa *FrameAssembler) Reset() {
+	fa.buf = fa.buf[:0]
+	fa.frames = nil
+}
+
+// DelimiterSplit returns a split function for frames terminated by delim.
+// The delimiter is not part of the frame.
+func DelimiterSplit(delim byte) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if i := bytes.IndexByte(data, delim); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		return 0, nil, nil
+	}
+}
+
+// FixedLengthSplit returns a split function for frames of exactly n bytes.
+func FixedLengthSplit(n int) bufio.SplitFunc {
+	return func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+		if n > 0 && len(data) >= n {
+			return n, data[:n], nil
+		}
+		return 0, nil, nil
+	}
+}
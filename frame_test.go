@@ -0,0 +1,54 @@
+package serialport
+
+import (
+	"bufio"
+	"reflect"
+	"testing"
+)
+
+func TestFrameAssemblerDelimiter(t *testing.T) {
+	fa := NewFrameAssembler(DelimiterSplit('\n'))
+
+	fa.Feed([]byte("$GPGGA,1"))
+	if frames := fa.Frames(); len(frames) != 0 {
+		t.Fatalf("Frames() = %q, want none", frames)
+	}
+
+	fa.Feed([]byte("23\n$GPRMC\n$GP"))
+	want := [][]byte{[]byte("$GPGGA,123"), []byte("$GPRMC")}
+	if frames := fa.Frames(); !reflect.DeepEqual(frames, want) {
+		t.Fatalf("Frames() = %q, want %q", frames, want)
+	}
+	if n := fa.Buffered(); n != 3 {
+		t.Fatalf("Buffered() = %v, want 3", n)
+	}
+}
+
+func TestFrameAssemblerFixedLength(t *testing.T) {
+	fa := NewFrameAssembler(FixedLengthSplit(3))
+
+	fa.Feed([]byte{1, 2})
+	fa.Feed([]byte{3, 4, 5, 6, 7})
+	want := [][]byte{{1, 2, 3}, {4, 5, 6}}
+	if frames := fa.Frames(); !reflect.DeepEqual(frames, want) {
+		t.Fatalf("Frames() = %v, want %v", frames, want)
+	}
+
+	fa.Reset()
+	fa.Feed([]byte{8, 9, 10})
+	want = [][]byte{{8, 9, 10}}
+	if frames := fa.Frames(); !reflect.DeepEqual(frames, want) {
+		t.Fatalf("Frames() after Reset = %v, want %v", frames, want)
+	}
+}
+
+func TestFrameAssemblerScanLines(t *testing.T) {
+	fa := NewFrameAssembler(bufio.ScanLines)
+
+	fa.Feed([]byte("OK\r\nERR"))
+	fa.Feed([]byte("OR\r\n"))
+	want := [][]byte{[]byte("OK"), []byte("ERROR")}
+	if frames := fa.Frames(); !reflect.DeepEqual(frames, want) {
+		t.Fatalf("Frames() = %q, want %q", frames, want)
+	}
+}
@@ -0,0 +1,426 @@
+package serialport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Read reads up to len(b) bytes from the serial port.
+// It returns the number of bytes (0 <= n <= len(b)) read from the serial port and any errors encountered,
+// as translated by the error filter, see SetErrorFilter.
+// A zero-length b returns (0, nil) immediately without touching the serial port.
+// Close unblocks a Read waiting for data at once, which then returns ErrClosed.
+// Once the serial port is closed, Read returns io.EOF, so that loops reading until EOF end cleanly.
+// On every platform, Read returns as soon as at least one byte is available, without waiting to fill b:
+//     Timeout == 0: Read blocks until at least one byte is read;
+//     Timeout > 0:  Read blocks until at least one byte is read or Timeout has elapsed,
+//                   in which case it returns 0, nil.
+func (sp *SerialPort) Read(b []byte) (n int, err error) {
+	return sp.ReadContext(context.Background(), b)
+}
+
+// ReadContext is like Read but returns promptly with ctx.Err() once ctx is done,
+// e.g. to stop a reader blocked for the whole Timeout at shutdown.
+// Cancelling loses no data: bytes already read are returned with ctx.Err(), the rest by the next read.
+func (sp *SerialPort) ReadContext(ctx context.Context, b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+	deadline, _ := sp.deadlines()
+	ctx, cancel, translate := withDeadline(ctx, deadline)
+	defer cancel()
+	defer func() { err = translate(err) }()
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	if n = sp.takeBuffered(b); n > 0 {
+		return
+	}
+	// Close waits for the read to return before closing the handle.
+	if !sp.acquire() {
+		return 0, io.EOF
+	}
+	defer sp.release()
+
+	read := func(b []byte) (int, error) { return sp.readContext(ctx, b) }
+	for {
+		if cfg := sp.config(); cfg.RetryEmptyReads && cfg.Timeout > 0 {
+			n, err = retryEmptyReads(read, b, cfg.Timeout)
+		} else {
+			n, err = read(b)
+		}
+		if n == 0 && sp.isClosed() {
+			// Interrupted by Close.
+			return n, ErrClosed
+		}
+		if err == nil || err == ctx.Err() {
+			return
+		}
+		if err = sp.filterError(err); err != ErrRetry {
+			return
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// retryEmptyReads calls read until it returns data or an error, or timeout has elapsed.
+// Some drivers return no data long before the read timeout, which would make a Read loop spin.
+func retryEmptyReads(read func([]byte) (int, error), b []byte, timeout time.Duration) (n int, err error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		n, err = read(b)
+		if n != 0 || err != nil || !time.Now().Before(deadline) {
+			return
+		}
+	}
+}
+
+// Write writes len(b) bytes to the serial port.
+// It returns the number of bytes (0 <= n <= len(b)) written to the serial port and any errors encountered,
+// as translated by the error filter, see SetErrorFilter.
+// b may be arbitrarily large: it is written in chunks that fit the driver's output buffer.
+// If Config.WriteTimeout > 0 and a chunk does not complete within it, Write returns ErrTimeout,
+// or ErrFlowControlStall if the output queue did not drain at all in that time.
+// With Config.StrictSevenBit and 7 data bits, Write writes nothing and returns an error
+// if b contains a byte with the high bit set, which the line cannot carry.
+// Close waits for a Write in progress; writing to a closed serial port returns ErrClosed.
+func (sp *SerialPort) Write(b []byte) (n int, err error) {
+	if _, deadline := sp.deadlines(); !deadline.IsZero() {
+		return sp.WriteContext(context.Background(), b)
+	}
+
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+
+	// Close waits for the write to return before closing the handle.
+	if !sp.acquire() {
+		return 0, ErrClosed
+	}
+	defer sp.release()
+
+	if sp.config().WriteTimeout <= 0 {
+		return sp.writeChunked(b)
+	}
+
+	before, qerr := sp.outWaiting()
+	n, err = sp.writeChunked(b)
+	if err == ErrTimeout && qerr == nil {
+		if after, qerr := sp.outWaiting(); qerr == nil && flowControlStalled(before, n, after) {
+			err = ErrFlowControlStall
+		}
+	}
+	return
+}
+
+// WriteString is like Write but takes a string, e.g. an AT command, with the same count and error semantics.
+// It implements io.StringWriter, so io.WriteString uses it.
+func (sp *SerialPort) WriteString(s string) (n int, err error) {
+	return sp.Write([]byte(s))
+}
+
+// WriteContext is like Write but returns promptly with ctx.Err() once ctx is done,
+// together with the number of bytes written until then.
+func (sp *SerialPort) WriteContext(ctx context.Context, b []byte) (n int, err error) {
+	_, deadline := sp.deadlines()
+	ctx, cancel, translate := withDeadline(ctx, deadline)
+	defer cancel()
+	defer func() { err = translate(err) }()
+	if err = ctx.Err(); err != nil {
+		return
+	}
+
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+
+	// Close waits for the write to return before closing the handle.
+	if !sp.acquire() {
+		return 0, ErrClosed
+	}
+	defer sp.release()
+
+	return sp.writeChunkedContext(ctx, b)
+}
+
+// Drain blocks until all data written has been transmitted, e.g. before Close or before
+// switching the direction of a half-duplex line. Unlike Flush, it discards nothing.
+// A Write in progress in another goroutine completes first.
+func (sp *SerialPort) Drain() error {
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+
+	return sp.drain()
+}
+
+// InputWaiting returns the number of bytes that Read can return without waiting:
+// those received by the driver and those read ahead by the line readers.
+func (sp *SerialPort) InputWaiting() (int, error) {
+	n, err := sp.inWaiting()
+	if err != nil {
+		return 0, err
+	}
+	sp.bmu.Lock()
+	n += len(sp.rbuf)
+	sp.bmu.Unlock()
+	return n, nil
+}
+
+// OutputWaiting returns the number of bytes written but not yet transmitted.
+func (sp *SerialPort) OutputWaiting() (int, error) {
+	return sp.outWaiting()
+}
+
+// defaultWriteChunkSize is the chunk size of writeChunked when the driver does not report its output buffer size.
+const defaultWriteChunkSize = 4096
+
+// writeChunked writes len(b) bytes to the serial port, at most writeChunkSize bytes at a time,
+// so that a large write never exceeds what the driver accepts at once.
+func (sp *SerialPort) writeChunked(b []byte) (n int, err error) {
+	return sp.writeChunkedContext(context.Background(), b)
+}
+
+// writeChunkedContext is writeChunked, stopping with ctx.Err() once ctx is done.
+func (sp *SerialPort) writeChunkedContext(ctx context.Context, b []byte) (n int, err error) {
+	if cfg := sp.config(); cfg.StrictSevenBit && cfg.DataBits == DB7 {
+		if err = checkSevenBit(b); err != nil {
+			return
+		}
+	}
+
+	size := sp.writeChunkSize()
+	for n < len(b) {
+		end := n + size
+		if end > len(b) {
+			end = len(b)
+		}
+		var m int
+		m, err = sp.writeContext(ctx, b[n:end])
+		n += m
+		if err != nil {
+			if err == ctx.Err() {
+				return
+			}
+			if err = sp.filterError(err); err == ErrRetry {
+				continue
+			}
+			if err == nil && n < len(b) {
+				// A suppressed error must not hide the bytes left unwritten, see io.Writer.
+				err = io.ErrShortWrite
+			}
+			return
+		}
+		if m == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+	return
+}
+
+// checkSevenBit returns an error if b contains a byte that does not fit in 7 data bits.
+func checkSevenBit(b []byte) error {
+	for i, c := range b {
+		if c&0x80 != 0 {
+			return fmt.Errorf("serialport: byte %#02x at offset %v does not fit in 7 data bits", c, i)
+		}
+	}
+	return nil
+}
+
+// flowControlStalled reports whether no byte was transmitted while a write timed out,
+// given the output queue length before and after the write and the number of bytes it queued.
+func flowControlStalled(before, written, after int) bool {
+	return after > 0 && before+written-after <= 0
+}
+
+// Query writes req and then reads the response into resp, holding both the read and
+// the write side of the serial port so that no other goroutine's I/O can interleave.
+// Reading stops when resp is full or a read returns no data within the configured Timeout.
+// It returns the number of response bytes read and any errors encountered.
+func (sp *SerialPort) Query(req []byte, resp []byte) (n int, err error) {
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	// Close waits for the exchange to return before closing the handle.
+	if !sp.acquire() {
+		return 0, ErrClosed
+	}
+	defer sp.release()
+
+	if _, err = sp.writeChunked(req); err != nil {
+		return
+	}
+
+	n = sp.takeBuffered(resp)
+	for n < len(resp) {
+		var m int
+		// Unlike read, readContext waits where Close can interrupt it.
+		m, err = sp.readContext(context.Background(), resp[n:])
+		n += m
+		if err != nil || m == 0 {
+			return
+		}
+	}
+
+	return
+}
+
+// Transaction performs a request/response exchange: it discards stale input, writes req
+// and reads the response up to and including delim, e.g. '\n' or '\r', within timeout
+// (no limit if timeout is 0), holding both the read and the write side of the serial port
+// so that no other goroutine's I/O can interleave. Data received after delim is kept for the next read.
+// On timeout it returns the response received so far and ErrTimeout, so a caller can simply retry.
+func (sp *SerialPort) Transaction(req []byte, delim byte, timeout time.Duration) ([]byte, error) {
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	// Close waits for the exchange to return before closing the handle.
+	if !sp.acquire() {
+		return nil, ErrClosed
+	}
+	defer sp.release()
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	sp.discardBuffered()
+	if err := sp.flushInput(); err != nil {
+		return nil, err
+	}
+	if _, err := sp.writeChunked(req); err != nil {
+		return nil, err
+	}
+	return sp.readUntil(delim, deadline)
+}
+
+// ReadExact blocks until exactly n bytes have been read from the serial port, ignoring Config.Timeout.
+// On Linux the read is performed with VMIN semantics, on Windows by looping on Read.
+// It returns the bytes read so far together with any error encountered.
+// Warning: if the device sends fewer than n bytes, ReadExact blocks forever.
+func (sp *SerialPort) ReadExact(n int) ([]byte, error) {
+	if n < 0 {
+		return nil, fmt.Errorf("serialport: ReadExact count cannot be negative %v", n)
+	}
+
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	// Close waits for the read to return before closing the handle.
+	if !sp.acquire() {
+		return nil, ErrClosed
+	}
+	defer sp.release()
+
+	b := make([]byte, n)
+	got := sp.takeBuffered(b)
+	m, err := sp.readExact(b[got:])
+	return b[:got+m], err
+}
+
+// Resync discards received data until the line has been quiet for quietFor,
+// which marks a clean frame boundary, e.g. after correcting a wrong baud rate.
+// It returns ErrTimeout if the line does not go quiet within timeout.
+func (sp *SerialPort) Resync(quietFor time.Duration, timeout time.Duration) error {
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	// Close waits for the reads to return before closing the handle.
+	if !sp.acquire() {
+		return ErrClosed
+	}
+	defer sp.release()
+
+	if err := sp.flushInput(); err != nil {
+		return err
+	}
+
+	deadline := time.Now().Add(timeout)
+	buf := make([]byte, 256)
+	for {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return ErrTimeout
+		}
+		if wait > quietFor {
+			wait = quietFor
+		}
+
+		_, err := sp.readTimeout(buf, wait)
+		if err == ErrTimeout {
+			if wait == quietFor {
+				return nil
+			}
+			return ErrTimeout
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// takeBuffered moves data read ahead by the line reader into b.
+func (sp *SerialPort) takeBuffered(b []byte) int {
+	sp.bmu.Lock()
+	defer sp.bmu.Unlock()
+
+	n := copy(b, sp.rbuf)
+	sp.rbuf = sp.rbuf[n:]
+	return n
+}
+
+// unread puts p back in front of the buffered data, to be returned by the next read.
+func (sp *SerialPort) unread(p []byte) {
+	if len(p) == 0 {
+		return
+	}
+	sp.bmu.Lock()
+	sp.rbuf = append(append([]byte(nil), p...), sp.rbuf...)
+	sp.bmu.Unlock()
+}
+
+// discardBuffered drops data read ahead by the line reader.
+func (sp *SerialPort) discardBuffered() {
+	sp.bmu.Lock()
+	sp.rbuf = nil
+	sp.bmu.Unlock()
+}
+
+// readTimeoutBudget returns how long a helper built on several reads may take in total:
+// the configured Timeout, or a negative duration (wait forever) if it is 0.
+func (sp *SerialPort) readTimeoutBudget() time.Duration {
+	if timeout := sp.config().Timeout; timeout > 0 {
+		return timeout
+	}
+	return -1
+}
+
+// remaining returns the time left until deadline for readTimeout, where a zero deadline means forever.
+func remaining(deadline time.Time) time.Duration {
+	if deadline.IsZero() {
+		return -1
+	}
+	if d := time.Until(deadline); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// deadlineAfter returns the deadline for timeout, where a negative timeout means no deadline.
+func deadlineAfter(timeout time.Duration) time.Time {
+	if timeout < 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(timeout)
+}
@@ -0,0 +1,87 @@
+package serialport
+
+import (
+	"bytes"
+	"strings"
+	"time"
+)
+
+// readLine returns the next '\n' terminated line without its line ending ("\n" or "\r\n").
+// Bytes read past the line stay buffered for the next read. rmu must be held.
+// On timeout the partial line stays buffered and ErrTimeout is returned.
+func (sp *SerialPort) readLine(deadline time.Time) (string, error) {
+	buf := make([]byte, 256)
+	for {
+		sp.bmu.Lock()
+		if i := bytes.IndexByte(sp.rbuf, '\n'); i >= 0 {
+			line := string(bytes.TrimSuffix(sp.rbuf[:i], []byte{'\r'}))
+			sp.rbuf = sp.rbuf[i+1:]
+			sp.bmu.Unlock()
+			return line, nil
+		}
+		sp.bmu.Unlock()
+
+		timeout := remaining(deadline)
+		if timeout == 0 {
+			return "", ErrTimeout
+		}
+		n, err := sp.readTimeout(buf, timeout)
+		sp.bmu.Lock()
+		sp.rbuf = append(sp.rbuf, buf[:n]...)
+		sp.bmu.Unlock()
+		if err != nil {
+			return "", err
+		}
+	}
+}
+
+// ReadLine reads one '\n' terminated line, without its line ending, within the configured Timeout
+// (no limit if Timeout is 0), e.g. an NMEA sentence. On timeout it returns ErrTimeout and the partial line
+// stays buffered, to be completed by the next ReadLine or returned by Read: unlike a bufio.Scanner
+// wrapping the serial port, ReadLine never holds on to bytes past the line it returns.
+func (sp *SerialPort) ReadLine() (string, error) {
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	return sp.readLine(deadlineAfter(sp.readTimeoutBudget()))
+}
+
+// ReadLines reads n lines, without their line endings, within the configured Timeout
+// (no limit if Timeout is 0). On timeout it returns the lines read so far and ErrTimeout.
+func (sp *SerialPort) ReadLines(n int) ([]string, error) {
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	deadline := deadlineAfter(sp.readTimeoutBudget())
+	lines := make([]string, 0, n)
+	for len(lines) < n {
+		line, err := sp.readLine(deadline)
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+	}
+
+	return lines, nil
+}
+
+// ReadUntilLine reads lines until one contains match, e.g. "OK" or "ERROR" after an AT command,
+// within the configured Timeout (no limit if Timeout is 0). The matching line is the last one returned.
+// On timeout it returns the lines read so far and ErrTimeout.
+func (sp *SerialPort) ReadUntilLine(match string) ([]string, error) {
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	deadline := deadlineAfter(sp.readTimeoutBudget())
+	var lines []string
+	for {
+		line, err := sp.readLine(deadline)
+		if err != nil {
+			return lines, err
+		}
+		lines = append(lines, line)
+		if strings.Contains(line, match) {
+			return lines, nil
+		}
+	}
+}
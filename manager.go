@@ -0,0 +1,199 @@
+package serialport
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A ManagerEvent reports that a registered serial port connected or disconnected.
+type ManagerEvent struct {
+	Name      string      // registered port name
+	Port      *SerialPort // the opened port if Connected, the closed one otherwise
+	Connected bool
+	Err       error // why the port disconnected
+}
+
+// A Manager keeps a set of serial ports open, reopening each one when it disappears
+// (e.g. a USB adapter being unplugged and plugged back in).
+// Closing a managed port with its Close method unregisters it, with an ErrClosed event.
+type Manager struct {
+	interval time.Duration
+	events   chan ManagerEvent
+
+	mu     sync.Mutex
+	ports  map[string]*managedPort
+	closed bool
+	wg     sync.WaitGroup
+}
+
+type managedPort struct {
+	cfg  Config
+	sp   *SerialPort
+	stop chan struct{}
+}
+
+// NewManager returns a Manager that checks its ports and retries opening missing ones every interval
+// (1 second if interval <= 0).
+func NewManager(interval time.Duration) *Manager {
+	if interval <= 0 {
+		interval = time.Second
+	}
+	return &Manager{
+		interval: interval,
+		events:   make(chan ManagerEvent, 64),
+		ports:    make(map[string]*managedPort),
+	}
+}
+
+// Events returns the channel on which connect and disconnect events are delivered.
+// Events are dropped if the channel is full. It is closed by CloseAll.
+func (m *Manager) Events() <-chan ManagerEvent {
+	return m.events
+}
+
+// Register adds the serial port name, opened with cfg, to the Manager.
+// The port is opened in the background and reopened whenever it goes away.
+func (m *Manager) Register(name string, cfg Config) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return errors.New("serialport: Manager is closed")
+	}
+	if _, ok := m.ports[name]; ok {
+		return fmt.Errorf("serialport: %v is already registered", name)
+	}
+
+	mp := &managedPort{cfg: cfg, stop: make(chan struct{})}
+	m.ports[name] = mp
+	m.wg.Add(1)
+	go m.supervise(name, mp)
+
+	return nil
+}
+
+// Unregister removes the serial port name from the Manager and closes it.
+func (m *Manager) Unregister(name string) {
+	m.mu.Lock()
+	mp, ok := m.ports[name]
+	if ok {
+		delete(m.ports, name)
+		close(mp.stop)
+	}
+	m.mu.Unlock()
+}
+
+// Get returns the serial port name if it is registered and currently open.
+func (m *Manager) Get(name string) (*SerialPort, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mp, ok := m.ports[name]
+	if !ok || mp.sp == nil {
+		return nil, false
+	}
+	return mp.sp, true
+}
+
+// CloseAll unregisters and closes every serial port, then closes the Events channel.
+// The Manager cannot be used afterwards.
+func (m *Manager) CloseAll() {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return
+	}
+	m.closed = true
+	for name, mp := range m.ports {
+		delete(m.ports, name)
+		close(mp.stop)
+	}
+	m.mu.Unlock()
+
+	m.wg.Wait()
+	close(m.events)
+}
+
+func (m *Manager) supervise(name string, mp *managedPort) {
+	defer m.wg.Done()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		m.check(name, mp)
+
+		m.mu.Lock()
+		sp := mp.sp
+		m.mu.Unlock()
+		var closed <-chan struct{}
+		if sp != nil {
+			closed = sp.closeDone()
+		}
+
+		select {
+		case <-closed:
+			// Closed by the user: give up on the port rather than reopen it.
+			m.mu.Lock()
+			mp.sp = nil
+			if m.ports[name] == mp {
+				delete(m.ports, name)
+			}
+			m.mu.Unlock()
+			m.emit(ManagerEvent{Name: name, Port: sp, Err: ErrClosed})
+			return
+		case <-mp.stop:
+			m.mu.Lock()
+			sp := mp.sp
+			mp.sp = nil
+			m.mu.Unlock()
+			if sp != nil {
+				sp.Close()
+			}
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check opens the port if it is missing, or closes it if it is no longer usable.
+func (m *Manager) check(name string, mp *managedPort) {
+	m.mu.Lock()
+	sp := mp.sp
+	m.mu.Unlock()
+
+	if sp == nil {
+		sp, err := Open(name, mp.cfg)
+		if err != nil {
+			return
+		}
+		m.mu.Lock()
+		mp.sp = sp
+		m.mu.Unlock()
+		m.emit(ManagerEvent{Name: name, Port: sp, Connected: true})
+		return
+	}
+
+	if !sp.acquire() {
+		// Closed by the user, which supervise handles.
+		return
+	}
+	err := sp.probe()
+	sp.release()
+	if err != nil {
+		m.mu.Lock()
+		mp.sp = nil
+		m.mu.Unlock()
+		sp.Close()
+		m.emit(ManagerEvent{Name: name, Port: sp, Err: err})
+	}
+}
+
+func (m *Manager) emit(ev ManagerEvent) {
+	select {
+	case m.events <- ev:
+	default:
+	}
+}
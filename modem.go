@@ -0,0 +1,42 @@
+package serialport
+
+import "strings"
+
+// ModemBits is a snapshot of the input modem status lines of a serial port.
+type ModemBits uint8
+
+const (
+	ModemCTS ModemBits = 1 << iota // Clear To Send
+	ModemDSR                       // Data Set Ready
+	ModemRI                        // Ring Indicator
+	ModemDCD                       // Data Carrier Detect (RLSD on Windows)
+)
+
+// CTS reports whether Clear To Send is asserted.
+func (m ModemBits) CTS() bool { return m&ModemCTS != 0 }
+
+// DSR reports whether Data Set Ready is asserted.
+func (m ModemBits) DSR() bool { return m&ModemDSR != 0 }
+
+// RI reports whether Ring Indicator is asserted.
+func (m ModemBits) RI() bool { return m&ModemRI != 0 }
+
+// DCD reports whether Data Carrier Detect is asserted.
+func (m ModemBits) DCD() bool { return m&ModemDCD != 0 }
+
+// String returns the asserted lines, e.g. "CTS|DCD", or "none".
+func (m ModemBits) String() string {
+	var lines []string
+	for _, l := range []struct {
+		bit  ModemBits
+		name string
+	}{{ModemCTS, "CTS"}, {ModemDSR, "DSR"}, {ModemRI, "RI"}, {ModemDCD, "DCD"}} {
+		if m&l.bit != 0 {
+			lines = append(lines, l.name)
+		}
+	}
+	if len(lines) == 0 {
+		return "none"
+	}
+	return strings.Join(lines, "|")
+}
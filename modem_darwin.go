@@ -0,0 +1,116 @@
+package serialport
+
+import (
+	"context"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// modemPollInterval is how often WatchModemLines and WaitForModemChange sample the modem status lines.
+const modemPollInterval = 50 * time.Millisecond
+
+// ModemStatus returns the current state of the input modem status lines.
+func (sp *SerialPort) ModemStatus() (ModemBits, error) {
+	bits, err := unix.IoctlGetInt(sp.fd, unix.TIOCMGET)
+	if err != nil {
+		return 0, err
+	}
+	return decodeModemBits(bits), nil
+}
+
+// decodeModemBits translates TIOCMGET bits to ModemBits.
+func decodeModemBits(bits int) (m ModemBits) {
+	if bits&unix.TIOCM_CTS != 0 {
+		m |= ModemCTS
+	}
+	if bits&unix.TIOCM_DSR != 0 {
+		m |= ModemDSR
+	}
+	if bits&unix.TIOCM_RNG != 0 {
+		m |= ModemRI
+	}
+	if bits&unix.TIOCM_CAR != 0 {
+		m |= ModemDCD
+	}
+	return
+}
+
+// WatchModemLines sends a ModemStatus snapshot on the returned channel each time CTS, DSR, RI or DCD changes,
+// until ctx is cancelled or the serial port is closed, at which point the channel is closed.
+// The channel is also closed if the port fails.
+// Snapshots are dropped, not queued, while the receiver is busy; the next one sent is always up to date.
+//
+// The lines are sampled every 50 ms, as macOS has no TIOCMIWAIT to wait for a change.
+// A change shorter than that, like a ring pulse, can be missed.
+func (sp *SerialPort) WatchModemLines(ctx context.Context) (<-chan ModemBits, error) {
+	status, err := sp.ModemStatus()
+	if err != nil {
+		return nil, err
+	}
+	if !sp.acquire() {
+		return nil, ErrClosed
+	}
+
+	ch := make(chan ModemBits, 1)
+	go func() {
+		defer sp.release()
+		defer close(ch)
+
+		for {
+			if status, err = sp.waitModemLines(ctx, status); err != nil {
+				return
+			}
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- status
+		}
+	}()
+
+	return ch, nil
+}
+
+// WaitForModemChange blocks until CTS, DSR, RI or DCD changes and returns the new state of the lines,
+// or an error once ctx is done or the serial port is closed.
+// Like WatchModemLines it samples the lines every 50 ms, as macOS has no TIOCMIWAIT,
+// so a shorter pulse can be missed.
+func (sp *SerialPort) WaitForModemChange(ctx context.Context) (ModemBits, error) {
+	if !sp.acquire() {
+		return 0, ErrClosed
+	}
+	defer sp.release()
+	status, err := sp.ModemStatus()
+	if err != nil {
+		return 0, err
+	}
+	if status, err = sp.waitModemLines(ctx, status); err != nil {
+		return 0, err
+	}
+	return status, nil
+}
+
+// waitModemLines samples the lines every modemPollInterval until they differ from status,
+// and returns the new status. The caller must hold acquire.
+func (sp *SerialPort) waitModemLines(ctx context.Context, status ModemBits) (ModemBits, error) {
+	ticker := time.NewTicker(modemPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return status, ctx.Err()
+		case <-sp.closeDone():
+			return status, ErrClosed
+		case <-ticker.C:
+		}
+
+		next, err := sp.ModemStatus()
+		if err != nil {
+			return status, err
+		}
+		if next != status {
+			return next, nil
+		}
+	}
+}
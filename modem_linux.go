@@ -0,0 +1,146 @@
+package serialport
+
+import (
+	"context"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// modemPollInterval is how often WatchModemLines and WaitForModemChange sample the modem status lines.
+const modemPollInterval = 50 * time.Millisecond
+
+// getModemBits reads the modem lines of fd with TIOCMGET; a variable so that tests can fake lines on a pty.
+var getModemBits = func(fd int) (int, error) {
+	return unix.IoctlGetInt(fd, unix.TIOCMGET)
+}
+
+// ModemStatus returns the current state of the input modem status lines.
+func (sp *SerialPort) ModemStatus() (ModemBits, error) {
+	bits, err := getModemBits(sp.fd)
+	if err != nil {
+		return 0, err
+	}
+	return decodeModemBits(bits), nil
+}
+
+// decodeModemBits translates TIOCMGET bits to ModemBits.
+func decodeModemBits(bits int) (m ModemBits) {
+	if bits&unix.TIOCM_CTS != 0 {
+		m |= ModemCTS
+	}
+	if bits&unix.TIOCM_DSR != 0 {
+		m |= ModemDSR
+	}
+	if bits&unix.TIOCM_RNG != 0 {
+		m |= ModemRI
+	}
+	if bits&unix.TIOCM_CAR != 0 {
+		m |= ModemDCD
+	}
+	return
+}
+
+// WatchModemLines sends a ModemStatus snapshot on the returned channel each time CTS, DSR, RI or DCD changes,
+// until ctx is cancelled or the serial port is closed, at which point the channel is closed.
+// The channel is also closed if the port fails.
+// Snapshots are dropped, not queued, while the receiver is busy; the next one sent is always up to date.
+//
+// The lines are sampled every 50 ms. TIOCMIWAIT would wake up on the change itself, but it cannot be
+// interrupted, which would keep the device open after Close. Drivers with TIOCGICOUNT count every
+// transition, so a change shorter than the sampling interval, like a ring pulse, is still reported.
+func (sp *SerialPort) WatchModemLines(ctx context.Context) (<-chan ModemBits, error) {
+	status, err := sp.ModemStatus()
+	if err != nil {
+		return nil, err
+	}
+	if !sp.acquire() {
+		return nil, ErrClosed
+	}
+	counts, cerr := sp.modemCounts()
+
+	ch := make(chan ModemBits, 1)
+	go func() {
+		defer sp.release()
+		defer close(ch)
+
+		for {
+			status, counts, err = sp.waitModemLines(ctx, status, counts, cerr)
+			if err != nil {
+				return
+			}
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- status
+		}
+	}()
+
+	return ch, nil
+}
+
+// WaitForModemChange blocks until CTS, DSR, RI or DCD changes and returns the new state of the lines,
+// or an error once ctx is done or the serial port is closed. Drivers with TIOCGICOUNT report a pulse too,
+// in which case the returned state can be the same as before the call.
+//
+// Like WatchModemLines it samples the lines every 50 ms instead of waiting in TIOCMIWAIT,
+// which neither ctx nor Close could interrupt.
+func (sp *SerialPort) WaitForModemChange(ctx context.Context) (ModemBits, error) {
+	if !sp.acquire() {
+		return 0, ErrClosed
+	}
+	defer sp.release()
+	status, err := sp.ModemStatus()
+	if err != nil {
+		return 0, err
+	}
+	counts, cerr := sp.modemCounts()
+
+	if status, _, err = sp.waitModemLines(ctx, status, counts, cerr); err != nil {
+		return 0, err
+	}
+	return status, nil
+}
+
+// waitModemLines samples the lines every modemPollInterval until they differ from status, or the transitions
+// counted by TIOCGICOUNT differ from counts unless cerr is not nil, and returns the new status and counts.
+// It returns early once ctx is done or the serial port is closed. The caller must hold acquire.
+func (sp *SerialPort) waitModemLines(ctx context.Context, status ModemBits, counts [4]int32, cerr error) (ModemBits, [4]int32, error) {
+	ticker := time.NewTicker(modemPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return status, counts, ctx.Err()
+		case <-sp.closeDone():
+			return status, counts, ErrClosed
+		case <-ticker.C:
+		}
+
+		next, err := sp.ModemStatus()
+		if err != nil {
+			return status, counts, err
+		}
+		changed := next != status
+		if cerr == nil {
+			if c, err := sp.modemCounts(); err == nil && c != counts {
+				counts = c
+				changed = true
+			}
+		}
+		if changed {
+			return next, counts, nil
+		}
+	}
+}
+
+// modemCounts returns the number of transitions of CTS, DSR, RI and DCD counted by the driver.
+func (sp *SerialPort) modemCounts() ([4]int32, error) {
+	var ic serialIcounter
+	if err := ioctlPtr(sp.fd, unix.TIOCGICOUNT, unsafe.Pointer(&ic)); err != nil {
+		return [4]int32{}, err
+	}
+	return [4]int32{ic.CTS, ic.DSR, ic.RNG, ic.DCD}, nil
+}
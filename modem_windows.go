@@ -0,0 +1,206 @@
+package serialport
+
+import (
+	"context"
+	"syscall"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+// Reference https://docs.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-setcommmask
+const (
+	win32EV_CTS  = 0x0008
+	win32EV_DSR  = 0x0010
+	win32EV_RLSD = 0x0020
+	win32EV_RING = 0x0100
+)
+
+// Reference https://docs.microsoft.com/en-us/windows/win32/api/winbase/nf-winbase-getcommmodemstatus
+const (
+	win32MS_CTS_ON  = 0x0010
+	win32MS_DSR_ON  = 0x0020
+	win32MS_RING_ON = 0x0040
+	win32MS_RLSD_ON = 0x0080
+)
+
+var (
+	procSetCommMask        = modkernel32.NewProc("SetCommMask")
+	procWaitCommEvent      = modkernel32.NewProc("WaitCommEvent")
+	procGetCommModemStatus = modkernel32.NewProc("GetCommModemStatus")
+)
+
+func win32SetCommMask(handle windows.Handle, mask uint32) error {
+	r1, _, err := syscall.Syscall(procSetCommMask.Addr(), 2, uintptr(handle), uintptr(mask), 0)
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+func win32WaitCommEvent(handle windows.Handle, mask *uint32, overlapped *windows.Overlapped) error {
+	r1, _, err := syscall.Syscall(procWaitCommEvent.Addr(), 3, uintptr(handle), uintptr(unsafe.Pointer(mask)), uintptr(unsafe.Pointer(overlapped)))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+func win32GetCommModemStatus(handle windows.Handle, status *uint32) error {
+	r1, _, err := syscall.Syscall(procGetCommModemStatus.Addr(), 2, uintptr(handle), uintptr(unsafe.Pointer(status)), 0)
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+// ModemStatus returns the current state of the input modem status lines.
+func (sp *SerialPort) ModemStatus() (ModemBits, error) {
+	if !sp.acquire() {
+		return 0, ErrClosed
+	}
+	defer sp.release()
+	return sp.modemStatus()
+}
+
+// modemStatus is ModemStatus for callers that hold acquire.
+func (sp *SerialPort) modemStatus() (ModemBits, error) {
+	var status uint32
+	if err := win32GetCommModemStatus(sp.handle, &status); err != nil {
+		return 0, err
+	}
+	return decodeModemBits(status), nil
+}
+
+// decodeModemBits translates GetCommModemStatus flags to ModemBits.
+func decodeModemBits(status uint32) (m ModemBits) {
+	if status&win32MS_CTS_ON != 0 {
+		m |= ModemCTS
+	}
+	if status&win32MS_DSR_ON != 0 {
+		m |= ModemDSR
+	}
+	if status&win32MS_RING_ON != 0 {
+		m |= ModemRI
+	}
+	if status&win32MS_RLSD_ON != 0 {
+		m |= ModemDCD
+	}
+	return
+}
+
+// WatchModemLines sends a ModemStatus snapshot on the returned channel each time CTS, DSR, RI or DCD changes,
+// until ctx is cancelled or the serial port is closed, at which point the channel is closed.
+// The channel is also closed if the port fails or is reopened.
+// Snapshots are dropped, not queued, while the receiver is busy; the next one sent is always up to date.
+//
+// Changes are waited for with WaitCommEvent, of which Windows allows only one per port,
+// so there must be at most one watcher per SerialPort.
+func (sp *SerialPort) WatchModemLines(ctx context.Context) (<-chan ModemBits, error) {
+	status, err := sp.ModemStatus()
+	if err != nil {
+		return nil, err
+	}
+	if !sp.acquire() {
+		return nil, ErrClosed
+	}
+	if err := win32SetCommMask(sp.handle, win32EV_CTS|win32EV_DSR|win32EV_RLSD|win32EV_RING); err != nil {
+		sp.release()
+		return nil, err
+	}
+
+	ch := make(chan ModemBits, 1)
+	go func() {
+		defer sp.release()
+		defer close(ch)
+		for {
+			if err := sp.waitModemChange(ctx); err != nil {
+				return
+			}
+			next, err := sp.modemStatus()
+			if err != nil {
+				return
+			}
+			if next == status {
+				continue
+			}
+			status = next
+			select {
+			case <-ch:
+			default:
+			}
+			ch <- status
+		}
+	}()
+
+	return ch, nil
+}
+
+// WaitForModemChange blocks until CTS, DSR, RI or DCD changes and returns the new state of the lines,
+// or an error once ctx is done or the serial port is closed.
+// It waits with WaitCommEvent for EV_CTS, EV_DSR, EV_RING or EV_RLSD, so a pulse is reported too,
+// in which case the returned state can be the same as before the call.
+// Windows allows only one WaitCommEvent per port: do not call it while WatchModemLines is running.
+func (sp *SerialPort) WaitForModemChange(ctx context.Context) (ModemBits, error) {
+	if !sp.acquire() {
+		return 0, ErrClosed
+	}
+	defer sp.release()
+	if err := win32SetCommMask(sp.handle, win32EV_CTS|win32EV_DSR|win32EV_RLSD|win32EV_RING); err != nil {
+		return 0, err
+	}
+	if err := sp.waitModemChange(ctx); err != nil {
+		return 0, err
+	}
+	return sp.modemStatus()
+}
+
+// A commEvent is a WaitCommEvent in progress. It is shared with the goroutine waiting for the result,
+// which keeps it on the heap together with the mask the kernel writes on completion.
+type commEvent struct {
+	ov   *windows.Overlapped
+	mask uint32
+}
+
+// waitModemChange blocks until one of the events set with SetCommMask occurs,
+// ctx is done, the serial port is closed or Reopen cancels the wait.
+func (sp *SerialPort) waitModemChange(ctx context.Context) error {
+	ov, err := newOverlapped()
+	if err != nil {
+		return err
+	}
+	defer windows.CloseHandle(ov.HEvent)
+
+	// The kernel writes the mask when the wait completes, after WaitCommEvent has returned,
+	// so it must not live on this goroutine's stack, which can move while it is parked below.
+	ev := &commEvent{ov: ov}
+	// Start the wait under lmu, so that a Reopen cancelling pending I/O does not miss it.
+	sp.lmu.Lock()
+	if sp.isPausedLocked() {
+		err = windows.ERROR_OPERATION_ABORTED
+	} else {
+		err = win32WaitCommEvent(sp.handle, &ev.mask, ev.ov)
+	}
+	sp.lmu.Unlock()
+	if err != windows.ERROR_IO_PENDING {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		var n uint32
+		done <- windows.GetOverlappedResult(sp.handle, ev.ov, &n, true)
+	}()
+	select {
+	case err = <-done:
+		return err
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-sp.closeDone():
+		err = ErrClosed
+	}
+	// The wait must be over before ov can be released.
+	windows.CancelIoEx(sp.handle, ov)
+	<-done
+	return err
+}
@@ -0,0 +1,36 @@
+package serialport
+
+import "time"
+
+// An Option changes the Config that OpenWithOptions opens a serial port with.
+type Option func(*Config)
+
+// OpenWithOptions opens a serial port with DefaultConfig changed by opts, applied in order,
+// e.g. OpenWithOptions(name, WithBaud(BR9600), WithParity(PE)).
+func OpenWithOptions(name string, opts ...Option) (*SerialPort, error) {
+	cfg := DefaultConfig()
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	return Open(name, cfg)
+}
+
+// WithBaud sets Config.BaudRate.
+func WithBaud(baud int) Option {
+	return func(cfg *Config) { cfg.BaudRate = baud }
+}
+
+// WithParity sets Config.Parity.
+func WithParity(parity Parity) Option {
+	return func(cfg *Config) { cfg.Parity = parity }
+}
+
+// WithTimeout sets Config.Timeout, the Read timeout.
+func WithTimeout(timeout time.Duration) Option {
+	return func(cfg *Config) { cfg.Timeout = timeout }
+}
+
+// WithFlowControl sets Config.FlowControl to FlowNone, FlowHardware or FlowSoftware.
+func WithFlowControl(flowControl int) Option {
+	return func(cfg *Config) { cfg.FlowControl = flowControl }
+}
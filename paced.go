@@ -0,0 +1,66 @@
+package serialport
+
+import (
+	"io"
+	"time"
+)
+
+// pacedWriter forwards writes to a serial port, keeping at least gap between
+// consecutive units. A unit is a single byte, or a whole Write call if perWrite is set.
+type pacedWriter struct {
+	w        io.Writer // the serial port
+	gap      time.Duration
+	perWrite bool
+	last     time.Time
+}
+
+// PacedWriter returns an io.Writer that forwards to sp one byte at a time,
+// waiting at least gap between consecutive bytes.
+// It is intended for slow devices (relay boards, character LCDs, etc.) that cannot keep up with back-to-back data.
+func PacedWriter(sp *SerialPort, gap time.Duration) io.Writer {
+	return &pacedWriter{w: sp, gap: gap}
+}
+
+// PacedCallWriter returns an io.Writer that forwards each Write call to sp unchanged,
+// waiting at least gap between consecutive calls.
+func PacedCallWriter(sp *SerialPort, gap time.Duration) io.Writer {
+	return &pacedWriter{w: sp, gap: gap, perWrite: true}
+}
+
+func (pw *pacedWriter) Write(b []byte) (n int, err error) {
+	if pw.perWrite {
+		pw.wait()
+		n, err = pw.w.Write(b)
+		pw.last = time.Now()
+		if err == nil && n < len(b) {
+			err = io.ErrShortWrite
+		}
+		return
+	}
+
+	for n < len(b) {
+		pw.wait()
+		var m int
+		m, err = pw.w.Write(b[n : n+1])
+		pw.last = time.Now()
+		n += m
+		if err != nil {
+			return
+		}
+		if m == 0 {
+			return n, io.ErrShortWrite
+		}
+	}
+
+	return
+}
+
+// wait sleeps until gap has elapsed since the last unit was written.
+func (pw *pacedWriter) wait() {
+	if pw.last.IsZero() {
+		return
+	}
+	if d := pw.gap - time.Since(pw.last); d > 0 {
+		time.Sleep(d)
+	}
+}
@@ -0,0 +1,125 @@
+package serialport
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// A Framing describes how a PacketPort delimits messages in the byte stream.
+type Framing struct {
+	// Encode returns msg as it is sent on the wire.
+	Encode func(msg []byte) ([]byte, error)
+	// Split extracts one message from the received stream, see FrameAssembler.
+	Split bufio.SplitFunc
+}
+
+// DelimiterFraming frames each message by appending delim, which must not occur in the message.
+func DelimiterFraming(delim byte) Framing {
+	return Framing{
+		Encode: func(msg []byte) ([]byte, error) {
+			if bytes.IndexByte(msg, delim) >= 0 {
+				return nil, fmt.Errorf("serialport: message contains the delimiter %#02x", delim)
+			}
+			return append(append([]byte(nil), msg...), delim), nil
+		},
+		Split: DelimiterSplit(delim),
+	}
+}
+
+// LengthPrefixFraming frames each message with its length as a 2-byte big-endian prefix,
+// so messages may contain any byte but are limited to 65535 bytes.
+func LengthPrefixFraming() Framing {
+	return Framing{
+		Encode: func(msg []byte) ([]byte, error) {
+			if len(msg) > 0xffff {
+				return nil, fmt.Errorf("serialport: message too long for a 2-byte length prefix %v", len(msg))
+			}
+			frame := make([]byte, 2+len(msg))
+			binary.BigEndian.PutUint16(frame, uint16(len(msg)))
+			copy(frame[2:], msg)
+			return frame, nil
+		},
+		Split: func(data []byte, atEOF bool) (advance int, token []byte, err error) {
+			if len(data) < 2 {
+				return 0, nil, nil
+			}
+			n := 2 + int(binary.BigEndian.Uint16(data))
+			if len(data) < n {
+				return 0, nil, nil
+			}
+			return n, data[2:n], nil
+		},
+	}
+}
+
+// A PacketPort exchanges whole messages over a SerialPort: each WriteMessage sends one framed message
+// and each ReadMessage returns exactly one, like a datagram socket.
+// The SerialPort should not be read directly while a PacketPort is in use,
+// since a partial message read past by one would be lost to the other.
+type PacketPort struct {
+	sp      *SerialPort
+	framing Framing
+
+	mu      sync.Mutex // guards fa and pending
+	fa      *FrameAssembler
+	pending [][]byte
+}
+
+// NewPacketPort returns a PacketPort that exchanges messages over sp using framing.
+func NewPacketPort(sp *SerialPort, framing Framing) *PacketPort {
+	return &PacketPort{sp: sp, framing: framing, fa: NewFrameAssembler(framing.Split)}
+}
+
+// WriteMessage frames msg and writes it to the serial port.
+func (pp *PacketPort) WriteMessage(msg []byte) error {
+	frame, err := pp.framing.Encode(msg)
+	if err != nil {
+		return err
+	}
+	n, err := pp.sp.Write(frame)
+	if err == nil && n < len(frame) {
+		err = io.ErrShortWrite
+	}
+	return err
+}
+
+// ReadMessage returns the next message, without its framing, within the configured Timeout
+// (no limit if Timeout is 0). On timeout it returns ErrTimeout and keeps any partial message.
+func (pp *PacketPort) ReadMessage() ([]byte, error) {
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+
+	deadline := deadlineAfter(pp.sp.readTimeoutBudget())
+	buf := make([]byte, 256)
+	for {
+		if len(pp.pending) == 0 {
+			pp.pending = pp.fa.Frames()
+		}
+		if len(pp.pending) > 0 {
+			msg := pp.pending[0]
+			pp.pending = pp.pending[1:]
+			return msg, nil
+		}
+
+		timeout := remaining(deadline)
+		if timeout == 0 {
+			return nil, ErrTimeout
+		}
+		pp.sp.rmu.Lock()
+		n := pp.sp.takeBuffered(buf)
+		var err error
+		if n == 0 {
+			n, err = pp.sp.readTimeout(buf, timeout)
+		}
+		pp.sp.rmu.Unlock()
+
+		pp.fa.Feed(buf[:n])
+		if err != nil && err != ErrTimeout {
+			return nil, err
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package serialport
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestFramingRoundTrip(t *testing.T) {
+	msgs := [][]byte{[]byte("hello"), {}, {0x00, '\n', 0xff}}
+
+	for name, framing := range map[string]Framing{
+		"LengthPrefix": LengthPrefixFraming(),
+		"Delimiter":    DelimiterFraming(0x7e),
+	} {
+		fa := NewFrameAssembler(framing.Split)
+		for _, msg := range msgs {
+			frame, err := framing.Encode(msg)
+			if err != nil {
+				t.Fatalf("%v: Encode(%q): %v", name, msg, err)
+			}
+			// Feed a byte at a time, as a slow link would deliver it.
+			for i := range frame {
+				fa.Feed(frame[i : i+1])
+			}
+		}
+		frames := fa.Frames()
+		if len(frames) != len(msgs) {
+			t.Fatalf("%v: Frames() = %q, want %q", name, frames, msgs)
+		}
+		for i := range msgs {
+			if !bytes.Equal(frames[i], msgs[i]) {
+				t.Fatalf("%v: Frames() = %q, want %q", name, frames, msgs)
+			}
+		}
+	}
+}
+
+func TestDelimiterFramingRejectsDelimiter(t *testing.T) {
+	if _, err := DelimiterFraming('\n').Encode([]byte("a\nb")); err == nil {
+		t.Fatal("Encode of a message containing the delimiter succeeded")
+	}
+}
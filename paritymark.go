@@ -0,0 +1,40 @@
+package serialport
+
+// A MarkedByte is a received byte as decoded by DecodeParityMarks.
+type MarkedByte struct {
+	Value       byte
+	ParityError bool // received with a parity or framing error; a break reads as a 0 with ParityError set
+}
+
+// DecodeParityMarks decodes data read with Config.MarkParityErrors, in which the driver sends
+// a character received with an error as 0xFF 0x00 c, and a valid 0xFF as 0xFF 0xFF.
+// A sequence cut off at the end of b is returned in rest, to be prepended to the next read.
+func DecodeParityMarks(b []byte) (out []MarkedByte, rest []byte) {
+	out = make([]MarkedByte, 0, len(b))
+	for i := 0; i < len(b); {
+		if b[i] != 0xff {
+			out = append(out, MarkedByte{Value: b[i]})
+			i++
+			continue
+		}
+		if i+1 == len(b) {
+			return out, b[i:]
+		}
+		switch b[i+1] {
+		case 0xff:
+			out = append(out, MarkedByte{Value: 0xff})
+			i += 2
+		case 0x00:
+			if i+2 == len(b) {
+				return out, b[i:]
+			}
+			out = append(out, MarkedByte{Value: b[i+2], ParityError: true})
+			i += 3
+		default:
+			// Not a sequence the driver sends, e.g. data read before marking was enabled.
+			out = append(out, MarkedByte{Value: 0xff})
+			i++
+		}
+	}
+	return out, nil
+}
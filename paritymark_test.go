@@ -0,0 +1,27 @@
+package serialport
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeParityMarks(t *testing.T) {
+	for _, tt := range []struct {
+		in   []byte
+		out  []MarkedByte
+		rest []byte
+	}{
+		{[]byte("ab"), []MarkedByte{{Value: 'a'}, {Value: 'b'}}, nil},
+		{[]byte{'a', 0xff, 0xff, 'b'}, []MarkedByte{{Value: 'a'}, {Value: 0xff}, {Value: 'b'}}, nil},
+		{[]byte{0xff, 0x00, 'x', 'y'}, []MarkedByte{{Value: 'x', ParityError: true}, {Value: 'y'}}, nil},
+		{[]byte{0xff, 0x00, 0x00}, []MarkedByte{{Value: 0, ParityError: true}}, nil},
+		{[]byte{'a', 0xff}, []MarkedByte{{Value: 'a'}}, []byte{0xff}},
+		{[]byte{'a', 0xff, 0x00}, []MarkedByte{{Value: 'a'}}, []byte{0xff, 0x00}},
+		{[]byte{0xff, 'a'}, []MarkedByte{{Value: 0xff}, {Value: 'a'}}, nil},
+	} {
+		out, rest := DecodeParityMarks(tt.in)
+		if !reflect.DeepEqual(out, tt.out) || !reflect.DeepEqual(rest, tt.rest) {
+			t.Errorf("DecodeParityMarks(%x) = %v, %x; want %v, %x", tt.in, out, rest, tt.out, tt.rest)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package serialport
+
+import (
+	"io"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// SetPollMode switches the serial port in or out of poll mode. In poll mode the fd is non-blocking
+// and every read and write waits in poll(2) with the exact Config.Timeout and Config.WriteTimeout,
+// instead of relying on VMIN and VTIME, whose timeout has a granularity of 100 ms.
+// SetReadParams has no effect in poll mode.
+func (sp *SerialPort) SetPollMode(on bool) error {
+	flags, err := unix.FcntlInt(uintptr(sp.fd), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	if on {
+		flags |= unix.O_NONBLOCK
+	} else {
+		flags &^= unix.O_NONBLOCK
+	}
+	if _, err = unix.FcntlInt(uintptr(sp.fd), unix.F_SETFL, flags); err != nil {
+		return err
+	}
+
+	sp.cmu.Lock()
+	sp.poll = on
+	sp.cmu.Unlock()
+	return nil
+}
+
+// pollMode reports whether SetPollMode is in effect.
+func (sp *SerialPort) pollMode() bool {
+	sp.cmu.Lock()
+	defer sp.cmu.Unlock()
+	return sp.poll
+}
+
+// readPoll waits up to timeout for data, then reads up to len(b) bytes from the non-blocking fd.
+// It returns ErrTimeout if no data arrives in time. A negative timeout waits forever.
+func (sp *SerialPort) readPoll(b []byte, timeout time.Duration) (n int, err error) {
+	deadline := deadlineAfter(timeout)
+	for {
+		if err = sp.waitIO(unix.POLLIN, remaining(deadline)); err != nil {
+			return
+		}
+		n, err = unix.Read(sp.fd, b)
+		if err == unix.EAGAIN {
+			continue
+		}
+		if (n == 0 && err == nil) || err == unix.EIO {
+			return 0, io.EOF
+		}
+		if n < 0 {
+			n = 0
+		}
+		return
+	}
+}
+
+// writePoll writes len(b) bytes to the non-blocking fd, waiting for room in the output buffer
+// for up to timeout in total. It returns ErrTimeout if not everything was written in time.
+// A timeout <= 0 waits forever.
+func (sp *SerialPort) writePoll(b []byte, timeout time.Duration) (n int, err error) {
+	if timeout <= 0 {
+		timeout = -1
+	}
+	deadline := deadlineAfter(timeout)
+	for n < len(b) {
+		if err = sp.waitIO(unix.POLLOUT, remaining(deadline)); err != nil {
+			return
+		}
+		var m int
+		m, err = unix.Write(sp.fd, b[n:])
+		if err == unix.EAGAIN {
+			continue
+		}
+		if err != nil {
+			return
+		}
+		n += m
+	}
+	return
+}
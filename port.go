@@ -0,0 +1,21 @@
+package serialport
+
+import "io"
+
+// Port is the interface shared by local serial ports and remote ones such as DialRFC2217,
+// so that the same framing and protocol code works against either.
+type Port interface {
+	io.ReadWriteCloser
+	// Config returns the configuration of the port.
+	Config() (Config, error)
+	// SetConfig sets the port according to cfg.
+	SetConfig(cfg Config) error
+	// Flush discards data received but not read, and data written but not transmitted.
+	Flush() error
+}
+
+var (
+	_ io.ReadWriteCloser = (*SerialPort)(nil)
+	_ io.StringWriter    = (*SerialPort)(nil)
+	_ Port               = (*SerialPort)(nil)
+)
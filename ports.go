@@ -0,0 +1,108 @@
+package serialport
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// PortInfo describes a serial port found by ListPorts.
+// The USB fields are only set for USB adapters, and the strings only if the device provides them.
+type PortInfo struct {
+	Name         string // name to pass to Open, e.g. /dev/ttyUSB0 or COM3
+	IsUSB        bool   // whether the port is provided by a USB device
+	VID          uint16 // USB vendor ID
+	PID          uint16 // USB product ID
+	Manufacturer string // USB iManufacturer string
+	Product      string // USB iProduct string
+	SerialNumber string // USB iSerialNumber string
+	IsVirtual    bool   // whether the port is a pseudo-terminal or another tty without hardware
+}
+
+// ListPortsOptions selects the ports ListPortsWithOptions returns in addition to those backed by hardware,
+// e.g. for test setups and virtual links. Windows lists virtual ports registered as COM ports
+// (e.g. com0com) like any other, so the options only matter on Linux.
+type ListPortsOptions struct {
+	IncludeVirtual bool // ttys without a device, like the tty0tty null-modem pairs (Linux)
+	IncludePTY     bool // pseudo-terminals under /dev/pts, e.g. those created by socat (Linux)
+}
+
+// FindBySerial returns the name of the serial port of the USB adapter with the given serial number,
+// which addresses one physical adapter however many identical ones are connected.
+func FindBySerial(serial string) (string, error) {
+	ports, err := ListPorts()
+	if err != nil {
+		return "", err
+	}
+	for _, p := range ports {
+		if p.IsUSB && p.SerialNumber == serial {
+			return p.Name, nil
+		}
+	}
+	return "", fmt.Errorf("serialport: no port with serial number %q", serial)
+}
+
+// OpenMatch opens the only serial port listed by ListPorts whose name matches pattern, e.g. "ttyUSB*"
+// or "usbserial|ttyUSB", so that tools need not hardcode the device names of each platform.
+// pattern is tried as a glob (see path.Match) against both the whole name and its last element, then,
+// if no name matches, as a regular expression anywhere in the name.
+// If no port or several match, the error lists the candidates.
+func OpenMatch(pattern string, cfg Config) (*SerialPort, error) {
+	ports, err := ListPorts()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(ports))
+	for i, p := range ports {
+		names[i] = p.Name
+	}
+
+	matched, err := matchPorts(names, pattern)
+	if err != nil {
+		return nil, err
+	}
+	switch len(matched) {
+	case 0:
+		return nil, fmt.Errorf("serialport: no port matches %q, candidates: %v", pattern, strings.Join(names, ", "))
+	case 1:
+		return Open(matched[0], cfg)
+	}
+	return nil, fmt.Errorf("serialport: %v ports match %q: %v", len(matched), pattern, strings.Join(matched, ", "))
+}
+
+// matchPorts returns the names that match pattern, as described for OpenMatch.
+func matchPorts(names []string, pattern string) ([]string, error) {
+	_, globErr := path.Match(pattern, "")
+	re, reErr := regexp.Compile(pattern)
+	if globErr != nil && reErr != nil {
+		return nil, fmt.Errorf("serialport: invalid port pattern %q: %v", pattern, reErr)
+	}
+
+	var matched []string
+	if globErr == nil {
+		for _, name := range names {
+			base := name[strings.LastIndexAny(name, `/\`)+1:]
+			if ok, _ := path.Match(pattern, name); ok {
+				matched = append(matched, name)
+			} else if ok, _ := path.Match(pattern, base); ok {
+				matched = append(matched, name)
+			}
+		}
+	}
+	if len(matched) > 0 || reErr != nil {
+		return matched, nil
+	}
+	for _, name := range names {
+		if re.MatchString(name) {
+			matched = append(matched, name)
+		}
+	}
+	return matched, nil
+}
+
+// sortPorts sorts ports by name, so that ListPorts is stable.
+func sortPorts(ports []PortInfo) {
+	sort.Slice(ports, func(i, j int) bool { return ports[i].Name < ports[j].Name })
+}
@@ -0,0 +1,58 @@
+package serialport
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// devDir is where the device nodes of the ttys are created.
+var devDir = "/dev"
+
+// ListPorts returns the call-out devices (/dev/cu.*) of the serial ports, sorted by name.
+// Each port also has a dial-in device (/dev/tty.*), whose open waits for DCD; only the call-out one is listed.
+// The USB fields are not filled in, reading them requires IOKit.
+func ListPorts() ([]PortInfo, error) {
+	return ListPortsWithOptions(ListPortsOptions{})
+}
+
+// ListPortsWithOptions is like ListPorts, adding the pseudo-terminals (/dev/ttys*) if opts.IncludePTY is set.
+// macOS has no virtual ttys other than pseudo-terminals.
+func ListPortsWithOptions(opts ListPortsOptions) ([]PortInfo, error) {
+	entries, err := os.ReadDir(devDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []PortInfo
+	for _, e := range entries {
+		name := e.Name()
+		switch {
+		case strings.HasPrefix(name, "cu."):
+			ports = append(ports, PortInfo{Name: filepath.Join(devDir, name)})
+		case opts.IncludePTY && isPTY(name):
+			ports = append(ports, PortInfo{Name: filepath.Join(devDir, name), IsVirtual: true})
+		}
+	}
+	sortPorts(ports)
+
+	return ports, nil
+}
+
+// isPTY reports whether name is a pseudo-terminal slave, ttys followed by its number.
+func isPTY(name string) bool {
+	if !strings.HasPrefix(name, "ttys") || len(name) == len("ttys") {
+		return false
+	}
+	for _, c := range name[len("ttys"):] {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// usbID is not implemented on macOS, every port is reported as not being a USB adapter.
+func usbID(name string) (vid, pid uint16, ok bool, err error) {
+	return 0, 0, false, nil
+}
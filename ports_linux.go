@@ -0,0 +1,148 @@
+package serialport
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// devDir is where the device nodes of the ttys are created.
+var devDir = "/dev"
+
+// devPts is where pseudo-terminal slaves are created.
+var devPts = "/dev/pts"
+
+// systemTTY matches the ttys without a device that are never serial ports:
+// the current terminal, the console, virtual consoles and the pseudo-terminal multiplexer.
+var systemTTY = regexp.MustCompile(`^(tty|console|tty[0-9]+|ptmx)$`)
+
+// devSerialByID is where udev creates stable symlinks named after the adapter's identity.
+var devSerialByID = "/dev/serial/by-id"
+
+// ListPortsByID returns the stable /dev/serial/by-id/... names created by udev,
+// mapped to the device they currently point to (e.g. /dev/ttyUSB0).
+// Opening the by-id name addresses the same physical adapter across reboots and replugs.
+func ListPortsByID() (map[string]string, error) {
+	entries, err := os.ReadDir(devSerialByID)
+	if os.IsNotExist(err) {
+		return map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ports := make(map[string]string, len(entries))
+	for _, e := range entries {
+		link := filepath.Join(devSerialByID, e.Name())
+		target, err := filepath.EvalSymlinks(link)
+		if err != nil {
+			continue // dangling link of a device being removed
+		}
+		ports[link] = target
+	}
+
+	return ports, nil
+}
+
+// ListPorts returns the serial ports backed by a device, sorted by name.
+// The ttys of /sys/class/tty are only listed if their node exists in /dev, and the UARTs
+// the serial8250 driver registers for probing but found no hardware for are left out.
+// USB adapters are described by the idVendor, idProduct, manufacturer, product and serial files of their sysfs device.
+func ListPorts() ([]PortInfo, error) {
+	return ListPortsWithOptions(ListPortsOptions{})
+}
+
+// ListPortsWithOptions is like ListPorts, adding the virtual ports and pseudo-terminals selected by opts.
+func ListPortsWithOptions(opts ListPortsOptions) ([]PortInfo, error) {
+	entries, err := os.ReadDir(sysClassTTY)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []PortInfo
+	for _, e := range entries {
+		name := "/dev/" + e.Name()
+		_, ok, err := ttyDevice(name)
+		if err != nil {
+			continue // a tty going away
+		}
+		if _, err := os.Stat(filepath.Join(devDir, e.Name())); err != nil {
+			continue // no device node, e.g. in a container
+		}
+		if !ok {
+			if opts.IncludeVirtual && !systemTTY.MatchString(e.Name()) {
+				ports = append(ports, PortInfo{Name: name, IsVirtual: true})
+			}
+			continue
+		}
+		if phantomUART(e.Name()) {
+			continue
+		}
+		info, err := portInfo(name)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, info)
+	}
+
+	if opts.IncludePTY {
+		ptys, err := listPTYs()
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, ptys...)
+	}
+	sortPorts(ports)
+
+	return ports, nil
+}
+
+// phantomUART reports whether the tty is a UART without hardware, e.g. one of the ttyS0 to ttyS31
+// that serial8250 always registers: their port type (PORT_UNKNOWN) is 0.
+func phantomUART(tty string) bool {
+	typ, err := readSysfsString(filepath.Join(sysClassTTY, tty, "type"))
+	return err == nil && typ == "0"
+}
+
+// listPTYs returns the pseudo-terminal slaves in devPts.
+func listPTYs() ([]PortInfo, error) {
+	entries, err := os.ReadDir(devPts)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []PortInfo
+	for _, e := range entries {
+		if e.Name() == "ptmx" {
+			continue
+		}
+		ports = append(ports, PortInfo{Name: filepath.Join(devPts, e.Name()), IsVirtual: true})
+	}
+	return ports, nil
+}
+
+// portInfo describes the serial port name.
+func portInfo(name string) (info PortInfo, err error) {
+	info.Name = name
+
+	dir, ok, err := usbDevice(name)
+	if !ok || err != nil {
+		return
+	}
+	info.IsUSB = true
+	if info.VID, err = readSysfsHex(filepath.Join(dir, "idVendor")); err != nil {
+		return
+	}
+	if info.PID, err = readSysfsHex(filepath.Join(dir, "idProduct")); err != nil {
+		return
+	}
+	// String descriptors are optional.
+	info.Manufacturer, _ = readSysfsString(filepath.Join(dir, "manufacturer"))
+	info.Product, _ = readSysfsString(filepath.Join(dir, "product"))
+	info.SerialNumber, _ = readSysfsString(filepath.Join(dir, "serial"))
+
+	return
+}
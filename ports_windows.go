@@ -0,0 +1,97 @@
+package serialport
+
+import (
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// ListPorts returns the serial ports registered in HKLM\HARDWARE\DEVICEMAP\SERIALCOMM, sorted by name.
+// USB adapters are described by their device key under HKLM\SYSTEM\CurrentControlSet\Enum,
+// which holds the same properties SetupAPI reports.
+func ListPorts() ([]PortInfo, error) {
+	return ListPortsWithOptions(ListPortsOptions{})
+}
+
+// ListPortsWithOptions is the same as ListPorts: virtual COM ports are registered in SERIALCOMM
+// like the others, and Windows has no pseudo-terminals.
+func ListPortsWithOptions(opts ListPortsOptions) ([]PortInfo, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, `HARDWARE\DEVICEMAP\SERIALCOMM`, registry.QUERY_VALUE)
+	if err == registry.ErrNotExist {
+		return nil, nil // no serial port at all
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+
+	values, err := k.ReadValueNames(-1)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []PortInfo
+	for _, v := range values {
+		name, _, err := k.GetStringValue(v)
+		if err != nil {
+			continue
+		}
+		info, err := portInfo(name)
+		if err != nil {
+			return nil, err
+		}
+		ports = append(ports, info)
+	}
+	sortPorts(ports)
+
+	return ports, nil
+}
+
+// portInfo describes the serial port name.
+func portInfo(name string) (info PortInfo, err error) {
+	info.Name = name
+
+	key, ok, err := usbDeviceKey(name)
+	if !ok || err != nil {
+		return
+	}
+	info.IsUSB = true
+	info.VID, info.PID, _, _ = usbID(name)
+	info.Manufacturer = registryDisplayString(registryString(key, "Mfg"))
+	info.Product = registryDisplayString(registryString(key, "DeviceDesc"))
+	info.SerialNumber = usbSerialNumber(key)
+
+	return
+}
+
+// registryDisplayString strips the "@driver.inf,%key%;" indirection from a device property.
+func registryDisplayString(s string) string {
+	if i := strings.LastIndexByte(s, ';'); i >= 0 {
+		return s[i+1:]
+	}
+	return s
+}
+
+// usbSerialNumber extracts the serial number from the Enum key path of a USB device.
+// Windows uses it as the instance ID of devices with a serial number, e.g. USB\VID_10C4&PID_EA60\0001,
+// and generates one containing '&' for the others. FTDI's bus appends the channel letter,
+// e.g. FTDIBUS\VID_0403+PID_6001+A50285BIA\0000.
+func usbSerialNumber(key string) string {
+	parts := strings.Split(key, `\`)
+	if len(parts) < 3 {
+		return ""
+	}
+	enum, device, instance := parts[len(parts)-3], parts[len(parts)-2], parts[len(parts)-1]
+
+	if strings.EqualFold(enum, "FTDIBUS") {
+		fields := strings.Split(device, "+")
+		if len(fields) < 3 || len(fields[2]) < 2 {
+			return ""
+		}
+		return fields[2][:len(fields[2])-1]
+	}
+	if strings.Contains(instance, "&") {
+		return ""
+	}
+	return instance
+}
@@ -0,0 +1,120 @@
+package serialport
+
+import (
+	"context"
+	"io"
+	"os"
+	"time"
+)
+
+const (
+	minProgressChunk = 64
+	maxProgressChunk = 4096
+)
+
+// progressChunkSize returns how many bytes take about 100 ms to transmit with cfg,
+// so that progress is reported at a steady pace regardless of the baud rate.
+func progressChunkSize(cfg Config) int {
+	size := minProgressChunk
+	if ct := charTime(cfg); ct > 0 {
+		size = int(100 * time.Millisecond / ct)
+	}
+	if size < minProgressChunk {
+		size = minProgressChunk
+	}
+	if size > maxProgressChunk {
+		size = maxProgressChunk
+	}
+	return size
+}
+
+// WriteWithProgress writes b to the serial port in chunks and calls progress
+// after each chunk with the number of bytes written so far and len(b).
+// Each chunk is written like Write, honoring Config.WriteTimeout, Config.StrictSevenBit and the error filter.
+func (sp *SerialPort) WriteWithProgress(b []byte, progress func(written, total int)) (int, error) {
+	return sp.WriteWithProgressContext(context.Background(), b, progress)
+}
+
+// WriteWithProgressContext is like WriteWithProgress but stops between chunks
+// and returns ctx.Err() once ctx is done.
+func (sp *SerialPort) WriteWithProgressContext(ctx context.Context, b []byte, progress func(written, total int)) (n int, err error) {
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+
+	chunk := progressChunkSize(sp.config())
+	for {
+		if err = ctx.Err(); err != nil {
+			return
+		}
+
+		end := n + chunk
+		if end > len(b) {
+			end = len(b)
+		}
+		var m int
+		m, err = sp.writeChunkedContext(ctx, b[n:end])
+		n += m
+		if err == nil && n < end {
+			err = io.ErrShortWrite
+		}
+		if progress != nil {
+			progress(n, len(b))
+		}
+		if err != nil || n == len(b) {
+			return
+		}
+	}
+}
+
+// WriteFile streams the contents of the file path to the serial port in chunks of chunkSize bytes
+// (about 100 ms of transmission if chunkSize <= 0) and calls progress, if not nil, after each chunk
+// with the number of bytes written so far and the file size. Each chunk is written like Write,
+// honoring Config.WriteTimeout and flow control.
+func (sp *SerialPort) WriteFile(path string, chunkSize int, progress func(written, total int64)) error {
+	return sp.WriteFileDelay(path, chunkSize, 0, progress)
+}
+
+// WriteFileDelay is like WriteFile but pauses for delay between chunks,
+// for devices that must process each chunk before the next one arrives.
+func (sp *SerialPort) WriteFileDelay(path string, chunkSize int, delay time.Duration, progress func(written, total int64)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	total := fi.Size()
+
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+
+	if chunkSize <= 0 {
+		chunkSize = progressChunkSize(sp.config())
+	}
+	buf := make([]byte, chunkSize)
+	var written int64
+	for {
+		n, err := io.ReadFull(f, buf)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		if written > 0 && delay > 0 {
+			time.Sleep(delay)
+		}
+
+		m, werr := sp.writeChunked(buf[:n])
+		written += int64(m)
+		if progress != nil {
+			progress(written, total)
+		}
+		if werr != nil {
+			return werr
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package serialport
+
+import (
+	"bytes"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenPTY opens a pseudo-terminal pair, e.g. to test code built on this package without hardware:
+// data written to master is read from the serial port opened with Open(slaveName, cfg), and the other way round.
+// master reads with DefaultConfig's Timeout; the pty itself is configured by opening the slave.
+// Neither a pty master nor its slave has modem lines, and the slave ignores the baud rate.
+func OpenPTY() (master *SerialPort, slaveName string, err error) {
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", newOpenError("/dev/ptmx", err)
+	}
+	// grantpt, unlockpt and ptsname
+	var name [128]byte
+	for _, req := range []uint{unix.TIOCPTYGRANT, unix.TIOCPTYUNLK} {
+		if err = ioctlPtr(fd, req, nil); err != nil {
+			unix.Close(fd)
+			return nil, "", err
+		}
+	}
+	if err = ioctlPtr(fd, unix.TIOCPTYGNAME, unsafe.Pointer(&name[0])); err != nil {
+		unix.Close(fd)
+		return nil, "", err
+	}
+	if i := bytes.IndexByte(name[:], 0); i >= 0 {
+		slaveName = string(name[:i])
+	}
+
+	master = &SerialPort{fd: fd, name: "/dev/ptmx", cfg: DefaultConfig()}
+	if err = master.openWake(); err != nil {
+		unix.Close(fd)
+		return nil, "", err
+	}
+	return master, slaveName, nil
+}
@@ -0,0 +1,35 @@
+package serialport
+
+import (
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// OpenPTY opens a pseudo-terminal pair, e.g. to test code built on this package without hardware:
+// data written to master is read from the serial port opened with Open(slaveName, cfg), and the other way round.
+// master reads with DefaultConfig's Timeout; the pty itself is configured by opening the slave.
+// Neither a pty master nor its slave has modem lines, and the slave ignores the baud rate.
+func OpenPTY() (master *SerialPort, slaveName string, err error) {
+	fd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, "", newOpenError("/dev/ptmx", err)
+	}
+	// unlockpt and ptsname
+	if err = unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		unix.Close(fd)
+		return nil, "", err
+	}
+	n, err := unix.IoctlGetUint32(fd, unix.TIOCGPTN)
+	if err != nil {
+		unix.Close(fd)
+		return nil, "", err
+	}
+
+	master = &SerialPort{fd: fd, name: "/dev/ptmx", cfg: DefaultConfig()}
+	if err = master.openWake(); err != nil {
+		unix.Close(fd)
+		return nil, "", err
+	}
+	return master, fmt.Sprintf("/dev/pts/%d", n), nil
+}
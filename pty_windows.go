@@ -0,0 +1,8 @@
+package serialport
+
+// OpenPTY opens a pseudo-terminal pair on Linux and macOS.
+// Windows has no pseudo-terminals usable as serial ports, so it always returns ErrUnsupported;
+// a virtual null-modem driver such as com0com provides a pair of COM ports instead.
+func OpenPTY() (master *SerialPort, slaveName string, err error) {
+	return nil, "", ErrUnsupported
+}
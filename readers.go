@@ -0,0 +1,221 @@
+package serialport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+)
+
+// ReadUpTo reads until max bytes have been collected or no byte has arrived for idle,
+// which suits instruments that do not frame their output but pause between messages.
+// The first byte is waited for up to the configured Timeout (forever if Timeout is 0);
+// if none arrives, ReadUpTo returns no data and ErrTimeout.
+func (sp *SerialPort) ReadUpTo(max int, idle time.Duration) ([]byte, error) {
+	if max < 0 {
+		return nil, fmt.Errorf("serialport: ReadUpTo max cannot be negative %v", max)
+	}
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	b := make([]byte, max)
+	n := sp.takeBuffered(b)
+	if n == 0 && max > 0 {
+		m, err := sp.readTimeout(b, sp.readTimeoutBudget())
+		n += m
+		if err != nil {
+			return b[:n], err
+		}
+	}
+
+	for n < max {
+		m, err := sp.readTimeout(b[n:], idle)
+		n += m
+		if err == ErrTimeout {
+			break
+		}
+		if err != nil {
+			return b[:n], err
+		}
+	}
+
+	return b[:n], nil
+}
+
+// ReadBatch waits up to firstByteTimeout for at least one byte (forever if negative),
+// then takes up to max bytes in total of what is already available, without waiting any further.
+// If no byte arrives in time, ReadBatch returns no data and ErrTimeout.
+func (sp *SerialPort) ReadBatch(max int, firstByteTimeout time.Duration) ([]byte, error) {
+	if max < 0 {
+		return nil, fmt.Errorf("serialport: ReadBatch max cannot be negative %v", max)
+	}
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	b := make([]byte, max)
+	n := sp.takeBuffered(b)
+	if n == 0 && max > 0 {
+		m, err := sp.readTimeout(b, firstByteTimeout)
+		n += m
+		if err != nil {
+			return b[:n], err
+		}
+	}
+
+	for n < max {
+		m, err := sp.readTimeout(b[n:], 0)
+		n += m
+		if err == ErrTimeout {
+			break
+		}
+		if err != nil {
+			return b[:n], err
+		}
+	}
+
+	return b[:n], nil
+}
+
+// ReadUntil reads until delim within the configured Timeout (no limit if Timeout is 0),
+// returning the data up to and including delim; data received after it is kept for the next read.
+// On timeout it returns the data received so far, which may be empty but is never nil, and ErrTimeout.
+func (sp *SerialPort) ReadUntil(delim byte) ([]byte, error) {
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	return sp.readUntil(delim, deadlineAfter(sp.readTimeoutBudget()))
+}
+
+// readUntil is ReadUntil with a deadline, where a zero deadline means forever. rmu must be held.
+func (sp *SerialPort) readUntil(delim byte, deadline time.Time) ([]byte, error) {
+	buf := make([]byte, 256)
+	got := []byte{}
+	for n := sp.takeBuffered(buf); n > 0; n = sp.takeBuffered(buf) {
+		got = append(got, buf[:n]...)
+	}
+	for {
+		if i := bytes.IndexByte(got, delim); i >= 0 {
+			sp.unread(got[i+1:])
+			return got[:i+1], nil
+		}
+
+		timeout := remaining(deadline)
+		if timeout == 0 {
+			return got, ErrTimeout
+		}
+		n, err := sp.readTimeout(buf, timeout)
+		got = append(got, buf[:n]...)
+		if err != nil && err != ErrTimeout {
+			return got, err
+		}
+	}
+}
+
+// ReadFull reads exactly len(b) bytes within the configured Timeout (no limit if Timeout is 0),
+// which is a budget for the whole call rather than for each underlying read.
+// Like io.ReadFull, it returns io.ErrUnexpectedEOF if only part of b was filled in time or before
+// the other end went away; if nothing arrived at all it returns ErrTimeout or io.EOF.
+func (sp *SerialPort) ReadFull(b []byte) (n int, err error) {
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	deadline := deadlineAfter(sp.readTimeoutBudget())
+	n = sp.takeBuffered(b)
+	for n < len(b) {
+		timeout := remaining(deadline)
+		if timeout == 0 {
+			err = ErrTimeout
+			break
+		}
+		var m int
+		m, err = sp.readTimeout(b[n:], timeout)
+		n += m
+		if err != nil && err != ErrTimeout {
+			break
+		}
+		err = nil
+	}
+	if n > 0 && (err == ErrTimeout || err == io.EOF) {
+		err = io.ErrUnexpectedEOF
+	}
+	return
+}
+
+// Expect writes send, then collects received data until it matches pattern, like tcl/expect.
+// It returns the data up to the end of the match; data received after it is kept for the next read.
+// If pattern does not match within timeout, Expect returns the data collected so far and ErrTimeout.
+func (sp *SerialPort) Expect(send []byte, pattern *regexp.Regexp, timeout time.Duration) ([]byte, error) {
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	deadline := time.Now().Add(timeout)
+	if _, err := sp.writeChunked(send); err != nil {
+		return nil, err
+	}
+
+	buf := make([]byte, 256)
+	n := sp.takeBuffered(buf)
+	got := append([]byte{}, buf[:n]...)
+	for {
+		if loc := pattern.FindIndex(got); loc != nil {
+			sp.unread(got[loc[1]:])
+			return got[:loc[1]], nil
+		}
+
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			return got, ErrTimeout
+		}
+		n, err := sp.readTimeout(buf, wait)
+		got = append(got, buf[:n]...)
+		if err == ErrTimeout {
+			continue
+		}
+		if err != nil {
+			return got, err
+		}
+	}
+}
+
+// Probe checks whether a device answers on the serial port, e.g. while scanning ports and baud rates for it.
+// It sends each sequence of sendSeq in turn, discarding stale input first, and collects the response
+// for up to perAttemptTimeout. It reports true as soon as expect accepts the data received for an attempt,
+// and false if no attempt gets an acceptable response.
+func (sp *SerialPort) Probe(sendSeq [][]byte, expect func([]byte) bool, perAttemptTimeout time.Duration) (bool, error) {
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	buf := make([]byte, 256)
+	for _, send := range sendSeq {
+		if err := sp.flushInput(); err != nil {
+			return false, err
+		}
+		deadline := time.Now().Add(perAttemptTimeout)
+		if _, err := sp.writeChunked(send); err != nil {
+			return false, err
+		}
+
+		var got []byte
+		for {
+			wait := time.Until(deadline)
+			if wait <= 0 {
+				break
+			}
+			n, err := sp.readTimeout(buf, wait)
+			got = append(got, buf[:n]...)
+			if n > 0 && expect(got) {
+				return true, nil
+			}
+			if err != nil && err != ErrTimeout {
+				return false, err
+			}
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,40 @@
+package serialport
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// ComputeReadParams translates "return data at most maxLatency after the line goes quiet,
+// preferably in batches of preferredBatch bytes" into termios VMIN and VTIME:
+//     VMIN  = preferredBatch (at most 255), so a read returns as soon as a full batch has arrived;
+//     VTIME = maxLatency in deciseconds (1 to 255), so a partial batch is returned once no byte has arrived for that long.
+// VTIME cannot express less than 100 ms, so a smaller maxLatency, like a preferredBatch of 1,
+// gives VMIN = 1 and VTIME = 0: every read returns as soon as any data is available.
+// In every case a read waits for its first byte, bounded only by Config.Timeout where the read uses it.
+func ComputeReadParams(maxLatency time.Duration, preferredBatch int) (vmin, vtime uint8) {
+	if preferredBatch <= 1 || maxLatency < deciseconds {
+		return 1, 0
+	}
+	if preferredBatch > math.MaxUint8 {
+		preferredBatch = math.MaxUint8
+	}
+	t := (maxLatency + deciseconds - 1) / deciseconds
+	if t > math.MaxUint8 {
+		t = math.MaxUint8
+	}
+	return uint8(preferredBatch), uint8(t)
+}
+
+// SetReadParams sets VMIN and VTIME, e.g. as computed by ComputeReadParams, until the next SetConfig.
+func (sp *SerialPort) SetReadParams(vmin, vtime uint8) error {
+	termios, err := unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
+	if err != nil {
+		return err
+	}
+	termios.Cc[unix.VMIN] = vmin
+	termios.Cc[unix.VTIME] = vtime
+	return unix.IoctlSetTermios(sp.fd, unix.TCSETS2, termios)
+}
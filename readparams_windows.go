@@ -0,0 +1,45 @@
+package serialport
+
+import (
+	"math"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// ReadParams are the read fields of COMMTIMEOUTS, the Windows counterpart of VMIN and VTIME.
+type ReadParams struct {
+	ReadIntervalTimeout        uint32 // maximum gap between two bytes, in ms
+	ReadTotalTimeoutMultiplier uint32 // per byte requested, in ms
+	ReadTotalTimeoutConstant   uint32 // in ms
+}
+
+// ComputeReadParams translates "return data at most maxLatency after the line goes quiet,
+// preferably in batches of preferredBatch bytes" into COMMTIMEOUTS:
+// a read returns when its buffer is full or once no byte has arrived for maxLatency.
+// Windows has no minimum byte count, so the batch size is that of the buffer passed to Read;
+// a preferredBatch of 1 or a maxLatency below 1 ms makes every read return as soon as any data is available.
+// In every case a read waits for its first byte.
+func ComputeReadParams(maxLatency time.Duration, preferredBatch int) ReadParams {
+	if preferredBatch <= 1 || maxLatency < time.Millisecond {
+		// MAXDWORD interval and multiplier: return what is available as soon as there is something.
+		return ReadParams{
+			ReadIntervalTimeout:        math.MaxUint32,
+			ReadTotalTimeoutMultiplier: math.MaxUint32,
+			ReadTotalTimeoutConstant:   math.MaxUint32 - 1,
+		}
+	}
+	return ReadParams{ReadIntervalTimeout: durationToMs(maxLatency)}
+}
+
+// SetReadParams sets the read fields of COMMTIMEOUTS, e.g. as computed by ComputeReadParams, until the next SetConfig.
+func (sp *SerialPort) SetReadParams(p ReadParams) error {
+	var t windows.CommTimeouts
+	if err := windows.GetCommTimeouts(sp.handle, &t); err != nil {
+		return err
+	}
+	t.ReadIntervalTimeout = p.ReadIntervalTimeout
+	t.ReadTotalTimeoutMultiplier = p.ReadTotalTimeoutMultiplier
+	t.ReadTotalTimeoutConstant = p.ReadTotalTimeoutConstant
+	return windows.SetCommTimeouts(sp.handle, &t)
+}
@@ -0,0 +1,418 @@
+package serialport
+
+import (
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+)
+
+// Telnet commands and options, see RFC 854, RFC 856, RFC 858 and RFC 2217.
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetOptBinary  = 0
+	telnetOptSGA     = 3
+	telnetOptComPort = 44
+)
+
+// RFC 2217 COM-PORT-OPTION commands sent by the client; the server answers with the command + 100.
+const (
+	comPortSetBaudRate = 1
+	comPortSetDataSize = 2
+	comPortSetParity   = 3
+	comPortSetStopSize = 4
+	comPortPurgeData   = 12
+
+	comPortServerOffset = 100
+
+	comPortPurgeBoth = 3
+)
+
+// serialport parity to RFC 2217 parity
+var spToRFC2217Parity = map[Parity]byte{PN: 1, PO: 2, PE: 3, PM: 4, PS: 5}
+
+// serialport stopbits to RFC 2217 stop size
+var spToRFC2217StopSize = map[StopBits]byte{SB1: 1, SB2: 2, SB1_5: 3}
+
+// An RFC2217Port is a serial port of a device server (Moxa NPort, Digi PortServer, ser2net, etc.)
+// reached over TCP with the Telnet COM-PORT-OPTION of RFC 2217. It must be created with DialRFC2217.
+type RFC2217Port struct {
+	conn net.Conn
+
+	cmu sync.Mutex // guards cfg
+	cfg Config     // last requested configuration, updated with the values the server reports
+
+	rmu     sync.Mutex // serializes reads, guards raw, pending and the decoder state
+	raw     []byte
+	pending []byte // received data not yet returned by Read
+	state   int    // telnet decoder state
+	cmd     byte   // option command being decoded
+	sb      []byte // subnegotiation being decoded
+
+	wmu sync.Mutex // serializes writes
+
+	nmu    sync.Mutex // guards local and remote
+	local  map[byte]bool
+	remote map[byte]bool
+}
+
+var _ Port = (*RFC2217Port)(nil)
+
+// DialRFC2217 connects to the RFC 2217 serial port server at addr (host:port)
+// and sets the remote serial port according to cfg. The returned Port is an *RFC2217Port.
+// Binary transmission is negotiated in both directions, so data is only altered on the wire by IAC escaping,
+// which Read and Write undo and apply transparently.
+func DialRFC2217(addr string, cfg Config) (Port, error) {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	p := newRFC2217Port(conn)
+	if err = p.negotiate(); err == nil {
+		err = p.SetConfig(cfg)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func newRFC2217Port(conn net.Conn) *RFC2217Port {
+	return &RFC2217Port{
+		conn:   conn,
+		raw:    make([]byte, 1024),
+		local:  map[byte]bool{},
+		remote: map[byte]bool{},
+	}
+}
+
+// negotiate offers the options the client needs, without waiting for the answers,
+// which are processed as they arrive by Read.
+func (p *RFC2217Port) negotiate() error {
+	p.nmu.Lock()
+	p.local[telnetOptComPort] = true
+	p.local[telnetOptBinary] = true
+	p.local[telnetOptSGA] = true
+	p.remote[telnetOptBinary] = true
+	p.remote[telnetOptSGA] = true
+	p.nmu.Unlock()
+
+	_, err := p.conn.Write([]byte{
+		telnetIAC, telnetWILL, telnetOptComPort,
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptSGA,
+	})
+	return err
+}
+
+// Close closes the connection to the server.
+func (p *RFC2217Port) Close() error {
+	return p.conn.Close()
+}
+
+// Read reads up to len(b) bytes received from the remote serial port.
+// Like SerialPort.Read, it returns (0, nil) if Config.Timeout > 0 elapses without data,
+// and blocks until data arrives if Config.Timeout is 0.
+func (p *RFC2217Port) Read(b []byte) (n int, err error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	p.rmu.Lock()
+	defer p.rmu.Unlock()
+
+	var deadline time.Time
+	if timeout := p.config().Timeout; timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err = p.conn.SetReadDeadline(deadline); err != nil {
+		return
+	}
+
+	for {
+		if len(p.pending) > 0 {
+			n = copy(b, p.pending)
+			p.pending = p.pending[n:]
+			return n, nil
+		}
+
+		m, err := p.conn.Read(p.raw)
+		if derr := p.decode(p.raw[:m]); derr != nil {
+			return 0, derr
+		}
+		if len(p.pending) > 0 {
+			continue
+		}
+		if ne, ok := err.(net.Error); ok && ne.Timeout() {
+			return 0, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+}
+
+// Write writes b to the remote serial port, doubling each IAC byte as the telnet protocol requires.
+// It returns ErrTimeout if Config.WriteTimeout > 0 elapses before the data is sent.
+func (p *RFC2217Port) Write(b []byte) (n int, err error) {
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+
+	var deadline time.Time
+	if timeout := p.config().WriteTimeout; timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	if err = p.conn.SetWriteDeadline(deadline); err != nil {
+		return
+	}
+
+	escaped := escapeIAC(b)
+	m, err := p.conn.Write(escaped)
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		err = ErrTimeout
+	}
+	if m == len(escaped) {
+		return len(b), err
+	}
+	// Count the bytes of b whose escaped form was sent completely.
+	for _, c := range b {
+		w := 1
+		if c == telnetIAC {
+			w = 2
+		}
+		if m < w {
+			break
+		}
+		m -= w
+		n++
+	}
+	return n, err
+}
+
+// Config returns the configuration of the remote serial port:
+// the one last set, updated with the values the server has reported back.
+func (p *RFC2217Port) Config() (Config, error) {
+	return p.config(), nil
+}
+
+// SetConfig sets the baud rate, data bits, parity and stop bits of the remote serial port.
+// The server applies them asynchronously; Config reflects them once it has confirmed them.
+func (p *RFC2217Port) SetConfig(cfg Config) error {
+	if cfg.BaudRate < 0 {
+		return configErrorf("BaudRate", "Config.BaudRate cannot be negative %v", cfg.BaudRate)
+	}
+	if cfg.DataBits != DB5 && cfg.DataBits != DB6 && cfg.DataBits != DB7 && cfg.DataBits != DB8 {
+		return configErrorf("DataBits", "invalid Config.DataBits %v", cfg.DataBits)
+	}
+	stopSize, ok := spToRFC2217StopSize[cfg.StopBits]
+	if !ok {
+		return configErrorf("StopBits", "invalid Config.StopBits %v", cfg.StopBits)
+	}
+	parity, ok := spToRFC2217Parity[cfg.Parity]
+	if !ok {
+		return configErrorf("Parity", "invalid Config.Parity %v", cfg.Parity)
+	}
+
+	baud := make([]byte, 4)
+	binary.BigEndian.PutUint32(baud, uint32(cfg.BaudRate))
+	var msg []byte
+	msg = append(msg, comPortSubnegotiation(comPortSetBaudRate, baud...)...)
+	msg = append(msg, comPortSubnegotiation(comPortSetDataSize, byte(cfg.DataBits))...)
+	msg = append(msg, comPortSubnegotiation(comPortSetParity, parity)...)
+	msg = append(msg, comPortSubnegotiation(comPortSetStopSize, stopSize)...)
+
+	p.wmu.Lock()
+	_, err := p.conn.Write(msg)
+	p.wmu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	p.cmu.Lock()
+	p.cfg = cfg
+	p.cmu.Unlock()
+	return nil
+}
+
+// Flush discards data received but not read, and asks the server to purge both of its buffers.
+func (p *RFC2217Port) Flush() error {
+	p.rmu.Lock()
+	p.pending = nil
+	p.rmu.Unlock()
+
+	p.wmu.Lock()
+	defer p.wmu.Unlock()
+	_, err := p.conn.Write(comPortSubnegotiation(comPortPurgeData, comPortPurgeBoth))
+	return err
+}
+
+func (p *RFC2217Port) config() Config {
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+	return p.cfg
+}
+
+// comPortSubnegotiation returns the COM-PORT-OPTION subnegotiation for cmd with value.
+func comPortSubnegotiation(cmd byte, value ...byte) []byte {
+	msg := []byte{telnetIAC, telnetSB, telnetOptComPort, cmd}
+	msg = append(msg, escapeIAC(value)...)
+	return append(msg, telnetIAC, telnetSE)
+}
+
+// escapeIAC returns b with each IAC byte doubled.
+func escapeIAC(b []byte) []byte {
+	escaped := make([]byte, 0, len(b))
+	for _, c := range b {
+		if c == telnetIAC {
+			escaped = append(escaped, telnetIAC)
+		}
+		escaped = append(escaped, c)
+	}
+	return escaped
+}
+
+// Telnet decoder states
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSB
+	telnetStateSBIAC
+)
+
+// decode appends the data in the telnet stream b to pending and handles the commands in it.
+// Sequences split across calls are completed by the next one. rmu must be held.
+func (p *RFC2217Port) decode(b []byte) error {
+	for _, c := range b {
+		switch p.state {
+		case telnetStateData:
+			if c == telnetIAC {
+				p.state = telnetStateIAC
+			} else {
+				p.pending = append(p.pending, c)
+			}
+		case telnetStateIAC:
+			switch c {
+			case telnetIAC:
+				p.pending = append(p.pending, c)
+				p.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				p.cmd = c
+				p.state = telnetStateOption
+			case telnetSB:
+				p.sb = p.sb[:0]
+				p.state = telnetStateSB
+			default:
+				// NOP, GA, AYT, etc. carry nothing for a serial port.
+				p.state = telnetStateData
+			}
+		case telnetStateOption:
+			p.state = telnetStateData
+			if err := p.answerOption(p.cmd, c); err != nil {
+				return err
+			}
+		case telnetStateSB:
+			if c == telnetIAC {
+				p.state = telnetStateSBIAC
+			} else {
+				p.sb = append(p.sb, c)
+			}
+		case telnetStateSBIAC:
+			switch c {
+			case telnetIAC:
+				p.sb = append(p.sb, c)
+				p.state = telnetStateSB
+			case telnetSE:
+				p.state = telnetStateData
+				p.handleSubnegotiation(p.sb)
+			default:
+				// Malformed subnegotiation, drop it.
+				p.state = telnetStateData
+			}
+		}
+	}
+	return nil
+}
+
+// answerOption answers an option negotiation by the server, following the loop avoidance rules of RFC 854:
+// an option is only acknowledged when its state changes.
+func (p *RFC2217Port) answerOption(cmd, opt byte) error {
+	p.nmu.Lock()
+	var reply byte
+	switch cmd {
+	case telnetDO:
+		if opt != telnetOptComPort && opt != telnetOptBinary && opt != telnetOptSGA {
+			reply = telnetWONT
+		} else if !p.local[opt] {
+			p.local[opt] = true
+			reply = telnetWILL
+		}
+	case telnetDONT:
+		if p.local[opt] {
+			p.local[opt] = false
+			reply = telnetWONT
+		}
+	case telnetWILL:
+		if opt != telnetOptBinary && opt != telnetOptSGA {
+			reply = telnetDONT
+		} else if !p.remote[opt] {
+			p.remote[opt] = true
+			reply = telnetDO
+		}
+	case telnetWONT:
+		if p.remote[opt] {
+			p.remote[opt] = false
+			reply = telnetDONT
+		}
+	}
+	p.nmu.Unlock()
+
+	if reply == 0 {
+		return nil
+	}
+	_, err := p.conn.Write([]byte{telnetIAC, reply, opt})
+	return err
+}
+
+// handleSubnegotiation records the serial port settings reported by the server.
+func (p *RFC2217Port) handleSubnegotiation(sb []byte) {
+	if len(sb) < 3 || sb[0] != telnetOptComPort {
+		return
+	}
+	cmd, value := sb[1], sb[2:]
+
+	p.cmu.Lock()
+	defer p.cmu.Unlock()
+	switch cmd {
+	case comPortServerOffset + comPortSetBaudRate:
+		if len(value) == 4 {
+			p.cfg.BaudRate = int(binary.BigEndian.Uint32(value))
+		}
+	case comPortServerOffset + comPortSetDataSize:
+		p.cfg.DataBits = DataBits(value[0])
+	case comPortServerOffset + comPortSetParity:
+		for parity, v := range spToRFC2217Parity {
+			if v == value[0] {
+				p.cfg.Parity = parity
+			}
+		}
+	case comPortServerOffset + comPortSetStopSize:
+		for stopBits, v := range spToRFC2217StopSize {
+			if v == value[0] {
+				p.cfg.StopBits = stopBits
+			}
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package serialport
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// serveRFC2217 accepts one connection on a local listener and runs server on it.
+func serveRFC2217(t *testing.T, server func(conn net.Conn)) (addr string) {
+	t.Helper()
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("no loopback networking: %v", err)
+	}
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		server(conn)
+	}()
+
+	return l.Addr().String()
+}
+
+func TestDialRFC2217(t *testing.T) {
+	wantSetup := []byte{
+		telnetIAC, telnetWILL, telnetOptComPort,
+		telnetIAC, telnetWILL, telnetOptBinary,
+		telnetIAC, telnetDO, telnetOptBinary,
+		telnetIAC, telnetWILL, telnetOptSGA,
+		telnetIAC, telnetDO, telnetOptSGA,
+		telnetIAC, telnetSB, telnetOptComPort, comPortSetBaudRate, 0x00, 0x00, 0x25, 0x80, telnetIAC, telnetSE,
+		telnetIAC, telnetSB, telnetOptComPort, comPortSetDataSize, 8, telnetIAC, telnetSE,
+		telnetIAC, telnetSB, telnetOptComPort, comPortSetParity, 3, telnetIAC, telnetSE,
+		telnetIAC, telnetSB, telnetOptComPort, comPortSetStopSize, 1, telnetIAC, telnetSE,
+	}
+	errc := make(chan string, 1)
+	addr := serveRFC2217(t, func(conn net.Conn) {
+		buf := make([]byte, len(wantSetup))
+		if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, wantSetup) {
+			errc <- "unexpected setup " + string(buf)
+			return
+		}
+		conn.Write([]byte{
+			telnetIAC, telnetDO, telnetOptComPort,
+			telnetIAC, telnetWILL, 1, // ECHO, which the client must refuse
+			telnetIAC, telnetSB, telnetOptComPort, comPortServerOffset + comPortSetBaudRate, 0x00, 0x00, 0x25, 0x80, telnetIAC, telnetSE,
+			'h', 'e', 'l', 'l', 'o',
+		})
+		buf = make([]byte, 5)
+		if _, err := io.ReadFull(conn, buf); err != nil || !bytes.Equal(buf, []byte{telnetIAC, telnetDONT, 1, 'h', 'i'}) {
+			errc <- "unexpected reply " + string(buf)
+			return
+		}
+		errc <- ""
+	})
+
+	cfg := DefaultConfig()
+	cfg.BaudRate = BR9600
+	cfg.Parity = PE
+	p, err := DialRFC2217(addr, cfg)
+	if err != nil {
+		t.Fatalf("DialRFC2217: %v", err)
+	}
+	defer p.Close()
+
+	buf := make([]byte, 16)
+	if n, err := p.Read(buf); string(buf[:n]) != "hello" || err != nil {
+		t.Fatalf("Read = %q, %v; want \"hello\", nil", buf[:n], err)
+	}
+	if got, _ := p.Config(); got.BaudRate != BR9600 || got.Parity != PE {
+		t.Fatalf("Config() = %+v, want 9600 bps even parity", got)
+	}
+	if _, err := p.Write([]byte("hi")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	select {
+	case msg := <-errc:
+		if msg != "" {
+			t.Fatal(msg)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("server did not finish")
+	}
+
+	// The server has closed the connection.
+	if _, err := p.Read(buf); err != io.EOF {
+		t.Fatalf("Read after the server closed = %v, want EOF", err)
+	}
+}
+
+func TestRFC2217ConfigErrors(t *testing.T) {
+	// Validation fails before anything is sent, so no connection is needed.
+	p := &RFC2217Port{}
+	for field, cfg := range map[string]Config{
+		"BaudRate": {BaudRate: -1, DataBits: DB8, StopBits: SB1, Parity: PN},
+		"DataBits": {BaudRate: BR9600, DataBits: 9, StopBits: SB1, Parity: PN},
+		"StopBits": {BaudRate: BR9600, DataBits: DB8, StopBits: 3, Parity: PN},
+		"Parity":   {BaudRate: BR9600, DataBits: DB8, StopBits: SB1, Parity: 9},
+	} {
+		var cerr *ConfigError
+		if err := p.SetConfig(cfg); !errors.As(err, &cerr) || cerr.Field != field {
+			t.Errorf("SetConfig with a bad %s = %v, want a ConfigError for it", field, err)
+		}
+	}
+}
+
+func TestRFC2217IACRoundTrip(t *testing.T) {
+	// Setup sent by DialRFC2217 with DefaultConfig: option offers and four COM-PORT-OPTION settings.
+	const setupLen = 15 + 10 + 7 + 7 + 7
+	wire := make(chan int, 1)
+	addr := serveRFC2217(t, func(conn net.Conn) {
+		if _, err := io.ReadFull(conn, make([]byte, setupLen)); err != nil {
+			return
+		}
+		// Echo the escaped data stream as is, it is just as valid in the other direction.
+		n, _ := io.Copy(conn, conn)
+		wire <- int(n)
+	})
+
+	p, err := DialRFC2217(addr, DefaultConfig())
+	if err != nil {
+		t.Fatalf("DialRFC2217: %v", err)
+	}
+
+	data := bytes.Repeat([]byte{0xff}, 4096)
+	for i := 0; i < 256; i++ {
+		data = append(data, byte(i), telnetIAC)
+	}
+	if n, err := p.Write(data); n != len(data) || err != nil {
+		t.Fatalf("Write = %v, %v; want %v, nil", n, err, len(data))
+	}
+
+	got := make([]byte, 0, len(data))
+	buf := make([]byte, 1000)
+	for len(got) < len(data) {
+		n, err := p.Read(buf)
+		if err != nil {
+			t.Fatalf("Read after %v bytes: %v", len(got), err)
+		}
+		if n == 0 {
+			t.Fatalf("Read timed out after %v bytes", len(got))
+		}
+		got = append(got, buf[:n]...)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("round trip corrupted the data")
+	}
+
+	p.Close()
+	// Every 0xFF travels doubled on the wire.
+	if n, want := <-wire, len(data)+4096+256+1; n != want {
+		t.Fatalf("server saw %v bytes, want %v", n, want)
+	}
+}
@@ -0,0 +1,14 @@
+package serialport
+
+import "time"
+
+// RS485Config is the RS-485 half-duplex mode of a serial port, in which the driver drives RTS
+// as the transmitter enable of the RS-485 transceiver around each transmission, without the
+// timing gaps of toggling RTS by hand. See SetRS485Config.
+type RS485Config struct {
+	Enabled         bool          // the driver drives RTS around transmissions
+	RTSOnSend       bool          // RTS is logically high while sending
+	RTSAfterSend    bool          // RTS is logically high after sending
+	DelayBeforeSend time.Duration // between setting RTS and sending, rounded up to whole milliseconds
+	DelayAfterSend  time.Duration // between the end of sending and resetting RTS, rounded up to whole milliseconds
+}
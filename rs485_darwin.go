@@ -0,0 +1,13 @@
+package serialport
+
+// RS485Config returns the RS-485 mode of the serial port on Linux.
+// macOS has no RS-485 mode in the serial driver interface, so it always returns ErrUnsupported.
+func (sp *SerialPort) RS485Config() (RS485Config, error) {
+	return RS485Config{}, ErrUnsupported
+}
+
+// SetRS485Config sets the RS-485 mode of the serial port on Linux.
+// macOS has no RS-485 mode in the serial driver interface, so it always returns ErrUnsupported.
+func (sp *SerialPort) SetRS485Config(cfg RS485Config) error {
+	return ErrUnsupported
+}
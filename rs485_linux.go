@@ -0,0 +1,86 @@
+package serialport
+
+import (
+	"fmt"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Reference https://www.kernel.org/doc/html/latest/driver-api/serial/serial-rs485.html
+// struct serial_rs485 {
+//   __u32 flags;
+//   __u32 delay_rts_before_send; /* in milliseconds */
+//   __u32 delay_rts_after_send;  /* in milliseconds */
+//   __u32 padding[5];
+// };
+type serialRS485 struct {
+	Flags              uint32
+	DelayRTSBeforeSend uint32
+	DelayRTSAfterSend  uint32
+	Padding            [5]uint32
+}
+
+// serial_rs485.flags
+const (
+	serRS485Enabled      = 1 << 0
+	serRS485RTSOnSend    = 1 << 1
+	serRS485RTSAfterSend = 1 << 2
+)
+
+// RS485Config returns the RS-485 mode of the serial port with TIOCGRS485.
+// It returns ErrUnsupported if the driver has no RS-485 support.
+func (sp *SerialPort) RS485Config() (RS485Config, error) {
+	var rs serialRS485
+	if err := ioctlPtr(sp.fd, unix.TIOCGRS485, unsafe.Pointer(&rs)); err != nil {
+		if err == unix.ENOTTY || err == unix.EINVAL {
+			return RS485Config{}, ErrUnsupported
+		}
+		return RS485Config{}, err
+	}
+	return rs485FromKernel(&rs), nil
+}
+
+// SetRS485Config sets the RS-485 mode of the serial port with TIOCSRS485.
+// Drivers may adjust the settings, e.g. clamp the delays; RS485Config returns those in effect.
+// It returns ErrUnsupported if the driver has no RS-485 support.
+func (sp *SerialPort) SetRS485Config(cfg RS485Config) error {
+	if cfg.DelayBeforeSend < 0 || cfg.DelayAfterSend < 0 {
+		return fmt.Errorf("serialport: RS-485 delays cannot be negative")
+	}
+	rs := rs485ToKernel(cfg)
+	if err := ioctlPtr(sp.fd, unix.TIOCSRS485, unsafe.Pointer(&rs)); err != nil {
+		if err == unix.ENOTTY || err == unix.EINVAL {
+			return ErrUnsupported
+		}
+		return err
+	}
+	return nil
+}
+
+func rs485ToKernel(cfg RS485Config) serialRS485 {
+	var rs serialRS485
+	if cfg.Enabled {
+		rs.Flags |= serRS485Enabled
+	}
+	if cfg.RTSOnSend {
+		rs.Flags |= serRS485RTSOnSend
+	}
+	if cfg.RTSAfterSend {
+		rs.Flags |= serRS485RTSAfterSend
+	}
+	rs.DelayRTSBeforeSend = uint32((cfg.DelayBeforeSend + time.Millisecond - 1) / time.Millisecond)
+	rs.DelayRTSAfterSend = uint32((cfg.DelayAfterSend + time.Millisecond - 1) / time.Millisecond)
+	return rs
+}
+
+func rs485FromKernel(rs *serialRS485) RS485Config {
+	return RS485Config{
+		Enabled:         rs.Flags&serRS485Enabled != 0,
+		RTSOnSend:       rs.Flags&serRS485RTSOnSend != 0,
+		RTSAfterSend:    rs.Flags&serRS485RTSAfterSend != 0,
+		DelayBeforeSend: time.Duration(rs.DelayRTSBeforeSend) * time.Millisecond,
+		DelayAfterSend:  time.Duration(rs.DelayRTSAfterSend) * time.Millisecond,
+	}
+}
@@ -0,0 +1,106 @@
+package serialport
+
+import "time"
+
+// SelfTestResult reports the outcome of SelfTest.
+type SelfTestResult struct {
+	Sent      int  // number of pattern bytes written
+	Received  int  // number of bytes read back
+	Corrupted int  // number of received bytes that differ from the pattern
+	Passed    bool // all bytes were read back unchanged
+
+	ModemLines bool // the modem lines were tested, so RTSToCTS and DTRToDSR are meaningful
+	RTSToCTS   bool // CTS followed RTS, as with a full loopback jumper
+	DTRToDSR   bool // DSR followed DTR, as with a full loopback jumper
+}
+
+// modemSettleTime is how long SelfTest lets an input line follow the output line looped back to it.
+const modemSettleTime = 10 * time.Millisecond
+
+// SelfTest checks the serial port against a loopback jumper (TX shorted to RX).
+// It discards any pending data, writes a pattern of every character value
+// and reads it back within one second plus twice the transmission time.
+// If the port has modem lines and does not use hardware flow control, SelfTest also toggles RTS and DTR
+// and reports whether CTS and DSR follow them, as with a full loopback jumper; both are left asserted.
+// That test does not affect Passed, as a plain TX-RX jumper leaves the modem lines open.
+// A result that did not pass is not an error; err reports I/O failures only.
+func (sp *SerialPort) SelfTest() (res SelfTestResult, err error) {
+	cfg, err := sp.Config()
+	if err != nil {
+		return
+	}
+
+	pattern := make([]byte, 256)
+	for i := range pattern {
+		pattern[i] = byte(i) & byte(1<<cfg.DataBits-1)
+	}
+
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	if err = sp.Flush(); err != nil {
+		return
+	}
+
+	deadline := time.Now().Add(time.Second + 2*time.Duration(len(pattern))*charTime(cfg))
+	if res.Sent, err = sp.write(pattern); err != nil {
+		return
+	}
+
+	echo := make([]byte, len(pattern))
+	for res.Received < len(echo) {
+		wait := remaining(deadline)
+		if wait == 0 {
+			break
+		}
+		var n int
+		n, err = sp.readTimeout(echo[res.Received:], wait)
+		res.Received += n
+		if err == ErrTimeout {
+			err = nil
+			break
+		}
+		if err != nil {
+			return
+		}
+	}
+
+	for i := 0; i < res.Received; i++ {
+		if echo[i] != pattern[i] {
+			res.Corrupted++
+		}
+	}
+	res.Passed = res.Received == res.Sent && res.Corrupted == 0
+
+	if _, merr := sp.ModemStatus(); merr != nil || cfg.FlowControl == FlowHardware {
+		return
+	}
+	res.ModemLines = true
+	if res.RTSToCTS, err = sp.followsLine(sp.SetRTS, ModemCTS); err != nil {
+		return
+	}
+	res.DTRToDSR, err = sp.followsLine(sp.SetDTR, ModemDSR)
+	return
+}
+
+// followsLine reports whether the input line in follows the output line that set drives,
+// which is left asserted.
+func (sp *SerialPort) followsLine(set func(bool) error, in ModemBits) (bool, error) {
+	follows := true
+	for _, on := range []bool{false, true} {
+		if err := set(on); err != nil {
+			return false, err
+		}
+		time.Sleep(modemSettleTime)
+		status, err := sp.ModemStatus()
+		if err != nil {
+			return false, err
+		}
+		if (status&in != 0) != on {
+			follows = false
+		}
+	}
+	return follows, nil
+}
@@ -1,20 +1,167 @@
 // Package serialport allows you to easily access serial ports
+//
+// Readers that collect data until a condition is met (ReadUpTo, ReadBatch, ReadUntil, Expect) return
+// the data received so far together with the error, e.g. ErrTimeout, for debugging or recovery;
+// on timeout that data is never a nil slice. Line and message readers (ReadLine, ReadLines, ReadUntilLine,
+// PacketPort.ReadMessage) instead keep a partial line or message buffered, so that the next call can complete it.
+//
+// A SerialPort may be read by one goroutine while another writes to it, e.g. to receive asynchronous
+// events while sending requests: reads and writes have separate locks and never wait for each other.
+// Concurrent Reads, like concurrent Writes, are serialized rather than interleaved, but which of them gets
+// which bytes is unspecified, so a stream should have a single reader and a single writer.
 package serialport
 
-import "time"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrTimeout is returned when an operation does not complete within its timeout.
+// It implements a Timeout() bool method that reports true, like the net package errors.
+var ErrTimeout error = &timeoutError{}
+
+type timeoutError struct{}
+
+func (e *timeoutError) Error() string   { return "serialport: timeout" }
+func (e *timeoutError) Timeout() bool   { return true }
+func (e *timeoutError) Temporary() bool { return true }
+
+// ErrUnsupported is returned by operations that the platform or the serial port does not support.
+var ErrUnsupported = errors.New("serialport: operation not supported")
+
+// ErrClosed is returned when closing a serial port that is already closed,
+// and by a Read that Close interrupted.
+var ErrClosed = errors.New("serialport: port closed")
+
+// ErrNotFound is returned by Open when the serial port does not exist, e.g. an unplugged USB adapter.
+// Test for it with errors.Is, as the error returned also wraps that of the platform.
+var ErrNotFound = errors.New("serialport: port not found")
+
+// ErrPortBusy is returned by Open when the serial port is in use, e.g. by another process.
+// Test for it with errors.Is, as the error returned also wraps that of the platform.
+// A port that the user may not open at all wraps the platform's permission error,
+// so errors.Is(err, os.ErrPermission) reports it.
+var ErrPortBusy = errors.New("serialport: port busy")
+
+// openError is returned when a serial port cannot be opened.
+// It wraps the error of the platform and matches kind with errors.Is.
+type openError struct {
+	name string
+	err  error // error of the platform
+	kind error // ErrNotFound, ErrPortBusy or nil
+}
+
+func newOpenError(name string, err error) error {
+	return &openError{name: name, err: err, kind: openErrorKind(err)}
+}
+
+func (e *openError) Error() string        { return "serialport: cannot open " + e.name + ": " + e.err.Error() }
+func (e *openError) Unwrap() error        { return e.err }
+func (e *openError) Is(target error) bool { return e.kind != nil && target == e.kind }
+
+// A ConfigError reports a Config that cannot be applied, e.g. by Open or SetConfig,
+// with the name of the offending field so that callers can branch on it.
+type ConfigError struct {
+	Field string // e.g. "StopBits"
+	Msg   string // what is wrong, without the "serialport: " prefix
+}
+
+func (e *ConfigError) Error() string { return "serialport: " + e.Msg }
+
+// configErrorf returns a ConfigError for field with a message formatted like fmt.Sprintf.
+func configErrorf(field string, format string, a ...interface{}) error {
+	return &ConfigError{Field: field, Msg: fmt.Sprintf(format, a...)}
+}
+
+// ErrFlowControlStall is returned by Write instead of ErrTimeout when nothing at all was transmitted
+// during the write timeout although data was queued, which usually means that flow control is holding
+// the output, e.g. the peer never asserts CTS. Like ErrTimeout, it implements a Timeout() bool method that reports true.
+var ErrFlowControlStall error = &stallError{}
+
+type stallError struct{}
+
+func (e *stallError) Error() string {
+	return "serialport: write stalled by flow control, the peer may not be asserting CTS"
+}
+func (e *stallError) Timeout() bool   { return true }
+func (e *stallError) Temporary() bool { return true }
+
+// Is reports a stall as a timeout, so that errors.Is(err, ErrTimeout) holds for it too.
+func (e *stallError) Is(target error) bool { return target == ErrTimeout }
 
 // Config for serial port configuration:
 //     BaudRate is the baud rate of serial transmission
 //     DataBits is the number of bits per character
 //     StopBits is the number of stop bits
 //     Parity is a method of detecting errors in transmission
-//     Timeout is the serial port Read() timeout
+//     Timeout is the serial port Read() timeout, 0 means Read() blocks until at least one byte is read
+//     WriteTimeout is the serial port Write() timeout, 0 means Write() blocks until done
+//     HangupOnClose drops DTR and RTS when the port is last closed (Linux and macOS HUPCL, ignored on Windows);
+//       DefaultConfig sets it, clear it to keep boards that reset on DTR (Arduino) running across Close
+//     NoResetOnOpen keeps DTR and RTS deasserted through Open, so boards that reset on DTR/RTS (ESP32, Arduino) keep running
+//     Exclusive makes Open fail with ErrPortBusy if another Exclusive open holds the port, and keeps other processes from opening it
+//       (Linux and macOS TIOCEXCL and flock, root can still open it; on Windows COM ports are always exclusive)
+//     Mode opens the port read-write (the default), read-only or write-only; it takes effect at Open and Reopen only
+//     RetryEmptyReads makes Read() retry reads that return no data before Timeout has elapsed
+//     LowercaseInput maps received uppercase letters to lowercase, for legacy uppercase-only terminals (Linux IUCLC only)
+//     UppercaseOutput maps sent lowercase letters to uppercase, for legacy uppercase-only terminals (Linux OLCUC only)
+//     FlowControl is the flow control method: none, hardware (RTS/CTS) or software (XON/XOFF)
+//     XonChar and XoffChar are the characters of software flow control, 0 means the standard DC1 (0x11) and DC3 (0x13)
+//     MarkParityErrors marks received characters that had a parity or framing error in the data read, see DecodeParityMarks
+//       (Linux and macOS PARMRK only)
+//     StrictSevenBit makes Write() fail on bytes with the high bit set when DataBits is 7, instead of silently truncating them
 type Config struct {
-	BaudRate int
-	DataBits int
-	StopBits int
-	Parity   int
-	Timeout  time.Duration
+	BaudRate         int
+	DataBits         DataBits
+	StopBits         StopBits
+	Parity           Parity
+	FlowControl      int
+	XonChar          byte
+	XoffChar         byte
+	Timeout          time.Duration
+	WriteTimeout     time.Duration
+	HangupOnClose    bool
+	NoResetOnOpen    bool
+	Exclusive        bool
+	Mode             AccessMode
+	RetryEmptyReads  bool
+	LowercaseInput   bool
+	UppercaseOutput  bool
+	MarkParityErrors bool
+	StrictSevenBit   bool
+}
+
+// Equal reports whether c and o describe the same configuration.
+func (c Config) Equal(o Config) bool {
+	return c == o
+}
+
+// Summary returns the configuration in the usual terminal program notation,
+// e.g. "115200 8N1 rtscts timeout=100ms". Flow control and timeouts are only listed if set.
+func (c Config) Summary() string {
+	parity := "?"
+	if c.Parity >= PN && c.Parity <= PS {
+		parity = string("NOEMS"[c.Parity])
+	}
+	s := fmt.Sprintf("%v %v%v%v", c.BaudRate, c.DataBits, parity, c.StopBits)
+
+	switch c.FlowControl {
+	case FlowHardware:
+		s += " rtscts"
+	case FlowSoftware:
+		s += " xonxoff"
+	}
+	if c.Timeout > 0 {
+		s += " timeout=" + c.Timeout.String()
+	}
+	if c.WriteTimeout > 0 {
+		s += " write-timeout=" + c.WriteTimeout.String()
+	}
+	return s
 }
 
 // BaudRate
@@ -35,42 +182,563 @@ const (
 	BR256000 = 256000 // 256000 bps
 )
 
+// DataBits is the number of data bits per character.
+type DataBits int
+
 // DataBits
 const (
-	DB5 = 5 // 5 data bits
-	DB6 = 6 // 6 data bits
-	DB7 = 7 // 7 data bits
-	DB8 = 8 // 8 data bits
+	DB5 DataBits = 5 // 5 data bits
+	DB6 DataBits = 6 // 6 data bits
+	DB7 DataBits = 7 // 7 data bits
+	DB8 DataBits = 8 // 8 data bits
 )
 
+// String returns the number of data bits, e.g. "8".
+func (d DataBits) String() string {
+	return strconv.Itoa(int(d))
+}
+
+// StopBits is the number of stop bits. 1.5 stop bits are encoded as 15, not 3:
+// always use the constants, or ParseStopBits for user input.
+type StopBits int
+
 // StopBits
 const (
-	SB1   = 1  // 1 stop bit
-	SB1_5 = 15 // 1.5 stop bits
-	SB2   = 2  // 2 stop bits
+	SB1   StopBits = 1  // 1 stop bit
+	SB1_5 StopBits = 15 // 1.5 stop bits
+	SB2   StopBits = 2  // 2 stop bits
 )
 
+// String returns "1", "1.5" or "2", or "StopBits(n)" for an invalid value.
+func (s StopBits) String() string {
+	switch s {
+	case SB1:
+		return "1"
+	case SB1_5:
+		return "1.5"
+	case SB2:
+		return "2"
+	}
+	return "StopBits(" + strconv.Itoa(int(s)) + ")"
+}
+
+// ParseStopBits parses "1", "1.5" or "2", e.g. from a command line flag or a configuration file.
+func ParseStopBits(s string) (StopBits, error) {
+	switch strings.TrimSpace(s) {
+	case "1":
+		return SB1, nil
+	case "1.5":
+		return SB1_5, nil
+	case "2":
+		return SB2, nil
+	}
+	return 0, fmt.Errorf("serialport: invalid stop bits %q, want 1, 1.5 or 2", s)
+}
+
+// Parity is the parity method of each character.
+type Parity int
+
 // Parity
 const (
-	PN = 0 // No parity
-	PO = 1 // Odd parity
-	PE = 2 // Even parity
-	PM = 3 // Mark parity
-	PS = 4 // Space parity
+	PN Parity = 0 // No parity
+	PO Parity = 1 // Odd parity
+	PE Parity = 2 // Even parity
+	PM Parity = 3 // Mark parity
+	PS Parity = 4 // Space parity
 )
 
+var parityNames = []string{PN: "none", PO: "odd", PE: "even", PM: "mark", PS: "space"}
+
+// String returns "none", "odd", "even", "mark" or "space", or "Parity(n)" for an invalid value.
+func (p Parity) String() string {
+	if p >= 0 && int(p) < len(parityNames) {
+		return parityNames[p]
+	}
+	return "Parity(" + strconv.Itoa(int(p)) + ")"
+}
+
+// FlowControl
+const (
+	FlowNone     = 0 // No flow control
+	FlowHardware = 1 // Hardware (RTS/CTS) flow control
+	FlowSoftware = 2 // Software (XON/XOFF) flow control
+)
+
+// AccessMode is the direction in which Open opens the serial port. A read-only port can monitor
+// a line without being able to disturb it; Write on it fails. Note that the OS may still assert
+// DTR and RTS on open, set Config.NoResetOnOpen to keep them deasserted.
+type AccessMode int
+
+// AccessMode
+const (
+	ModeReadWrite AccessMode = 0 // Read and write
+	ModeReadOnly  AccessMode = 1 // Read only
+	ModeWriteOnly AccessMode = 2 // Write only
+)
+
+var accessModeNames = []string{ModeReadWrite: "read-write", ModeReadOnly: "read-only", ModeWriteOnly: "write-only"}
+
+// String returns "read-write", "read-only" or "write-only", or "AccessMode(n)" for an invalid value.
+func (m AccessMode) String() string {
+	if m >= 0 && int(m) < len(accessModeNames) {
+		return accessModeNames[m]
+	}
+	return "AccessMode(" + strconv.Itoa(int(m)) + ")"
+}
+
+// Standard software flow control characters
+const (
+	defaultXonChar  = 0x11 // DC1
+	defaultXoffChar = 0x13 // DC3
+)
+
+// xonChar returns the XON character of c, applying the default.
+func (c Config) xonChar() byte {
+	if c.XonChar == 0 {
+		return defaultXonChar
+	}
+	return c.XonChar
+}
+
+// xoffChar returns the XOFF character of c, applying the default.
+func (c Config) xoffChar() byte {
+	if c.XoffChar == 0 {
+		return defaultXoffChar
+	}
+	return c.XoffChar
+}
+
+// flowChars returns the software flow control characters as Config reports them:
+// 0 for the standard ones, as long as cached does not spell them out.
+func flowChars(xon, xoff byte, cached Config) (byte, byte) {
+	if xon == cached.xonChar() {
+		xon = cached.XonChar
+	} else if xon == defaultXonChar {
+		xon = 0
+	}
+	if xoff == cached.xoffChar() {
+		xoff = cached.XoffChar
+	} else if xoff == defaultXoffChar {
+		xoff = 0
+	}
+	return xon, xoff
+}
+
 // DefaultConfig returns a default serial port configuration:
 //     115200 bps baudrate
 //     8 data bits
 //     1 stop bit
 //     no parity
 //     100 ms timeout
+//     hang up on close
 func DefaultConfig() Config {
 	return Config{
-		BaudRate: BR115200,
-		DataBits: DB8,
-		StopBits: SB1,
-		Parity:   PN,
-		Timeout:  100 * time.Millisecond,
+		BaudRate:      BR115200,
+		DataBits:      DB8,
+		StopBits:      SB1,
+		Parity:        PN,
+		Timeout:       100 * time.Millisecond,
+		HangupOnClose: true,
+	}
+}
+
+// DefaultReadWriteConfig returns DefaultConfig with separate Read() and Write() timeouts.
+// Recommended values for some common protocols:
+//     Modbus RTU: 1 s read (slave response timeout), 100 ms write
+//     NMEA 0183:  1.5 s read (longer than the 1 Hz sentence interval), 1 s write
+//     AT modem:   5 s read (longer for dialing or network commands), 1 s write
+func DefaultReadWriteConfig(readTimeout, writeTimeout time.Duration) Config {
+	cfg := DefaultConfig()
+	cfg.Timeout = readTimeout
+	cfg.WriteTimeout = writeTimeout
+	return cfg
+}
+
+// charTime returns the time it takes to transmit one character with cfg:
+// a start bit, the data bits, an optional parity bit and the stop bits.
+func charTime(cfg Config) time.Duration {
+	if cfg.BaudRate <= 0 {
+		return 0
+	}
+	bits := 1 + cfg.DataBits + 1
+	if cfg.Parity != PN {
+		bits++
+	}
+	if cfg.StopBits != SB1 {
+		bits++
+	}
+	return time.Duration(bits) * time.Second / time.Duration(cfg.BaudRate)
+}
+
+// SetUserData attaches arbitrary application data to the serial port.
+// The package never interprets it.
+func (sp *SerialPort) SetUserData(data interface{}) {
+	sp.umu.Lock()
+	sp.userData = data
+	sp.umu.Unlock()
+}
+
+// UserData returns the data attached by SetUserData, or nil.
+func (sp *SerialPort) UserData() interface{} {
+	sp.umu.Lock()
+	defer sp.umu.Unlock()
+	return sp.userData
+}
+
+// Name returns the name the serial port was opened with, e.g. a /dev/serial/by-id/... symlink
+// rather than the device it resolves to.
+func (sp *SerialPort) Name() string {
+	return sp.name
+}
+
+// IsOpen reports whether the serial port is open and its device is still there, so that a supervisor
+// loop can decide when to Reopen. Like a Manager, it checks for a hangup and asks the driver for the port
+// settings, which reads no data; it is false once e.g. a USB adapter has been unplugged, after Close
+// and after a failed Reopen.
+func (sp *SerialPort) IsOpen() bool {
+	if !sp.acquire() {
+		return false
+	}
+	defer sp.release()
+	return sp.probe() == nil
+}
+
+// AppliedConfig returns the configuration of the last successful SetConfig (or Open) without
+// querying the driver, unlike Config, which reads the settings in effect back.
+func (sp *SerialPort) AppliedConfig() Config {
+	return sp.config()
+}
+
+// String returns the name of the serial port followed by the Summary of the configuration in effect,
+// e.g. "/dev/ttyUSB0 115200 8N1 rtscts timeout=100ms", for log lines.
+// If the configuration cannot be read back, that of the last SetConfig is shown.
+func (sp *SerialPort) String() string {
+	cfg, err := sp.Config()
+	if err != nil {
+		cfg = sp.config()
+	}
+	return sp.name + " " + cfg.Summary()
+}
+
+// OpenContext is like Open but returns ctx.Err() once ctx is done, e.g. to abandon a Bluetooth SPP port
+// whose open blocks while the RFCOMM channel is established. An open in progress cannot be interrupted
+// on every platform, so it is left to complete in the background and the port is then closed, leaking nothing.
+func OpenContext(ctx context.Context, name string, cfg Config) (*SerialPort, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		sp  *SerialPort
+		err error
+	}
+	opened := make(chan result, 1)
+	go func() {
+		sp, err := Open(name, cfg)
+		opened <- result{sp, err}
+	}()
+
+	select {
+	case r := <-opened:
+		return r.sp, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-opened; r.err == nil {
+				r.sp.Close()
+			}
+		}()
+		return nil, ctx.Err()
+	}
+}
+
+// Reopen closes the handle of the serial port and opens the same name again with the configuration
+// of the last SetConfig, e.g. after a USB adapter was unplugged and plugged back in, so that the
+// SerialPort stays usable without building a new one. It waits for any Read or Write in progress.
+// On Windows the modem line waits are tied to the old handle: WatchModemLines closes its channel
+// and WaitForModemChange returns an error, so call them again after Reopen.
+// If the device cannot be opened, e.g. because it is still missing, Reopen returns the error of Open,
+// which names the device and matches ErrNotFound if it is missing, and every operation fails until a later Reopen succeeds. Reopening a closed serial port returns ErrClosed.
+func (sp *SerialPort) Reopen() error {
+	if sp.isClosed() {
+		return ErrClosed
+	}
+
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	sp.discardBuffered()
+	return sp.reopen(sp.config())
+}
+
+// config returns the last applied configuration.
+func (sp *SerialPort) config() Config {
+	sp.cmu.Lock()
+	defer sp.cmu.Unlock()
+	return sp.cfg
+}
+
+func (sp *SerialPort) setConfig(cfg Config) {
+	sp.cmu.Lock()
+	sp.cfg = cfg
+	sp.cmu.Unlock()
+}
+
+// EnsureConfig applies cfg only if it differs from the configuration in effect, as reported by Config,
+// so that periodically re-asserting the desired settings does not glitch the line.
+// It reports whether the configuration was changed.
+func (sp *SerialPort) EnsureConfig(cfg Config) (changed bool, err error) {
+	cur, err := sp.Config()
+	if err != nil {
+		return false, err
+	}
+	if cur.Equal(cfg) {
+		return false, nil
+	}
+	if err := sp.SetConfig(cfg); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WithBaud switches the serial port to baud, runs fn, and then restores the previous configuration,
+// even if fn fails or panics, e.g. to upload firmware faster than the control rate.
+// Output is drained before each switch, so no byte is sent at the wrong rate.
+func (sp *SerialPort) WithBaud(baud int, fn func() error) (err error) {
+	prev := sp.config()
+	cfg := prev
+	cfg.BaudRate = baud
+
+	if err := sp.drain(); err != nil {
+		return err
+	}
+	if err := sp.SetConfig(cfg); err != nil {
+		return err
+	}
+	defer func() {
+		derr := sp.drain()
+		serr := sp.SetConfig(prev)
+		if err == nil {
+			err = derr
+		}
+		if err == nil {
+			err = serr
+		}
+	}()
+
+	return fn()
+}
+
+// defaultBreakDuration is the break length of SendBreak(0), as used by common terminal programs.
+const defaultBreakDuration = 250 * time.Millisecond
+
+// SendBreak holds the line in the break condition (continuous space) for d, or 250 ms if d is 0,
+// e.g. to reset a downstream device. Pending output is transmitted first.
+func (sp *SerialPort) SendBreak(d time.Duration) error {
+	if d <= 0 {
+		d = defaultBreakDuration
+	}
+
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+
+	if err := sp.drain(); err != nil {
+		return err
+	}
+	if err := sp.setBreak(true); err != nil {
+		return err
+	}
+	time.Sleep(d)
+	return sp.setBreak(false)
+}
+
+// closeDone returns a channel that is closed when Close is called.
+func (sp *SerialPort) closeDone() <-chan struct{} {
+	sp.lmu.Lock()
+	defer sp.lmu.Unlock()
+	if sp.done == nil {
+		sp.done = make(chan struct{})
+	}
+	return sp.done
+}
+
+// isClosed reports whether Close has been called.
+func (sp *SerialPort) isClosed() bool {
+	sp.lmu.Lock()
+	defer sp.lmu.Unlock()
+	return sp.closed
+}
+
+// acquire registers a background goroutine that uses the serial port, which Close waits for.
+// It reports false if the serial port is closed; otherwise release must be called when done.
+// It waits while the background is paused, see pauseBackground.
+func (sp *SerialPort) acquire() bool {
+	sp.lmu.Lock()
+	defer sp.lmu.Unlock()
+	sp.waitPausedLocked()
+	if sp.closed {
+		return false
+	}
+	sp.bg.Add(1)
+	return true
+}
+
+func (sp *SerialPort) release() {
+	sp.bg.Done()
+}
+
+// pauseBackground makes acquire and stopBackground wait until resumeBackground is called,
+// so that the handle can be swapped once the goroutines already registered have returned.
+// It reports false if the serial port is closed; otherwise resumeBackground must be called when done.
+func (sp *SerialPort) pauseBackground() bool {
+	sp.lmu.Lock()
+	defer sp.lmu.Unlock()
+	sp.waitPausedLocked()
+	if sp.closed {
+		return false
+	}
+	sp.paused = make(chan struct{})
+	return true
+}
+
+func (sp *SerialPort) resumeBackground() {
+	sp.lmu.Lock()
+	close(sp.paused)
+	sp.paused = nil
+	sp.lmu.Unlock()
+}
+
+// isPausedLocked reports whether pauseBackground is in effect. sp.lmu must be held.
+func (sp *SerialPort) isPausedLocked() bool {
+	return sp.paused != nil
+}
+
+// waitPausedLocked waits for resumeBackground if pauseBackground is in effect. sp.lmu must be held.
+func (sp *SerialPort) waitPausedLocked() {
+	for sp.paused != nil {
+		paused := sp.paused
+		sp.lmu.Unlock()
+		<-paused
+		sp.lmu.Lock()
+	}
+}
+
+// stopBackground marks the serial port closed, signals background goroutines through closeDone,
+// interrupts reads waiting for data and waits for them all to finish, so that none uses the handle once it is closed.
+// It reports false if the serial port was already closed.
+func (sp *SerialPort) stopBackground() bool {
+	sp.lmu.Lock()
+	sp.waitPausedLocked()
+	if sp.closed {
+		sp.lmu.Unlock()
+		return false
+	}
+	sp.closed = true
+	if sp.done == nil {
+		sp.done = make(chan struct{})
+	}
+	close(sp.done)
+	sp.lmu.Unlock()
+
+	sp.interrupt()
+	sp.bg.Wait()
+	return true
+}
+
+// cookedMode reports whether SetCookedMode is in effect.
+func (sp *SerialPort) cookedMode() bool {
+	sp.cmu.Lock()
+	defer sp.cmu.Unlock()
+	return sp.cooked
+}
+
+// SetLinger sets how long Close waits for pending output to be transmitted, like SO_LINGER:
+//     d == 0: Close discards nothing and does not wait (the default);
+//     d > 0:  Close waits up to d, then discards what is still pending;
+//     d < 0:  Close waits until all pending output has been transmitted.
+func (sp *SerialPort) SetLinger(d time.Duration) {
+	sp.cmu.Lock()
+	sp.linger = d
+	sp.cmu.Unlock()
+}
+
+// lingerDrain waits for pending output according to SetLinger.
+func (sp *SerialPort) lingerDrain() {
+	sp.cmu.Lock()
+	d := sp.linger
+	sp.cmu.Unlock()
+
+	switch {
+	case d == 0:
+		return
+	case d < 0:
+		sp.drain()
+		return
+	}
+
+	deadline := time.Now().Add(d)
+	for {
+		n, err := sp.outWaiting()
+		if err != nil || n == 0 {
+			return
+		}
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			sp.flushOutput()
+			return
+		}
+		if wait > 10*time.Millisecond {
+			wait = 10 * time.Millisecond
+		}
+		time.Sleep(wait)
+	}
+}
+
+// SampleFramingErrors reads and discards received data for d, counting the bytes received
+// and the framing errors detected meanwhile. A high rate of framing errors per byte usually means
+// that the baud rate is slightly off, or that a clock is inaccurate.
+// Windows only reports whether framing errors occurred, so there framingErrs counts the reads
+// during which at least one did, a lower bound.
+func (sp *SerialPort) SampleFramingErrors(d time.Duration) (framingErrs int, bytesRx int, err error) {
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	prev, err := sp.framingErrorCount()
+	if err != nil {
+		return
+	}
+	cur := prev
+
+	deadline := time.Now().Add(d)
+	buf := make([]byte, 256)
+	for {
+		wait := time.Until(deadline)
+		if wait <= 0 {
+			break
+		}
+		n, rerr := sp.readTimeout(buf, wait)
+		bytesRx += n
+		if rerr != nil && rerr != ErrTimeout {
+			return cur - prev, bytesRx, rerr
+		}
+		if cur, err = sp.framingErrorCount(); err != nil {
+			return
+		}
+	}
+
+	return cur - prev, bytesRx, nil
+}
+
+// BaudRateError reports the baud rate requested by Config.BaudRate, the rate the hardware
+// actually generates, and the difference in percent. Errors beyond 2-3% usually cause framing errors.
+func (sp *SerialPort) BaudRateError() (requested int, actual int, percentErr float64, err error) {
+	requested = sp.config().BaudRate
+	if actual, err = sp.actualBaudRate(requested); err != nil {
+		return
+	}
+	if requested > 0 {
+		percentErr = float64(actual-requested) / float64(requested) * 100
 	}
+	return
 }
@@ -0,0 +1,824 @@
+package serialport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"sync"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const deciseconds = time.Millisecond * 100 // 1/10 second
+
+// Reference IOKit/serial/ioss.h:
+// #define IOSSIOSPEED _IOW('T', 2, speed_t)
+const darwinIOSSIOSPEED = 0x80085402
+
+// Reference sys/filio.h:
+// #define FIONREAD _IOR('f', 127, int)
+const darwinFIONREAD = 0x4004667f
+
+// Reference sys/fcntl.h, the queues TIOCFLUSH discards.
+const (
+	darwinFREAD  = 0x0001
+	darwinFWRITE = 0x0002
+)
+
+// darwinStandardBauds are the rates termios accepts directly, any other rate is set with IOSSIOSPEED.
+var darwinStandardBauds = map[int]bool{
+	50: true, 75: true, 110: true, 134: true, 150: true, 200: true, 300: true, 600: true, 1200: true,
+	1800: true, 2400: true, 4800: true, 7200: true, 9600: true, 14400: true, 19200: true, 28800: true,
+	38400: true, 57600: true, 76800: true, 115200: true, 230400: true,
+}
+
+func ioctlPtr(fd int, req uint, arg unsafe.Pointer) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
+// A SerialPort is a serial port. This must be instantiated by calling Open() and not manually.
+type SerialPort struct {
+	fd   int
+	name string
+	excl bool // fd holds the locks of Config.Exclusive, see lockExclusive
+
+	rwake *wakePipe // interrupts waits to read, see waitIOContext
+	wwake *wakePipe // interrupts waits to write with a context
+
+	cmu       sync.Mutex        // guards cfg, linger, cooked, errFilter and the deadlines
+	cfg       Config            // last applied configuration
+	linger    time.Duration     // see SetLinger
+	cooked    bool              // see SetCookedMode
+	errFilter func(error) error // see SetErrorFilter
+	rdeadline time.Time         // see SetReadDeadline
+	wdeadline time.Time         // see SetWriteDeadline
+
+	rmu sync.Mutex // serializes reads
+	wmu sync.Mutex // serializes writes
+
+	bmu  sync.Mutex // guards rbuf
+	rbuf []byte     // data read ahead by the line reader, returned before new data
+
+	umu      sync.Mutex // guards userData
+	userData interface{}
+
+	lmu    sync.Mutex     // guards closed, done and paused
+	closed bool           // set by Close
+	paused chan struct{}  // closed when Reopen resumes, see pauseBackground
+	done   chan struct{}  // closed by Close to stop background goroutines
+	bg     sync.WaitGroup // background goroutines using fd, waited for by Close
+}
+
+// Open opens a serial port, normally one of the /dev/cu.* call-out devices.
+// The device is opened non-blocking, so that opening a /dev/tty.* device does not wait for DCD.
+func Open(name string, cfg Config) (sp *SerialPort, err error) {
+	fd, err := unix.Open(name, openFlags(cfg.Mode)|unix.O_NOCTTY|unix.O_NONBLOCK, 0666)
+	if err != nil {
+		return nil, newOpenError(name, err)
+	}
+	sp = &SerialPort{fd: fd, name: name}
+	if err = sp.openWake(); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	if cfg.Exclusive {
+		if err = sp.lockExclusive(); err != nil {
+			sp.Close()
+			return nil, err
+		}
+	}
+	if cfg.NoResetOnOpen {
+		// Ports without modem lines do not support TIOCMBIC.
+		unix.IoctlSetPointerInt(sp.fd, unix.TIOCMBIC, unix.TIOCM_DTR|unix.TIOCM_RTS)
+	}
+	if _, err = unix.FcntlInt(uintptr(fd), unix.F_SETFL, 0); err != nil {
+		sp.Close()
+		return nil, err
+	}
+
+	if err = sp.SetConfig(cfg); err != nil {
+		sp.Close()
+		return nil, err
+	}
+
+	return
+}
+
+// lockExclusive takes an advisory flock on the fd, which fails with ErrPortBusy while another
+// Exclusive open holds it, even one by root, and sets TIOCEXCL, so that any later open(2)
+// of the tty by a process without CAP_SYS_ADMIN fails with EBUSY. Drivers without TIOCEXCL
+// are left with the flock alone.
+func (sp *SerialPort) lockExclusive() error {
+	if err := unix.Flock(sp.fd, unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		return newOpenError(sp.name, err)
+	}
+	unix.IoctlSetInt(sp.fd, unix.TIOCEXCL, 0)
+	sp.excl = true
+	return nil
+}
+
+// unlockExclusive clears TIOCEXCL before the fd is closed, which the kernel would otherwise keep
+// for as long as another process has the tty open. The flock goes away with the fd.
+func (sp *SerialPort) unlockExclusive() {
+	if sp.excl {
+		unix.IoctlSetInt(sp.fd, unix.TIOCNXCL, 0)
+		sp.excl = false
+	}
+}
+
+// openFlags returns the access mode flags of open(2) for mode.
+func openFlags(mode AccessMode) int {
+	switch mode {
+	case ModeReadOnly:
+		return unix.O_RDONLY
+	case ModeWriteOnly:
+		return unix.O_WRONLY
+	}
+	return unix.O_RDWR
+}
+
+// openErrorKind returns the sentinel error that err of open(2) matches, if any.
+func openErrorKind(err error) error {
+	switch err {
+	case unix.ENOENT, unix.ENODEV, unix.ENXIO:
+		return ErrNotFound
+	case unix.EBUSY, unix.EWOULDBLOCK:
+		return ErrPortBusy
+	}
+	return nil
+}
+
+// OpenNoConfig opens a serial port without changing its settings, e.g. to monitor a link
+// configured by another tool. Config reports the settings found in place,
+// and they are kept until SetConfig is called; in particular the port is not switched to raw mode.
+func OpenNoConfig(name string) (sp *SerialPort, err error) {
+	fd, err := unix.Open(name, unix.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0666)
+	if err != nil {
+		return nil, newOpenError(name, err)
+	}
+	sp = &SerialPort{fd: fd, name: name}
+	if err = sp.openWake(); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	if _, err = unix.FcntlInt(uintptr(fd), unix.F_SETFL, 0); err != nil {
+		sp.Close()
+		return nil, err
+	}
+	cfg, err := sp.Config()
+	if err != nil {
+		sp.Close()
+		return nil, err
+	}
+	sp.setConfig(cfg)
+
+	return
+}
+
+// Close close the serial port.
+// Pending output is handled according to SetLinger. Background goroutines using the serial port,
+// such as WatchModemLines and a Manager supervising it, are stopped before the fd is closed.
+// A Read waiting for data returns ErrClosed at once: its poll(2) is woken through a pipe.
+// Closing an already closed serial port returns ErrClosed.
+func (sp *SerialPort) Close() error {
+	if !sp.stopBackground() {
+		return ErrClosed
+	}
+	sp.lingerDrain()
+	sp.unlockExclusive()
+	err := unix.Close(sp.fd)
+	sp.closeWake()
+	return err
+}
+
+// Fd returns the file descriptor of the serial port, e.g. to issue ioctls such as TIOCMBIS
+// that this package does not wrap. It is an escape hatch and unsafe: the descriptor is shared
+// with the SerialPort, so changing its termios or file status flags can break later calls,
+// and it is only valid until Close.
+func (sp *SerialPort) Fd() uintptr {
+	return uintptr(sp.fd)
+}
+
+// reopen replaces the device open on the fd with name opened again with cfg. The fd number never changes,
+// as background goroutines such as WatchModemLines and calls such as ModemStatus use it without holding rmu
+// and wmu: the device is closed by parking the fd, and the fresh one is moved onto it with dup2(2).
+func (sp *SerialPort) reopen(cfg Config) error {
+	sp.unlockExclusive()
+	if err := sp.park(); err != nil {
+		return err
+	}
+	fresh, err := Open(sp.name, cfg)
+	if err != nil {
+		return err
+	}
+	// The wake pipes of the serial port are kept, as a Read may be about to wait on them.
+	fresh.closeWake()
+	err = unix.Dup2(fresh.fd, sp.fd)
+	unix.Close(fresh.fd)
+	if err != nil {
+		return err
+	}
+	sp.excl = fresh.excl
+
+	if sp.cookedMode() {
+		err = sp.SetConfig(cfg)
+	}
+	return err
+}
+
+// park closes the device open on the fd without releasing the fd number, which another file could reuse,
+// by making the fd refer to a pipe without writer instead: it reads as hung up, and every write and ioctl fails.
+func (sp *SerialPort) park() error {
+	var p [2]int
+	if err := unix.Pipe(p[:]); err != nil {
+		return err
+	}
+	unix.Close(p[1])
+	err := unix.Dup2(p[0], sp.fd)
+	unix.Close(p[0])
+	return err
+}
+
+// read reads up to len(b) bytes from the serial port.
+// It returns io.EOF once the other end has gone away: a hung up tty reads 0 bytes
+// and reports POLLHUP, which distinguishes it from a read that simply timed out.
+func (sp *SerialPort) read(b []byte) (n int, err error) {
+	if timeout := sp.config().Timeout; timeout > 0 && !exactVTIME(timeout) {
+		if err = sp.waitIO(unix.POLLIN, timeout); err != nil {
+			if err == ErrTimeout {
+				err = nil
+			}
+			return
+		}
+	}
+	n, err = unix.Read(sp.fd, b)
+	if (n == 0 && err == nil) || err == unix.EIO {
+		if sp.hungUp() {
+			return 0, io.EOF
+		}
+	}
+	if n < 0 {
+		n = 0
+	}
+	return
+}
+
+// probe checks that the serial port is still usable.
+func (sp *SerialPort) probe() error {
+	if sp.hungUp() {
+		return io.EOF
+	}
+	_, err := unix.IoctlGetTermios(sp.fd, unix.TIOCGETA)
+	return err
+}
+
+// hungUp reports whether the serial port has been hung up.
+func (sp *SerialPort) hungUp() bool {
+	fds := []unix.PollFd{{Fd: int32(sp.fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, 0)
+	return err == nil && n > 0 && fds[0].Revents&unix.POLLHUP != 0
+}
+
+// readExact fills b by temporarily setting VMIN to the number of missing bytes (at most 255) and VTIME to 0.
+func (sp *SerialPort) readExact(b []byte) (n int, err error) {
+	termios, err := unix.IoctlGetTermios(sp.fd, unix.TIOCGETA)
+	if err != nil {
+		return
+	}
+	saved := *termios
+	defer func() {
+		if rerr := unix.IoctlSetTermios(sp.fd, unix.TIOCSETA, &saved); err == nil {
+			err = rerr
+		}
+	}()
+
+	for n < len(b) {
+		vmin := len(b) - n
+		if vmin > math.MaxUint8 {
+			vmin = math.MaxUint8
+		}
+		if termios.Cc[unix.VMIN] != uint8(vmin) || termios.Cc[unix.VTIME] != 0 {
+			termios.Cc[unix.VMIN] = uint8(vmin)
+			termios.Cc[unix.VTIME] = 0
+			if err = unix.IoctlSetTermios(sp.fd, unix.TIOCSETA, termios); err != nil {
+				return
+			}
+		}
+
+		// With VTIME 0, poll(2) reports the fd readable once VMIN bytes have arrived,
+		// so the read does not block where Close cannot wake it.
+		if err = sp.waitIO(unix.POLLIN, -1); err != nil {
+			return
+		}
+		var m int
+		m, err = sp.read(b[n:])
+		n += m
+		if err != nil {
+			return
+		}
+		if m == 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+
+	return
+}
+
+// write writes len(b) bytes to the serial port, honoring Config.WriteTimeout.
+func (sp *SerialPort) write(b []byte) (n int, err error) {
+	if timeout := sp.config().WriteTimeout; timeout > 0 {
+		if err = sp.waitIO(unix.POLLOUT, timeout); err != nil {
+			return
+		}
+	}
+	n, err = unix.Write(sp.fd, b)
+	if n < 0 {
+		n = 0
+	}
+	return
+}
+
+// readTimeout waits up to timeout for the serial port to become readable, then reads up to len(b) bytes.
+// It returns ErrTimeout if no data arrives in time. A negative timeout waits forever.
+func (sp *SerialPort) readTimeout(b []byte, timeout time.Duration) (n int, err error) {
+	// Close waits for the read to return before closing the handle.
+	if !sp.acquire() {
+		return 0, ErrClosed
+	}
+	defer sp.release()
+	if err = sp.waitIO(unix.POLLIN, timeout); err != nil {
+		return
+	}
+	return sp.read(b)
+}
+
+// waitIO waits up to timeout for any of events to occur on the serial port.
+// It returns ErrTimeout if none occurs in time. A negative timeout waits forever.
+// A wait to read returns ErrClosed as soon as Close is called.
+func (sp *SerialPort) waitIO(events int16, timeout time.Duration) error {
+	return sp.waitIOContext(context.Background(), events, timeout)
+}
+
+// Flush flushes both data received but not read, and data written but not transmitted.
+func (sp *SerialPort) Flush() error {
+	sp.discardBuffered()
+	return unix.IoctlSetPointerInt(sp.fd, unix.TIOCFLUSH, darwinFREAD|darwinFWRITE)
+}
+
+// flushInput flushes data received but not read.
+func (sp *SerialPort) flushInput() error {
+	sp.discardBuffered()
+	return unix.IoctlSetPointerInt(sp.fd, unix.TIOCFLUSH, darwinFREAD)
+}
+
+// flushOutput flushes data written but not transmitted.
+func (sp *SerialPort) flushOutput() error {
+	return unix.IoctlSetPointerInt(sp.fd, unix.TIOCFLUSH, darwinFWRITE)
+}
+
+// drain waits until all data written has been transmitted, like tcdrain(3).
+func (sp *SerialPort) drain() error {
+	return unix.IoctlSetInt(sp.fd, unix.TIOCDRAIN, 0)
+}
+
+// inWaiting returns the number of bytes received but not yet read.
+func (sp *SerialPort) inWaiting() (int, error) {
+	return unix.IoctlGetInt(sp.fd, darwinFIONREAD)
+}
+
+// outWaiting returns the number of bytes written but not yet transmitted.
+func (sp *SerialPort) outWaiting() (int, error) {
+	return unix.IoctlGetInt(sp.fd, unix.TIOCOUTQ)
+}
+
+// writeChunkSize returns how many bytes write should be given at once.
+// The tty layer does not report the size of the driver's output buffer.
+func (sp *SerialPort) writeChunkSize() int {
+	return defaultWriteChunkSize
+}
+
+// TxFifoSize returns the size of the UART transmit FIFO on Linux.
+// macOS does not report it, so it always returns ErrUnsupported.
+func (sp *SerialPort) TxFifoSize() (int, error) {
+	return 0, ErrUnsupported
+}
+
+// SetTxFifoSize sets how many bytes of the transmit FIFO the driver uses on Linux.
+// macOS does not support it, so it always returns ErrUnsupported.
+func (sp *SerialPort) SetTxFifoSize(n int) error {
+	return ErrUnsupported
+}
+
+// SetBufferSizes sets the sizes of the driver input and output buffers on Windows.
+// The macOS tty layer has fixed buffers, so it only checks rx and tx and does nothing.
+func (sp *SerialPort) SetBufferSizes(rx, tx int) error {
+	if rx <= 0 || tx <= 0 {
+		return fmt.Errorf("serialport: invalid buffer sizes %v and %v", rx, tx)
+	}
+	return nil
+}
+
+// DTR reports whether the DTR (Data Terminal Ready) output line is asserted.
+func (sp *SerialPort) DTR() (bool, error) {
+	bits, err := unix.IoctlGetInt(sp.fd, unix.TIOCMGET)
+	return bits&unix.TIOCM_DTR != 0, err
+}
+
+// RTS reports whether the RTS (Request To Send) output line is asserted.
+func (sp *SerialPort) RTS() (bool, error) {
+	bits, err := unix.IoctlGetInt(sp.fd, unix.TIOCMGET)
+	return bits&unix.TIOCM_RTS != 0, err
+}
+
+// SetParityBit switches between mark and space parity on other platforms.
+// macOS has no mark or space parity, so it always returns ErrUnsupported.
+func (sp *SerialPort) SetParityBit(mark bool) error {
+	return ErrUnsupported
+}
+
+// framingErrorCount returns ErrUnsupported, macOS does not count line errors.
+func (sp *SerialPort) framingErrorCount() (int, error) {
+	return 0, ErrUnsupported
+}
+
+// setBreak starts (TIOCSBRK) or ends (TIOCCBRK) the break condition.
+func (sp *SerialPort) setBreak(on bool) error {
+	if on {
+		return unix.IoctlSetInt(sp.fd, unix.TIOCSBRK, 0)
+	}
+	return unix.IoctlSetInt(sp.fd, unix.TIOCCBRK, 0)
+}
+
+// SetDTR asserts (true) or deasserts (false) the DTR (Data Terminal Ready) output line,
+// without touching the rest of the configuration.
+func (sp *SerialPort) SetDTR(on bool) error {
+	return sp.setModemLine(unix.TIOCM_DTR, on)
+}
+
+// SetRTS asserts (true) or deasserts (false) the RTS (Request To Send) output line,
+// without touching the rest of the configuration.
+func (sp *SerialPort) SetRTS(on bool) error {
+	return sp.setModemLine(unix.TIOCM_RTS, on)
+}
+
+func (sp *SerialPort) setModemLine(bit int, on bool) error {
+	if on {
+		return unix.IoctlSetPointerInt(sp.fd, unix.TIOCMBIS, bit)
+	}
+	return unix.IoctlSetPointerInt(sp.fd, unix.TIOCMBIC, bit)
+}
+
+// CommErrors returns the line errors that occurred since the last call on other platforms.
+// macOS does not count line errors, so it always returns ErrUnsupported.
+func (sp *SerialPort) CommErrors() (CommErrorFlags, error) {
+	return 0, ErrUnsupported
+}
+
+// LineErrors returns the number of line errors since Open on other platforms.
+// macOS does not count line errors, so it always returns ErrUnsupported.
+func (sp *SerialPort) LineErrors() (LineErrorStats, error) {
+	return LineErrorStats{}, ErrUnsupported
+}
+
+// actualBaudRate returns the baud rate the driver reports having set for requested.
+func (sp *SerialPort) actualBaudRate(requested int) (int, error) {
+	termios, err := unix.IoctlGetTermios(sp.fd, unix.TIOCGETA)
+	if err != nil {
+		return 0, err
+	}
+	return int(termios.Ospeed), nil
+}
+
+// Config returns the configuration of the serial port.
+func (sp *SerialPort) Config() (cfg Config, err error) {
+	termios, err := unix.IoctlGetTermios(sp.fd, unix.TIOCGETA)
+	if err != nil {
+		return
+	}
+
+	cfg = configFromTermios(termios)
+
+	// Settings with no termios equivalent are those of the last SetConfig.
+	cached := sp.config()
+	cfg.WriteTimeout = cached.WriteTimeout
+	cfg.NoResetOnOpen = cached.NoResetOnOpen
+	cfg.Mode = cached.Mode
+	cfg.Exclusive = cached.Exclusive
+	cfg.RetryEmptyReads = cached.RetryEmptyReads
+	cfg.StrictSevenBit = cached.StrictSevenBit
+	// 2 and 1.5 stop bits are the same setting with 5 data bits.
+	if cfg.StopBits == SB1_5 && cached.StopBits == SB2 {
+		cfg.StopBits = SB2
+	}
+	cfg.XonChar, cfg.XoffChar = flowChars(termios.Cc[unix.VSTART], termios.Cc[unix.VSTOP], cached)
+	// A rate set with IOSSIOSPEED is not stored in termios.
+	if !darwinStandardBauds[cached.BaudRate] {
+		cfg.BaudRate = cached.BaudRate
+	}
+	// VTIME only has decisecond resolution, keep the exact Timeout if VTIME still holds it.
+	if cached.Timeout > 0 && termios.Cc[unix.VTIME] == vtime(cached.Timeout) {
+		cfg.Timeout = cached.Timeout
+	}
+
+	return
+}
+
+// configFromTermios decodes the Config fields that are stored in termios.
+func configFromTermios(termios *unix.Termios) (cfg Config) {
+	cfg.BaudRate = int(termios.Ospeed)
+
+	// CS5 is 0 and CS8 is CS6|CS7, so the size must be compared under the CSIZE mask.
+	switch termios.Cflag & unix.CSIZE {
+	case unix.CS5:
+		cfg.DataBits = DB5
+	case unix.CS6:
+		cfg.DataBits = DB6
+	case unix.CS7:
+		cfg.DataBits = DB7
+	case unix.CS8:
+		cfg.DataBits = DB8
+	}
+
+	// With 5 data bits, CSTOPB makes UARTs send 1.5 stop bits.
+	switch {
+	case termios.Cflag&unix.CSTOPB == 0:
+		cfg.StopBits = SB1
+	case cfg.DataBits == DB5:
+		cfg.StopBits = SB1_5
+	default:
+		cfg.StopBits = SB2
+	}
+
+	switch {
+	case termios.Cflag&unix.PARENB == 0:
+		cfg.Parity = PN
+	case termios.Cflag&unix.PARODD != 0:
+		cfg.Parity = PO
+	default:
+		cfg.Parity = PE
+	}
+
+	switch {
+	case termios.Cflag&unix.CRTSCTS != 0:
+		cfg.FlowControl = FlowHardware
+	case termios.Iflag&unix.IXON != 0:
+		cfg.FlowControl = FlowSoftware
+	}
+	cfg.XonChar, cfg.XoffChar = flowChars(termios.Cc[unix.VSTART], termios.Cc[unix.VSTOP], Config{})
+
+	cfg.HangupOnClose = termios.Cflag&unix.HUPCL != 0
+	cfg.MarkParityErrors = termios.Iflag&unix.PARMRK != 0
+
+	cfg.Timeout = time.Duration(termios.Cc[unix.VTIME]) * deciseconds
+
+	return
+}
+
+func checkConfigParam(cfg Config) error {
+	if cfg.BaudRate < 0 {
+		return configErrorf("BaudRate", "Config.BaudRate cannot be negative %v", cfg.BaudRate)
+	}
+
+	if cfg.DataBits != DB5 && cfg.DataBits != DB6 && cfg.DataBits != DB7 && cfg.DataBits != DB8 {
+		return configErrorf("DataBits", "invalid Config.DataBits %v", cfg.DataBits)
+	}
+
+	if cfg.StopBits != SB1 && cfg.StopBits != SB1_5 && cfg.StopBits != SB2 {
+		return configErrorf("StopBits", "invalid Config.StopBits %v", cfg.StopBits)
+	}
+	if cfg.StopBits == SB1_5 && cfg.DataBits != DB5 {
+		return configErrorf("StopBits", "1.5 stop bits require 5 data bits on macOS, where they are 2 stop bits sent by the UART as 1.5")
+	}
+
+	if cfg.Parity != PN && cfg.Parity != PO && cfg.Parity != PE {
+		return configErrorf("Parity", "invalid Config.Parity %v", cfg.Parity)
+	}
+
+	if cfg.FlowControl != FlowNone && cfg.FlowControl != FlowHardware && cfg.FlowControl != FlowSoftware {
+		return configErrorf("FlowControl", "invalid Config.FlowControl %v", cfg.FlowControl)
+	}
+
+	if cfg.xonChar() == cfg.xoffChar() {
+		return configErrorf("XonChar", "Config.XonChar and Config.XoffChar cannot both be %#02x", cfg.xonChar())
+	}
+
+	if cfg.LowercaseInput || cfg.UppercaseOutput {
+		return configErrorf("LowercaseInput", "Config.LowercaseInput and Config.UppercaseOutput are not supported on macOS")
+	}
+
+	if cfg.Mode != ModeReadWrite && cfg.Mode != ModeReadOnly && cfg.Mode != ModeWriteOnly {
+		return configErrorf("Mode", "invalid Config.Mode %v", cfg.Mode)
+	}
+
+	return nil
+}
+
+// SetConfig Set the serial port according to Config.
+func (sp *SerialPort) SetConfig(cfg Config) error {
+	if err := checkConfigParam(cfg); err != nil {
+		return err
+	}
+
+	// Start from the current settings so that everything Config does not cover (control characters)
+	// is kept, and explicitly clear every flag that is set below.
+	termios, err := unix.IoctlGetTermios(sp.fd, unix.TIOCGETA)
+	if err != nil {
+		return err
+	}
+
+	applyConfig(termios, cfg)
+	if sp.cookedMode() {
+		makeCooked(termios)
+	}
+
+	if err := unix.IoctlSetTermios(sp.fd, unix.TIOCSETA, termios); err != nil {
+		return err
+	}
+	// IOSSIOSPEED sets any rate, once termios holds a valid one.
+	if !darwinStandardBauds[cfg.BaudRate] && cfg.BaudRate > 0 {
+		speed := uint64(cfg.BaudRate)
+		if err := ioctlPtr(sp.fd, darwinIOSSIOSPEED, unsafe.Pointer(&speed)); err != nil {
+			return err
+		}
+	}
+	sp.setConfig(cfg)
+
+	return nil
+}
+
+// applyConfig rewrites the termios fields covered by cfg, clearing each field first
+// so that nothing from a previous configuration (e.g. a wider CSIZE) is left behind.
+func applyConfig(termios *unix.Termios, cfg Config) {
+	makeRaw(termios)
+
+	termios.Cflag &^= unix.CSIZE | unix.CSTOPB | unix.PARENB | unix.PARODD | unix.HUPCL
+	termios.Cflag |= unix.CREAD | unix.CLOCAL
+
+	// Rates termios does not know are set with IOSSIOSPEED afterwards.
+	speed := uint64(cfg.BaudRate)
+	if !darwinStandardBauds[cfg.BaudRate] {
+		speed = 9600
+	}
+	termios.Ispeed = speed
+	termios.Ospeed = speed
+
+	// CSIZE  Character size mask.  Values are CS5, CS6, CS7, or CS8.
+	switch cfg.DataBits {
+	case DB5:
+		termios.Cflag |= unix.CS5
+	case DB6:
+		termios.Cflag |= unix.CS6
+	case DB7:
+		termios.Cflag |= unix.CS7
+	case DB8:
+		termios.Cflag |= unix.CS8
+	}
+
+	// CSTOPB Set two stop bits, rather than one.
+	//        With 5 data bits, UARTs send 1.5 stop bits instead.
+	if cfg.StopBits == SB1_5 || cfg.StopBits == SB2 {
+		termios.Cflag |= unix.CSTOPB
+	}
+
+	// PARENB Enable parity generation on output and parity checking for input.
+	// PARODD If set, then parity for input and output is odd; otherwise even parity is used.
+	// INPCK  Enable input parity checking.
+	switch cfg.Parity {
+	case PO:
+		termios.Cflag |= unix.PARENB | unix.PARODD
+		termios.Iflag |= unix.INPCK
+	case PE:
+		termios.Cflag |= unix.PARENB
+		termios.Iflag |= unix.INPCK
+	}
+
+	// HUPCL  Lower modem control lines after last process closes the device (hang up).
+	if cfg.HangupOnClose {
+		termios.Cflag |= unix.HUPCL
+	}
+
+	applyFlowControl(termios, cfg)
+	applyParityMarking(termios, cfg)
+
+	// VMIN   Minimum number of characters for noncanonical read (MIN).
+	// VTIME  Timeout in t for noncanonical read (TIME).
+	if cfg.Timeout > 0 {
+		termios.Cc[unix.VMIN] = 0
+		termios.Cc[unix.VTIME] = vtime(cfg.Timeout)
+	} else {
+		termios.Cc[unix.VMIN] = 1
+		termios.Cc[unix.VTIME] = 0
+	}
+}
+
+// vtime returns the VTIME for a read timeout, rounded up to the next decisecond so that
+// a timeout below 100 ms does not turn into a blocking read, and capped at 25.5 s.
+// read waits for the first byte itself if VTIME cannot hold the timeout exactly, see exactVTIME.
+func vtime(timeout time.Duration) uint8 {
+	t := (timeout + deciseconds - 1) / deciseconds
+	if t > 255 {
+		return 255
+	}
+	return uint8(t)
+}
+
+// exactVTIME reports whether VTIME holds timeout exactly.
+func exactVTIME(timeout time.Duration) bool {
+	return timeout%deciseconds == 0 && timeout <= 255*deciseconds
+}
+
+// applyFlowControl sets the flow control of cfg.
+// CRTSCTS      Enable RTS/CTS (hardware) flow control.
+// IXON/IXOFF   Enable XON/XOFF flow control on output and input.
+// VSTART/VSTOP The XON and XOFF characters.
+func applyFlowControl(termios *unix.Termios, cfg Config) {
+	termios.Cflag &^= unix.CRTSCTS
+	termios.Iflag &^= unix.IXON | unix.IXOFF | unix.IXANY
+	termios.Cc[unix.VSTART] = cfg.xonChar()
+	termios.Cc[unix.VSTOP] = cfg.xoffChar()
+
+	switch cfg.FlowControl {
+	case FlowHardware:
+		termios.Cflag |= unix.CRTSCTS
+	case FlowSoftware:
+		termios.Iflag |= unix.IXON | unix.IXOFF
+	}
+}
+
+// applyParityMarking sets the parity error marking of cfg.
+// PARMRK Prefix a character received with a parity or framing error with \377 \0, and double a valid \377.
+// IGNPAR Ignore such characters, which would take precedence over PARMRK.
+// INPCK  Enable input parity checking.
+func applyParityMarking(termios *unix.Termios, cfg Config) {
+	termios.Iflag &^= unix.PARMRK
+
+	if cfg.MarkParityErrors {
+		termios.Iflag &^= unix.IGNPAR
+		termios.Iflag |= unix.PARMRK | unix.INPCK
+	}
+}
+
+// makeRaw sets raw mode, like cfmakeraw(3): no input or output processing, no echo, no signals.
+// ECHOE and ECHOK are cleared too, so that nothing of a port left in cooked mode by another program remains.
+func makeRaw(termios *unix.Termios) {
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL |
+		unix.IXON | unix.IXOFF | unix.IXANY | unix.INPCK
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHOE | unix.ECHOK | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+}
+
+// makeCooked sets canonical mode with echo, CR to NL translation on input and NL to CRLF on output.
+// Signal characters stay disabled, a serial device is not a controlling terminal.
+func makeCooked(termios *unix.Termios) {
+	termios.Iflag |= unix.ICRNL
+	termios.Oflag |= unix.OPOST | unix.ONLCR
+	termios.Lflag |= unix.ICANON | unix.ECHO | unix.ECHOE | unix.ECHOK | unix.IEXTEN
+}
+
+// SetRawMode switches the serial port to raw mode, in which data passes through unchanged.
+// This is the mode Open sets.
+func (sp *SerialPort) SetRawMode() error {
+	return sp.setCookedMode(false)
+}
+
+// SetCookedMode switches the serial port to cooked (canonical) mode, as used by interactive terminals:
+// Read returns whole lines, received data is echoed, CR is read as NL and NL is written as CRLF.
+// The mode is kept across SetConfig until SetRawMode is called.
+func (sp *SerialPort) SetCookedMode() error {
+	return sp.setCookedMode(true)
+}
+
+func (sp *SerialPort) setCookedMode(cooked bool) error {
+	termios, err := unix.IoctlGetTermios(sp.fd, unix.TIOCGETA)
+	if err != nil {
+		return err
+	}
+	if cooked {
+		makeCooked(termios)
+	} else {
+		cfg := sp.config()
+		makeRaw(termios)
+		if cfg.Parity != PN {
+			termios.Iflag |= unix.INPCK
+		}
+		applyFlowControl(termios, cfg)
+		applyParityMarking(termios, cfg)
+	}
+	if err := unix.IoctlSetTermios(sp.fd, unix.TIOCSETA, termios); err != nil {
+		return err
+	}
+
+	sp.cmu.Lock()
+	sp.cooked = cooked
+	sp.cmu.Unlock()
+	return nil
+}
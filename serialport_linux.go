@@ -1,59 +1,713 @@
 package serialport
 
 import (
+	"context"
 	"fmt"
+	"io"
+	"math"
+	"sync"
 	"time"
+	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
 
 const deciseconds = time.Millisecond * 100 // 1/10 second
 
+// Reference linux/serial.h:
+// struct serial_struct {
+//   int type;
+//   int line;
+//   unsigned int port;
+//   int irq;
+//   int flags;
+//   int xmit_fifo_size;
+//   int custom_divisor;
+//   int baud_base;
+//   unsigned short close_delay;
+//   char io_type;
+//   char reserved_char[1];
+//   int hub6;
+//   unsigned short closing_wait;
+//   unsigned short closing_wait2;
+//   unsigned char *iomem_base;
+//   unsigned short iomem_reg_shift;
+//   unsigned int port_high;
+//   unsigned long iomap_base;
+// };
+type serialStruct struct {
+	Type          int32
+	Line          int32
+	Port          uint32
+	Irq           int32
+	Flags         int32
+	XmitFifoSize  int32
+	CustomDivisor int32
+	BaudBase      int32
+	CloseDelay    uint16
+	IoType        int8
+	ReservedChar  [1]int8
+	Hub6          int32
+	ClosingWait   uint16
+	ClosingWait2  uint16
+	IomemBase     uintptr
+	IomemRegShift uint16
+	PortHigh      uint32
+	IomapBase     uintptr
+}
+
+// Reference linux/serial.h:
+// struct serial_icounter_struct {
+//   int cts, dsr, rng, dcd;
+//   int rx, tx;
+//   int frame, overrun, parity, brk;
+//   int buf_overrun;
+//   int reserved[9];
+// };
+type serialIcounter struct {
+	CTS        int32
+	DSR        int32
+	RNG        int32
+	DCD        int32
+	Rx         int32
+	Tx         int32
+	Frame      int32
+	Overrun    int32
+	Parity     int32
+	Brk        int32
+	BufOverrun int32
+	Reserved   [9]int32
+}
+
+func ioctlPtr(fd int, req uint, arg unsafe.Pointer) error {
+	_, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(req), uintptr(arg))
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
+
 // A SerialPort is a serial port. This must be instantiated by calling Open() and not manually.
 type SerialPort struct {
-	fd int
+	fd   int
+	name string
+	excl bool // fd holds the locks of Config.Exclusive, see lockExclusive
+
+	rwake *wakePipe // interrupts waits to read, see waitIOContext
+	wwake *wakePipe // interrupts waits to write with a context
+
+	cmu       sync.Mutex        // guards cfg, linger, cooked, poll, errFilter and the deadlines
+	cfg       Config            // last applied configuration
+	linger    time.Duration     // see SetLinger
+	cooked    bool              // see SetCookedMode
+	poll      bool              // see SetPollMode
+	errFilter func(error) error // see SetErrorFilter
+	rdeadline time.Time         // see SetReadDeadline
+	wdeadline time.Time         // see SetWriteDeadline
+
+	rmu sync.Mutex // serializes reads
+	wmu sync.Mutex // serializes writes
+
+	bmu  sync.Mutex // guards rbuf
+	rbuf []byte     // data read ahead by the line reader, returned before new data
+
+	umu      sync.Mutex // guards userData
+	userData interface{}
+
+	lmu    sync.Mutex     // guards closed, done and paused
+	closed bool           // set by Close
+	paused chan struct{}  // closed when Reopen resumes, see pauseBackground
+	done   chan struct{}  // closed by Close to stop background goroutines
+	bg     sync.WaitGroup // background goroutines using fd, waited for by Close
+
+	emu        sync.Mutex     // guards icount and icountOpen
+	icount     serialIcounter // error counters when last reported by CommErrors
+	icountOpen serialIcounter // error counters at Open, the base of LineErrors
 }
 
 // Open opens a serial port.
+// name may be a symlink such as /dev/serial/by-id/...; the SerialPort keeps that name
+// rather than the device it resolves to, so that it keeps addressing the same adapter.
 func Open(name string, cfg Config) (sp *SerialPort, err error) {
-	fd, err := unix.Open(name, unix.O_RDWR|unix.O_NOCTTY, 0666)
+	flags := openFlags(cfg.Mode) | unix.O_NOCTTY
+	if cfg.NoResetOnOpen {
+		flags |= unix.O_NONBLOCK
+	}
+	fd, err := unix.Open(name, flags, 0666)
 	if err != nil {
-		return
+		return nil, newOpenError(name, err)
+	}
+	sp = &SerialPort{fd: fd, name: name}
+	if err = sp.openWake(); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	if cfg.Exclusive {
+		if err = sp.lockExclusive(); err != nil {
+			sp.Close()
+			return nil, err
+		}
+	}
+	if cfg.NoResetOnOpen {
+		if err = sp.holdModemLines(); err != nil {
+			sp.Close()
+			return nil, err
+		}
 	}
-	sp = &SerialPort{fd: fd}
 
 	if err = sp.SetConfig(cfg); err != nil {
 		sp.Close()
+		return nil, err
+	}
+	// Errors counted before Open are not reported by CommErrors. Not every driver keeps counters.
+	ioctlPtr(sp.fd, unix.TIOCGICOUNT, unsafe.Pointer(&sp.icount))
+	sp.icountOpen = sp.icount
+
+	return
+}
+
+// lockExclusive takes an advisory flock on the fd, which fails with ErrPortBusy while another
+// Exclusive open holds it, even one by root, and sets TIOCEXCL, so that any later open(2)
+// of the tty by a process without CAP_SYS_ADMIN fails with EBUSY. Drivers without TIOCEXCL
+// are left with the flock alone.
+func (sp *SerialPort) lockExclusive() error {
+	if err := unix.Flock(sp.fd, unix.LOCK_EX|unix.LOCK_NB); err != nil {
+		return newOpenError(sp.name, err)
+	}
+	unix.IoctlSetInt(sp.fd, unix.TIOCEXCL, 0)
+	sp.excl = true
+	return nil
+}
+
+// unlockExclusive clears TIOCEXCL before the fd is closed, which the kernel would otherwise keep
+// for as long as another process has the tty open. The flock goes away with the fd.
+func (sp *SerialPort) unlockExclusive() {
+	if sp.excl {
+		unix.IoctlSetInt(sp.fd, unix.TIOCNXCL, 0)
+		sp.excl = false
+	}
+}
+
+// openFlags returns the access mode flags of open(2) for mode.
+func openFlags(mode AccessMode) int {
+	switch mode {
+	case ModeReadOnly:
+		return unix.O_RDONLY
+	case ModeWriteOnly:
+		return unix.O_WRONLY
+	}
+	return unix.O_RDWR
+}
+
+// openErrorKind returns the sentinel error that err of open(2) matches, if any.
+func openErrorKind(err error) error {
+	switch err {
+	case unix.ENOENT, unix.ENODEV, unix.ENXIO:
+		return ErrNotFound
+	case unix.EBUSY, unix.EWOULDBLOCK:
+		return ErrPortBusy
+	}
+	return nil
+}
+
+// OpenNoConfig opens a serial port without changing its settings, e.g. to monitor a link
+// configured by another tool or a boot script. Config reports the settings found in place,
+// and they are kept until SetConfig is called; in particular the port is not switched to raw mode.
+func OpenNoConfig(name string) (sp *SerialPort, err error) {
+	fd, err := unix.Open(name, unix.O_RDWR|unix.O_NOCTTY, 0666)
+	if err != nil {
+		return nil, newOpenError(name, err)
+	}
+	sp = &SerialPort{fd: fd, name: name}
+	if err = sp.openWake(); err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	cfg, err := sp.Config()
+	if err != nil {
+		sp.Close()
+		return nil, err
 	}
+	sp.setConfig(cfg)
+	ioctlPtr(sp.fd, unix.TIOCGICOUNT, unsafe.Pointer(&sp.icount))
+	sp.icountOpen = sp.icount
 
 	return
 }
 
+// holdModemLines deasserts DTR and RTS right after a non-blocking open, before the kernel
+// or SetConfig gets a chance to pulse them, then switches the fd back to blocking mode.
+func (sp *SerialPort) holdModemLines() error {
+	// Ports without modem lines (e.g. pseudo-terminals) do not support TIOCMBIC.
+	unix.IoctlSetPointerInt(sp.fd, unix.TIOCMBIC, unix.TIOCM_DTR|unix.TIOCM_RTS)
+
+	flags, err := unix.FcntlInt(uintptr(sp.fd), unix.F_GETFL, 0)
+	if err != nil {
+		return err
+	}
+	_, err = unix.FcntlInt(uintptr(sp.fd), unix.F_SETFL, flags&^unix.O_NONBLOCK)
+	return err
+}
+
 // Close close the serial port.
+// Pending output is handled according to SetLinger. Background goroutines using the serial port,
+// such as WatchModemLines and a Manager supervising it, are stopped before the fd is closed.
+// A Read waiting for data returns ErrClosed at once: its poll(2) is woken through a pipe.
+// Closing an already closed serial port returns ErrClosed.
 func (sp *SerialPort) Close() error {
-	return unix.Close(sp.fd)
+	if !sp.stopBackground() {
+		return ErrClosed
+	}
+	sp.lingerDrain()
+	sp.unlockExclusive()
+	err := unix.Close(sp.fd)
+	sp.closeWake()
+	return err
+}
+
+// Fd returns the file descriptor of the serial port, e.g. to issue ioctls such as TIOCMBIS
+// that this package does not wrap. It is an escape hatch and unsafe: the descriptor is shared
+// with the SerialPort, so changing its termios or file status flags can break later calls,
+// and it is only valid until Close.
+func (sp *SerialPort) Fd() uintptr {
+	return uintptr(sp.fd)
+}
+
+// reopen replaces the device open on the fd with name opened again with cfg. The fd number never changes,
+// as background goroutines such as WatchModemLines and calls such as ModemStatus use it without holding rmu
+// and wmu: the device is closed by parking the fd, and the fresh one is moved onto it with dup2(2).
+func (sp *SerialPort) reopen(cfg Config) error {
+	sp.unlockExclusive()
+	if err := sp.park(); err != nil {
+		return err
+	}
+	fresh, err := Open(sp.name, cfg)
+	if err != nil {
+		return err
+	}
+	// The wake pipes of the serial port are kept, as a Read may be about to wait on them.
+	fresh.closeWake()
+	err = unix.Dup2(fresh.fd, sp.fd)
+	unix.Close(fresh.fd)
+	if err != nil {
+		return err
+	}
+	sp.excl = fresh.excl
+	sp.emu.Lock()
+	sp.icount, sp.icountOpen = fresh.icount, fresh.icountOpen
+	sp.emu.Unlock()
+
+	if sp.cookedMode() {
+		err = sp.SetConfig(cfg)
+	}
+	if err == nil && sp.pollMode() {
+		err = sp.SetPollMode(true)
+	}
+	return err
+}
+
+// park closes the device open on the fd without releasing the fd number, which another file could reuse,
+// by making the fd refer to a pipe without writer instead: it reads as hung up, and every write and ioctl fails.
+func (sp *SerialPort) park() error {
+	var p [2]int
+	if err := unix.Pipe2(p[:], unix.O_CLOEXEC); err != nil {
+		return err
+	}
+	unix.Close(p[1])
+	err := unix.Dup2(p[0], sp.fd)
+	unix.Close(p[0])
+	return err
+}
+
+// read reads up to len(b) bytes from the serial port.
+// It returns io.EOF once the other end has gone away: a hung up tty (e.g. a detached USB adapter)
+// reads 0 bytes and a pty master whose slave was closed fails with EIO. Both report POLLHUP,
+// which distinguishes them from a read that simply timed out.
+func (sp *SerialPort) read(b []byte) (n int, err error) {
+	if sp.pollMode() {
+		n, err = sp.readPoll(b, sp.readTimeoutBudget())
+		if err == ErrTimeout {
+			err = nil
+		}
+		return
+	}
+
+	if timeout := sp.config().Timeout; timeout > 0 && !exactVTIME(timeout) {
+		if err = sp.waitIO(unix.POLLIN, timeout); err != nil {
+			if err == ErrTimeout {
+				err = nil
+			}
+			return
+		}
+	}
+	n, err = unix.Read(sp.fd, b)
+	if (n == 0 && err == nil) || err == unix.EIO {
+		if sp.hungUp() {
+			return 0, io.EOF
+		}
+	}
+	if n < 0 {
+		n = 0
+	}
+	return
+}
+
+// probe checks that the serial port is still usable.
+func (sp *SerialPort) probe() error {
+	if sp.hungUp() {
+		return io.EOF
+	}
+	_, err := unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
+	return err
+}
+
+// hungUp reports whether the serial port has been hung up.
+func (sp *SerialPort) hungUp() bool {
+	fds := []unix.PollFd{{Fd: int32(sp.fd), Events: unix.POLLIN}}
+	n, err := unix.Poll(fds, 0)
+	return err == nil && n > 0 && fds[0].Revents&unix.POLLHUP != 0
+}
+
+// readExact fills b by temporarily setting VMIN to the number of missing bytes (at most 255) and VTIME to 0,
+// or in poll mode by polling without a timeout.
+func (sp *SerialPort) readExact(b []byte) (n int, err error) {
+	if sp.pollMode() {
+		for n < len(b) && err == nil {
+			var m int
+			m, err = sp.readPoll(b[n:], -1)
+			n += m
+		}
+		return
+	}
+
+	termios, err := unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
+	if err != nil {
+		return
+	}
+	saved := *termios
+	defer func() {
+		if rerr := unix.IoctlSetTermios(sp.fd, unix.TCSETS2, &saved); err == nil {
+			err = rerr
+		}
+	}()
+
+	for n < len(b) {
+		vmin := len(b) - n
+		if vmin > math.MaxUint8 {
+			vmin = math.MaxUint8
+		}
+		if termios.Cc[unix.VMIN] != uint8(vmin) || termios.Cc[unix.VTIME] != 0 {
+			termios.Cc[unix.VMIN] = uint8(vmin)
+			termios.Cc[unix.VTIME] = 0
+			if err = unix.IoctlSetTermios(sp.fd, unix.TCSETS2, termios); err != nil {
+				return
+			}
+		}
+
+		// With VTIME 0, poll(2) reports the fd readable once VMIN bytes have arrived,
+		// so the read does not block where Close cannot wake it.
+		if err = sp.waitIO(unix.POLLIN, -1); err != nil {
+			return
+		}
+		var m int
+		m, err = sp.read(b[n:])
+		n += m
+		if err != nil {
+			return
+		}
+		if m == 0 {
+			return n, io.ErrUnexpectedEOF
+		}
+	}
+
+	return
 }
 
-// Read reads up to len(b) bytes from the serial port.
-// It returns the number of bytes (0 <= n <= len(b)) read from the serial port and any errors encountered.
-// Note:
-//     Timeout < 100 ms: Read blocks until at least one byte is readable;
-//     Timeout > 100 ms: Read blocks until at least one byte is read or timeout.
-func (sp *SerialPort) Read(b []byte) (n int, err error) {
-	return unix.Read(sp.fd, b)
+// write writes len(b) bytes to the serial port, honoring Config.WriteTimeout.
+func (sp *SerialPort) write(b []byte) (n int, err error) {
+	if sp.pollMode() {
+		return sp.writePoll(b, sp.config().WriteTimeout)
+	}
+	if timeout := sp.config().WriteTimeout; timeout > 0 {
+		if err = sp.waitIO(unix.POLLOUT, timeout); err != nil {
+			return
+		}
+	}
+	n, err = unix.Write(sp.fd, b)
+	if n < 0 {
+		n = 0
+	}
+	return
+}
+
+// readTimeout waits up to timeout for the serial port to become readable, then reads up to len(b) bytes.
+// It returns ErrTimeout if no data arrives in time. A negative timeout waits forever.
+func (sp *SerialPort) readTimeout(b []byte, timeout time.Duration) (n int, err error) {
+	// Close waits for the read to return before closing the handle.
+	if !sp.acquire() {
+		return 0, ErrClosed
+	}
+	defer sp.release()
+	if err = sp.waitIO(unix.POLLIN, timeout); err != nil {
+		return
+	}
+	return sp.read(b)
 }
 
-// Write writes len(b) bytes to the serial port.
-// It returns the number of bytes (0 <= n <= len(b)) written to the serial port and any errors encountered.
-func (sp *SerialPort) Write(b []byte) (n int, err error) {
-	return unix.Write(sp.fd, b)
+// waitIO waits up to timeout for any of events to occur on the serial port.
+// It returns ErrTimeout if none occurs in time. A negative timeout waits forever.
+// A wait to read returns ErrClosed as soon as Close is called.
+func (sp *SerialPort) waitIO(events int16, timeout time.Duration) error {
+	return sp.waitIOContext(context.Background(), events, timeout)
 }
 
 // Flush flushes both data received but not read, and data written but not transmitted.
 func (sp *SerialPort) Flush() error {
+	sp.discardBuffered()
 	return unix.IoctlSetInt(sp.fd, unix.TCFLSH, unix.TCIOFLUSH)
 }
 
+// flushInput flushes data received but not read.
+func (sp *SerialPort) flushInput() error {
+	sp.discardBuffered()
+	return unix.IoctlSetInt(sp.fd, unix.TCFLSH, unix.TCIFLUSH)
+}
+
+// flushOutput flushes data written but not transmitted.
+func (sp *SerialPort) flushOutput() error {
+	return unix.IoctlSetInt(sp.fd, unix.TCFLSH, unix.TCOFLUSH)
+}
+
+// drain waits until all data written has been transmitted, like tcdrain(3).
+func (sp *SerialPort) drain() error {
+	return unix.IoctlSetInt(sp.fd, unix.TCSBRK, 1)
+}
+
+// inWaiting returns the number of bytes received but not yet read.
+func (sp *SerialPort) inWaiting() (int, error) {
+	return unix.IoctlGetInt(sp.fd, unix.TIOCINQ)
+}
+
+// outWaiting returns the number of bytes written but not yet transmitted.
+func (sp *SerialPort) outWaiting() (int, error) {
+	return unix.IoctlGetInt(sp.fd, unix.TIOCOUTQ)
+}
+
+// writeChunkSize returns how many bytes write should be given at once.
+// The tty layer does not report the size of the driver's output buffer.
+func (sp *SerialPort) writeChunkSize() int {
+	return defaultWriteChunkSize
+}
+
+// SetBufferSizes sets the sizes of the driver input and output buffers on Windows.
+// The Linux tty layer has fixed buffers, so it only checks rx and tx and does nothing.
+func (sp *SerialPort) SetBufferSizes(rx, tx int) error {
+	if rx <= 0 || tx <= 0 {
+		return fmt.Errorf("serialport: invalid buffer sizes %v and %v", rx, tx)
+	}
+	return nil
+}
+
+// DTR reports whether the DTR (Data Terminal Ready) output line is asserted.
+func (sp *SerialPort) DTR() (bool, error) {
+	bits, err := unix.IoctlGetInt(sp.fd, unix.TIOCMGET)
+	return bits&unix.TIOCM_DTR != 0, err
+}
+
+// RTS reports whether the RTS (Request To Send) output line is asserted.
+func (sp *SerialPort) RTS() (bool, error) {
+	bits, err := unix.IoctlGetInt(sp.fd, unix.TIOCMGET)
+	return bits&unix.TIOCM_RTS != 0, err
+}
+
+// SetParityBit switches between mark (true) and space (false) parity without a full SetConfig,
+// e.g. to send the address byte of a 9-bit multidrop protocol with the 9th bit set and the data with it clear.
+// Config.Parity must be PM or PS. Pending output is transmitted first, so every byte goes out with
+// the parity it was written with. Each switch costs a system call and a drain of the output,
+// so group consecutive bytes that share the 9th bit into one Write.
+func (sp *SerialPort) SetParityBit(mark bool) error {
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+
+	termios, err := unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
+	if err != nil {
+		return err
+	}
+	if termios.Cflag&(unix.PARENB|unix.CMSPAR) != unix.PARENB|unix.CMSPAR {
+		return fmt.Errorf("serialport: SetParityBit requires mark or space parity")
+	}
+
+	parity := PS
+	termios.Cflag &^= unix.PARODD
+	if mark {
+		parity = PM
+		termios.Cflag |= unix.PARODD
+	}
+	// TCSETSW2 waits until pending output has been transmitted.
+	if err := unix.IoctlSetTermios(sp.fd, unix.TCSETSW2, termios); err != nil {
+		return err
+	}
+
+	sp.cmu.Lock()
+	sp.cfg.Parity = parity
+	sp.cmu.Unlock()
+	return nil
+}
+
+// framingErrorCount returns the driver's framing error counter (TIOCGICOUNT).
+func (sp *SerialPort) framingErrorCount() (int, error) {
+	var ic serialIcounter
+	if err := ioctlPtr(sp.fd, unix.TIOCGICOUNT, unsafe.Pointer(&ic)); err != nil {
+		return 0, err
+	}
+	return int(ic.Frame), nil
+}
+
+// setBreak starts (TIOCSBRK) or ends (TIOCCBRK) the break condition.
+func (sp *SerialPort) setBreak(on bool) error {
+	if on {
+		return unix.IoctlSetInt(sp.fd, unix.TIOCSBRK, 0)
+	}
+	return unix.IoctlSetInt(sp.fd, unix.TIOCCBRK, 0)
+}
+
+// SetDTR asserts (true) or deasserts (false) the DTR (Data Terminal Ready) output line,
+// without touching the rest of the configuration.
+func (sp *SerialPort) SetDTR(on bool) error {
+	return sp.setModemLine(unix.TIOCM_DTR, on)
+}
+
+// SetRTS asserts (true) or deasserts (false) the RTS (Request To Send) output line,
+// without touching the rest of the configuration.
+func (sp *SerialPort) SetRTS(on bool) error {
+	return sp.setModemLine(unix.TIOCM_RTS, on)
+}
+
+func (sp *SerialPort) setModemLine(bit int, on bool) error {
+	if on {
+		return unix.IoctlSetPointerInt(sp.fd, unix.TIOCMBIS, bit)
+	}
+	return unix.IoctlSetPointerInt(sp.fd, unix.TIOCMBIC, bit)
+}
+
+// CommErrors returns the line errors that occurred since the last call, or since Open.
+// They are derived from the driver's error counters (TIOCGICOUNT), which not every driver keeps.
+func (sp *SerialPort) CommErrors() (CommErrorFlags, error) {
+	sp.emu.Lock()
+	defer sp.emu.Unlock()
+
+	var ic serialIcounter
+	if err := ioctlPtr(sp.fd, unix.TIOCGICOUNT, unsafe.Pointer(&ic)); err != nil {
+		return 0, err
+	}
+	flags := commErrorsSince(sp.icount, ic)
+	sp.icount = ic
+	return flags, nil
+}
+
+// LineErrors returns the number of parity, framing, overrun and break errors since Open.
+// They are derived from the driver's error counters (TIOCGICOUNT), which not every driver keeps.
+func (sp *SerialPort) LineErrors() (LineErrorStats, error) {
+	var ic serialIcounter
+	if err := ioctlPtr(sp.fd, unix.TIOCGICOUNT, unsafe.Pointer(&ic)); err != nil {
+		return LineErrorStats{}, err
+	}
+
+	sp.emu.Lock()
+	defer sp.emu.Unlock()
+	return lineErrorsSince(sp.icountOpen, ic), nil
+}
+
+// lineErrorsSince returns the errors counted between the counters prev and cur.
+func lineErrorsSince(prev, cur serialIcounter) LineErrorStats {
+	return LineErrorStats{
+		Parity:  int(cur.Parity - prev.Parity),
+		Framing: int(cur.Frame - prev.Frame),
+		Overrun: int(cur.Overrun-prev.Overrun) + int(cur.BufOverrun-prev.BufOverrun),
+		Break:   int(cur.Brk - prev.Brk),
+	}
+}
+
+// commErrorsSince returns the errors counted between the counters prev and cur.
+func commErrorsSince(prev, cur serialIcounter) (flags CommErrorFlags) {
+	if cur.BufOverrun != prev.BufOverrun {
+		flags |= CommRxOver
+	}
+	if cur.Overrun != prev.Overrun {
+		flags |= CommOverrun
+	}
+	if cur.Parity != prev.Parity {
+		flags |= CommParity
+	}
+	if cur.Frame != prev.Frame {
+		flags |= CommFrame
+	}
+	if cur.Brk != prev.Brk {
+		flags |= CommBreak
+	}
+	return
+}
+
+// actualBaudRate returns the baud rate the UART generates for requested.
+// Native UARTs divide baud_base by an integer divisor; drivers that do not report
+// baud_base (e.g. most USB adapters) are trusted to report the rate they set in termios.
+func (sp *SerialPort) actualBaudRate(requested int) (int, error) {
+	var ss serialStruct
+	if err := ioctlPtr(sp.fd, unix.TIOCGSERIAL, unsafe.Pointer(&ss)); err == nil && ss.BaudBase > 0 && requested > 0 {
+		divisor := (int(ss.BaudBase) + requested/2) / requested
+		if divisor < 1 {
+			divisor = 1
+		}
+		return int(ss.BaudBase) / divisor, nil
+	}
+
+	termios, err := unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
+	if err != nil {
+		return 0, err
+	}
+	return int(termios.Ospeed), nil
+}
+
+// TxFifoSize returns the size in bytes of the UART transmit FIFO, xmit_fifo_size as reported by TIOCGSERIAL:
+// the number of bytes the driver hands to the hardware at once, which are sent even after a flush.
+// It returns ErrUnsupported if the driver does not report it, as is the case for most USB adapters and pseudo-terminals.
+func (sp *SerialPort) TxFifoSize() (int, error) {
+	var ss serialStruct
+	if err := ioctlPtr(sp.fd, unix.TIOCGSERIAL, unsafe.Pointer(&ss)); err != nil {
+		if err == unix.ENOTTY || err == unix.EINVAL {
+			return 0, ErrUnsupported
+		}
+		return 0, err
+	}
+	if ss.XmitFifoSize <= 0 {
+		return 0, ErrUnsupported
+	}
+	return int(ss.XmitFifoSize), nil
+}
+
+// SetTxFifoSize sets how many bytes of the transmit FIFO the driver uses with TIOCSSERIAL,
+// e.g. 1 so that a flush discards everything not yet on the wire. Changing it requires CAP_SYS_ADMIN.
+// It returns ErrUnsupported if the driver does not report a FIFO size.
+func (sp *SerialPort) SetTxFifoSize(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("serialport: invalid transmit FIFO size %v", n)
+	}
+	var ss serialStruct
+	if err := ioctlPtr(sp.fd, unix.TIOCGSERIAL, unsafe.Pointer(&ss)); err != nil {
+		if err == unix.ENOTTY || err == unix.EINVAL {
+			return ErrUnsupported
+		}
+		return err
+	}
+	if ss.XmitFifoSize <= 0 {
+		return ErrUnsupported
+	}
+	ss.XmitFifoSize = int32(n)
+	return ioctlPtr(sp.fd, unix.TIOCSSERIAL, unsafe.Pointer(&ss))
+}
+
 // Config returns the configuration of the serial port.
 func (sp *SerialPort) Config() (cfg Config, err error) {
 	termios, err := unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
@@ -61,33 +715,81 @@ func (sp *SerialPort) Config() (cfg Config, err error) {
 		return
 	}
 
+	cfg = configFromTermios(termios)
+
+	// Settings with no termios equivalent are those of the last SetConfig.
+	cached := sp.config()
+	cfg.WriteTimeout = cached.WriteTimeout
+	cfg.NoResetOnOpen = cached.NoResetOnOpen
+	cfg.Mode = cached.Mode
+	cfg.Exclusive = cached.Exclusive
+	cfg.RetryEmptyReads = cached.RetryEmptyReads
+	cfg.StrictSevenBit = cached.StrictSevenBit
+	// 2 and 1.5 stop bits are the same setting with 5 data bits.
+	if cfg.StopBits == SB1_5 && cached.StopBits == SB2 {
+		cfg.StopBits = SB2
+	}
+	cfg.XonChar, cfg.XoffChar = flowChars(termios.Cc[unix.VSTART], termios.Cc[unix.VSTOP], cached)
+	// VTIME only has decisecond resolution, keep the exact Timeout if VTIME still holds it.
+	if cached.Timeout > 0 && termios.Cc[unix.VTIME] == vtime(cached.Timeout) {
+		cfg.Timeout = cached.Timeout
+	}
+
+	return
+}
+
+// configFromTermios decodes the Config fields that are stored in termios.
+func configFromTermios(termios *unix.Termios) (cfg Config) {
 	cfg.BaudRate = int(termios.Ospeed)
 
-	switch {
-	case termios.Cflag&unix.CS5 > 0:
+	// CS5 is 0 and CS8 is CS6|CS7, so the size must be compared under the CSIZE mask.
+	switch termios.Cflag & unix.CSIZE {
+	case unix.CS5:
 		cfg.DataBits = DB5
-	case termios.Cflag&unix.CS6 > 0:
+	case unix.CS6:
 		cfg.DataBits = DB6
-	case termios.Cflag&unix.CS7 > 0:
+	case unix.CS7:
 		cfg.DataBits = DB7
-	case termios.Cflag&unix.CS8 > 0:
+	case unix.CS8:
 		cfg.DataBits = DB8
 	}
 
-	if termios.Cflag&unix.CSTOPB == 0 {
+	// With 5 data bits, CSTOPB makes UARTs send 1.5 stop bits.
+	switch {
+	case termios.Cflag&unix.CSTOPB == 0:
 		cfg.StopBits = SB1
-	} else {
+	case cfg.DataBits == DB5:
+		cfg.StopBits = SB1_5
+	default:
 		cfg.StopBits = SB2
 	}
 
-	if termios.Cflag&unix.PARENB == 0 {
+	switch {
+	case termios.Cflag&unix.PARENB == 0:
 		cfg.Parity = PN
-	} else if termios.Cflag&unix.PARODD > 0 {
+	case termios.Cflag&unix.CMSPAR != 0 && termios.Cflag&unix.PARODD != 0:
+		cfg.Parity = PM
+	case termios.Cflag&unix.CMSPAR != 0:
+		cfg.Parity = PS
+	case termios.Cflag&unix.PARODD != 0:
 		cfg.Parity = PO
-	} else {
+	default:
 		cfg.Parity = PE
 	}
 
+	switch {
+	case termios.Cflag&unix.CRTSCTS != 0:
+		cfg.FlowControl = FlowHardware
+	case termios.Iflag&unix.IXON != 0:
+		cfg.FlowControl = FlowSoftware
+	}
+	cfg.XonChar, cfg.XoffChar = flowChars(termios.Cc[unix.VSTART], termios.Cc[unix.VSTOP], Config{})
+
+	cfg.HangupOnClose = termios.Cflag&unix.HUPCL != 0
+	cfg.MarkParityErrors = termios.Iflag&unix.PARMRK != 0
+	cfg.LowercaseInput = termios.Iflag&unix.IUCLC != 0
+	cfg.UppercaseOutput = termios.Oflag&(unix.OPOST|unix.OLCUC) == unix.OPOST|unix.OLCUC
+
 	cfg.Timeout = time.Duration(termios.Cc[unix.VTIME]) * deciseconds
 
 	return
@@ -95,19 +797,34 @@ func (sp *SerialPort) Config() (cfg Config, err error) {
 
 func checkConfigParam(cfg Config) error {
 	if cfg.BaudRate < 0 {
-		return fmt.Errorf("serialport: Config.BaudRate cannot be negative %v", cfg.BaudRate)
+		return configErrorf("BaudRate", "Config.BaudRate cannot be negative %v", cfg.BaudRate)
 	}
 
 	if cfg.DataBits != DB5 && cfg.DataBits != DB6 && cfg.DataBits != DB7 && cfg.DataBits != DB8 {
-		return fmt.Errorf("serialport: invalid Config.DataBits %v", cfg.DataBits)
+		return configErrorf("DataBits", "invalid Config.DataBits %v", cfg.DataBits)
 	}
 
-	if cfg.StopBits != SB1 && cfg.StopBits != SB2 {
-		return fmt.Errorf("serialport: invalid Config.StopBits %v", cfg.StopBits)
+	if cfg.StopBits != SB1 && cfg.StopBits != SB1_5 && cfg.StopBits != SB2 {
+		return configErrorf("StopBits", "invalid Config.StopBits %v", cfg.StopBits)
+	}
+	if cfg.StopBits == SB1_5 && cfg.DataBits != DB5 {
+		return configErrorf("StopBits", "1.5 stop bits require 5 data bits on Linux, where they are 2 stop bits sent by the UART as 1.5")
 	}
 
-	if cfg.Parity != PN && cfg.Parity != PO && cfg.Parity != PE {
-		return fmt.Errorf("serialport: invalid Config.Parity %v", cfg.Parity)
+	if cfg.Parity != PN && cfg.Parity != PO && cfg.Parity != PE && cfg.Parity != PM && cfg.Parity != PS {
+		return configErrorf("Parity", "invalid Config.Parity %v", cfg.Parity)
+	}
+
+	if cfg.FlowControl != FlowNone && cfg.FlowControl != FlowHardware && cfg.FlowControl != FlowSoftware {
+		return configErrorf("FlowControl", "invalid Config.FlowControl %v", cfg.FlowControl)
+	}
+
+	if cfg.xonChar() == cfg.xoffChar() {
+		return configErrorf("XonChar", "Config.XonChar and Config.XoffChar cannot both be %#02x", cfg.xonChar())
+	}
+
+	if cfg.Mode != ModeReadWrite && cfg.Mode != ModeReadOnly && cfg.Mode != ModeWriteOnly {
+		return configErrorf("Mode", "invalid Config.Mode %v", cfg.Mode)
 	}
 
 	return nil
@@ -119,54 +836,221 @@ func (sp *SerialPort) SetConfig(cfg Config) error {
 		return err
 	}
 
-	termios2 := unix.Termios{}
-	termios2.Cflag |= unix.CREAD | unix.CLOCAL | unix.BOTHER
+	// Start from the current settings so that everything Config does not cover (line discipline,
+	// control characters) is kept, and explicitly clear every flag that is set below.
+	termios2, err := unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
+	if err != nil {
+		return err
+	}
+
+	applyConfig(termios2, cfg)
+	if sp.cookedMode() {
+		makeCooked(termios2)
+	}
+
+	if err := unix.IoctlSetTermios(sp.fd, unix.TCSETS2, termios2); err != nil {
+		return err
+	}
+	sp.setConfig(cfg)
+
+	return nil
+}
+
+// applyConfig rewrites the termios fields covered by cfg, clearing each field first
+// so that nothing from a previous configuration (e.g. a wider CSIZE) is left behind.
+func applyConfig(termios *unix.Termios, cfg Config) {
+	makeRaw(termios)
 
-	termios2.Ispeed = uint32(cfg.BaudRate)
-	termios2.Ospeed = uint32(cfg.BaudRate)
+	termios.Cflag &^= unix.CBAUD | unix.CIBAUD | unix.CSIZE | unix.CSTOPB | unix.PARENB | unix.PARODD | unix.CMSPAR |
+		unix.HUPCL
+	termios.Cflag |= unix.CREAD | unix.CLOCAL | unix.BOTHER
+
+	termios.Ispeed = uint32(cfg.BaudRate)
+	termios.Ospeed = uint32(cfg.BaudRate)
 
 	// CSIZE  Character size mask.  Values are CS5, CS6, CS7, or CS8.
 	switch cfg.DataBits {
 	case DB5:
-		termios2.Cflag |= unix.CS5
+		termios.Cflag |= unix.CS5
 	case DB6:
-		termios2.Cflag |= unix.CS6
+		termios.Cflag |= unix.CS6
 	case DB7:
-		termios2.Cflag |= unix.CS7
+		termios.Cflag |= unix.CS7
 	case DB8:
-		termios2.Cflag |= unix.CS8
+		termios.Cflag |= unix.CS8
 	}
 
 	// CSTOPB Set two stop bits, rather than one.
+	//        With 5 data bits, UARTs send 1.5 stop bits instead.
 	switch cfg.StopBits {
 	case SB1:
-	case SB2:
-		termios2.Cflag |= unix.CSTOPB
+	case SB1_5, SB2:
+		termios.Cflag |= unix.CSTOPB
 	}
 
 	// PARENB Enable parity generation on output and parity checking for input.
 	// PARODD If set, then parity for input and output is odd; otherwise even parity is used.
+	// CMSPAR Use "stick" (mark/space) parity: mark if PARODD is set, space otherwise.
 	// INPCK  Enable input parity checking.
 	switch cfg.Parity {
 	case PN:
 	case PO:
-		termios2.Cflag |= unix.PARENB | unix.PARODD
-		termios2.Iflag |= unix.INPCK
+		termios.Cflag |= unix.PARENB | unix.PARODD
+		termios.Iflag |= unix.INPCK
 	case PE:
-		termios2.Cflag |= unix.PARENB
-		termios2.Iflag |= unix.INPCK
+		termios.Cflag |= unix.PARENB
+		termios.Iflag |= unix.INPCK
+	case PM:
+		termios.Cflag |= unix.PARENB | unix.CMSPAR | unix.PARODD
+		termios.Iflag |= unix.INPCK
+	case PS:
+		termios.Cflag |= unix.PARENB | unix.CMSPAR
+		termios.Iflag |= unix.INPCK
+	}
+
+	// HUPCL  Lower modem control lines after last process closes the device (hang up).
+	// Without it DTR and RTS keep their state across Close, e.g. to keep a modem call up or
+	// to avoid resetting an attached board; CLOCAL stays set so that modem lines are ignored.
+	if cfg.HangupOnClose {
+		termios.Cflag |= unix.HUPCL
 	}
 
+	applyFlowControl(termios, cfg)
+	applyParityMarking(termios, cfg)
+	applyCaseMapping(termios, cfg)
+
 	// VMIN   Minimum number of characters for noncanonical read (MIN).
 	// VTIME  Timeout in t for noncanonical read (TIME).
-	t := uint8(cfg.Timeout / deciseconds)
-	if t > 0 {
-		termios2.Cc[unix.VMIN] = 0
-		termios2.Cc[unix.VTIME] = t
+	if cfg.Timeout > 0 {
+		termios.Cc[unix.VMIN] = 0
+		termios.Cc[unix.VTIME] = vtime(cfg.Timeout)
 	} else {
-		termios2.Cc[unix.VMIN] = 1
-		termios2.Cc[unix.VTIME] = 0
+		termios.Cc[unix.VMIN] = 1
+		termios.Cc[unix.VTIME] = 0
 	}
+}
+
+// vtime returns the VTIME for a read timeout, rounded up to the next decisecond so that
+// a timeout below 100 ms does not turn into a blocking read, and capped at 25.5 s.
+// read waits for the first byte itself if VTIME cannot hold the timeout exactly, see exactVTIME.
+func vtime(timeout time.Duration) uint8 {
+	t := (timeout + deciseconds - 1) / deciseconds
+	if t > 255 {
+		return 255
+	}
+	return uint8(t)
+}
 
-	return unix.IoctlSetTermios(sp.fd, unix.TCSETS2, &termios2)
+// exactVTIME reports whether VTIME holds timeout exactly.
+func exactVTIME(timeout time.Duration) bool {
+	return timeout%deciseconds == 0 && timeout <= 255*deciseconds
+}
+
+// applyFlowControl sets the flow control of cfg.
+// CRTSCTS      Enable RTS/CTS (hardware) flow control.
+// IXON/IXOFF   Enable XON/XOFF flow control on output and input.
+// VSTART/VSTOP The XON and XOFF characters.
+func applyFlowControl(termios *unix.Termios, cfg Config) {
+	termios.Cflag &^= unix.CRTSCTS
+	termios.Iflag &^= unix.IXON | unix.IXOFF | unix.IXANY
+	termios.Cc[unix.VSTART] = cfg.xonChar()
+	termios.Cc[unix.VSTOP] = cfg.xoffChar()
+
+	switch cfg.FlowControl {
+	case FlowHardware:
+		termios.Cflag |= unix.CRTSCTS
+	case FlowSoftware:
+		termios.Iflag |= unix.IXON | unix.IXOFF
+	}
+}
+
+// applyCaseMapping sets the legacy uppercase terminal flags of cfg.
+// IUCLC  Map uppercase characters to lowercase on input, only honored by Linux together with IEXTEN.
+// OLCUC  Map lowercase characters to uppercase on output, only honored together with OPOST.
+func applyCaseMapping(termios *unix.Termios, cfg Config) {
+	termios.Iflag &^= unix.IUCLC
+	termios.Oflag &^= unix.OLCUC
+
+	if cfg.LowercaseInput {
+		termios.Iflag |= unix.IUCLC
+		termios.Lflag |= unix.IEXTEN
+		// IEXTEN would also enable the literal-next and discard characters.
+		termios.Cc[unix.VLNEXT] = 0
+		termios.Cc[unix.VDISCARD] = 0
+	}
+	if cfg.UppercaseOutput {
+		// OPOST would also enable the other output mappings.
+		termios.Oflag &^= unix.ONLCR | unix.OCRNL | unix.ONOCR | unix.ONLRET
+		termios.Oflag |= unix.OPOST | unix.OLCUC
+	}
+}
+
+// applyParityMarking sets the parity error marking of cfg.
+// PARMRK Prefix a character received with a parity or framing error with \377 \0, and double a valid \377.
+// IGNPAR Ignore such characters, which would take precedence over PARMRK.
+// INPCK  Enable input parity checking.
+func applyParityMarking(termios *unix.Termios, cfg Config) {
+	termios.Iflag &^= unix.PARMRK
+
+	if cfg.MarkParityErrors {
+		termios.Iflag &^= unix.IGNPAR
+		termios.Iflag |= unix.PARMRK | unix.INPCK
+	}
+}
+
+// makeRaw sets raw mode, like cfmakeraw(3): no input or output processing, no echo, no signals.
+// ECHOE and ECHOK are cleared too, so that nothing of a port left in cooked mode by another program remains.
+func makeRaw(termios *unix.Termios) {
+	termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL |
+		unix.IXON | unix.IXOFF | unix.IXANY | unix.INPCK
+	termios.Oflag &^= unix.OPOST
+	termios.Lflag &^= unix.ECHO | unix.ECHOE | unix.ECHOK | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+}
+
+// makeCooked sets canonical mode with echo, CR to NL translation on input and NL to CRLF on output.
+// Signal characters stay disabled, a serial device is not a controlling terminal.
+func makeCooked(termios *unix.Termios) {
+	termios.Iflag |= unix.ICRNL
+	termios.Oflag |= unix.OPOST | unix.ONLCR
+	termios.Lflag |= unix.ICANON | unix.ECHO | unix.ECHOE | unix.ECHOK | unix.IEXTEN
+}
+
+// SetRawMode switches the serial port to raw mode, in which data passes through unchanged.
+// This is the mode Open sets.
+func (sp *SerialPort) SetRawMode() error {
+	return sp.setCookedMode(false)
+}
+
+// SetCookedMode switches the serial port to cooked (canonical) mode, as used by interactive terminals:
+// Read returns whole lines, received data is echoed, CR is read as NL and NL is written as CRLF.
+// The mode is kept across SetConfig until SetRawMode is called.
+func (sp *SerialPort) SetCookedMode() error {
+	return sp.setCookedMode(true)
+}
+
+func (sp *SerialPort) setCookedMode(cooked bool) error {
+	termios, err := unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
+	if err != nil {
+		return err
+	}
+	if cooked {
+		makeCooked(termios)
+	} else {
+		cfg := sp.config()
+		makeRaw(termios)
+		if cfg.Parity != PN {
+			termios.Iflag |= unix.INPCK
+		}
+		applyFlowControl(termios, cfg)
+		applyParityMarking(termios, cfg)
+		applyCaseMapping(termios, cfg)
+	}
+	if err := unix.IoctlSetTermios(sp.fd, unix.TCSETS2, termios); err != nil {
+		return err
+	}
+
+	sp.cmu.Lock()
+	sp.cooked = cooked
+	sp.cmu.Unlock()
+	return nil
 }
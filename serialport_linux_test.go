@@ -1,12 +1,31 @@
 package serialport
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/sys/unix"
 )
 
 func TestHelloWorld(t *testing.T) {
-	sp, err := Open("/dev/pts/3", DefaultConfig())
+	master, slave, err := OpenPTY()
+	if err != nil {
+		t.Skipf("OpenPTY: %v", err)
+	}
+	defer master.Close()
+	sp, err := Open(slave, DefaultConfig())
 	if err != nil {
 		t.Fatalf("Open: %v", err)
 	}
@@ -16,61 +35,2619 @@ func TestHelloWorld(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Write: %v", err)
 	}
-
 	t.Logf("Write %v bytes to serial port", n)
+
+	buf := make([]byte, n)
+	if _, err := master.ReadFull(buf); err != nil || string(buf) != "Hello, World" {
+		t.Fatalf("master ReadFull = %q, %v; want \"Hello, World\"", buf, err)
+	}
 }
 
 func TestEcho(t *testing.T) {
+	master, slave, err := OpenPTY()
+	if err != nil {
+		t.Skipf("OpenPTY: %v", err)
+	}
+	defer master.Close()
 	cfg := DefaultConfig()
-	//cfg.Timeout = 0
 	cfg.Timeout = 1000 * time.Millisecond
-	sp, err := Open("/dev/pts/3", cfg)
+	sp, err := Open(slave, cfg)
 	if err != nil {
 		t.Fatalf("Open: %v", err)
 	}
 	defer sp.Close()
 
-	buf := make([]byte, 64)
-	for {
+	for _, msg := range []string{"ping", "hello", "\x00\xff"} {
+		if _, err := master.WriteString(msg); err != nil {
+			t.Fatalf("master Write: %v", err)
+		}
+
+		buf := make([]byte, 64)
 		n, err := sp.Read(buf)
 		if err != nil {
 			t.Fatalf("Read: %v", err)
 		}
 		t.Logf("Read(%v): %v", n, buf[:n])
-
-		n, err = sp.Write(buf[:n])
-		if err != nil {
+		if _, err = sp.Write(buf[:n]); err != nil {
 			t.Fatalf("Write: %v", err)
 		}
-		t.Logf("Write(%v): %v", n, buf[:n])
+
+		echo := make([]byte, len(msg))
+		if _, err := master.ReadFull(echo); err != nil || string(echo) != msg {
+			t.Fatalf("echo = %q, %v; want %q", echo, err, msg)
+		}
 	}
 }
 
 func TestFlush(t *testing.T) {
-	cfg := DefaultConfig()
-	cfg.Timeout = 12 * time.Second
-	sp, err := Open("/dev/pts/3", cfg)
+	master, slave, err := OpenPTY()
+	if err != nil {
+		t.Skipf("OpenPTY: %v", err)
+	}
+	defer master.Close()
+	sp, err := Open(slave, DefaultConfig())
 	if err != nil {
 		t.Fatalf("Open: %v", err)
 	}
 	defer sp.Close()
 
-	buf := make([]byte, 2)
-	n, err := sp.Read(buf)
+	master.WriteString("stale")
+	time.Sleep(20 * time.Millisecond)
+	if err := sp.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	buf := make([]byte, 8)
+	if n, err := sp.Read(buf); n != 0 || err != nil {
+		t.Fatalf("Read after Flush = %q, %v; want nothing", buf[:n], err)
+	}
+	master.WriteString("fresh")
+	if n, err := sp.Read(buf); string(buf[:n]) != "fresh" || err != nil {
+		t.Fatalf("Read = %q, %v; want \"fresh\"", buf[:n], err)
+	}
+}
+
+func TestReadEmptyBuffer(t *testing.T) {
+	// An invalid fd makes any syscall fail with EBADF.
+	sp := &SerialPort{fd: -1}
+
+	for _, b := range [][]byte{nil, {}} {
+		n, err := sp.Read(b)
+		if n != 0 || err != nil {
+			t.Fatalf("Read(%v) = %v, %v; want 0, nil", b, n, err)
+		}
+	}
+}
+
+// openPTY opens a pseudo-terminal pair, returning the master fd and the slave device name.
+func openPTY(t *testing.T) (master int, slave string) {
+	t.Helper()
+
+	m, slave, err := OpenPTY()
 	if err != nil {
-		t.Fatalf("Read: %v", err)
+		t.Skipf("no pseudo-terminal support: %v", err)
 	}
-	t.Logf("Read %v bytes: %v", n, string(buf[:n]))
+	return m.fd, slave
+}
 
-	sp.Flush()
-	for {
-		n, err = sp.Read(buf)
+func TestReadEOFWhenMasterCloses(t *testing.T) {
+	master, slave := openPTY(t)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		unix.Close(master)
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	// Still connected: a timeout is not EOF.
+	buf := make([]byte, 8)
+	if n, err := sp.Read(buf); n != 0 || err != nil {
+		t.Fatalf("Read before close = %v, %v; want 0, nil", n, err)
+	}
+
+	unix.Close(master)
+	if n, err := sp.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Read after close = %v, %v; want 0, EOF", n, err)
+	}
+}
+
+func TestErrorFilter(t *testing.T) {
+	master, slave := openPTY(t)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		unix.Close(master)
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+	unix.Close(master)
+
+	buf := make([]byte, 8)
+	sp.SetErrorFilter(func(err error) error {
+		if err == io.EOF {
+			return nil
+		}
+		return err
+	})
+	if n, err := sp.Read(buf); n != 0 || err != nil {
+		t.Fatalf("Read with EOF suppressed = %v, %v; want 0, nil", n, err)
+	}
+
+	calls := 0
+	sp.SetErrorFilter(func(err error) error {
+		if calls++; calls < 3 {
+			return ErrRetry
+		}
+		return err
+	})
+	if n, err := sp.Read(buf); n != 0 || err != io.EOF || calls != 3 {
+		t.Fatalf("Read retried = %v, %v after %v calls; want 0, EOF after 3", n, err, calls)
+	}
+
+	// A Write whose error is suppressed still reports that it stopped short.
+	sp.SetErrorFilter(func(error) error { return nil })
+	if n, err := sp.Write([]byte("lost")); n != 0 || err != io.ErrShortWrite {
+		t.Fatalf("Write with errors suppressed = %v, %v; want 0, ErrShortWrite", n, err)
+	}
+
+	sp.SetErrorFilter(nil)
+	if n, err := sp.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Read with default filter = %v, %v; want 0, EOF", n, err)
+	}
+}
+
+func TestReadEOFWhenSlaveCloses(t *testing.T) {
+	sp, err := Open("/dev/ptmx", DefaultConfig())
+	if err != nil {
+		t.Skipf("no pseudo-terminal support: %v", err)
+	}
+	defer sp.Close()
+
+	if err = unix.IoctlSetPointerInt(sp.fd, unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatalf("unlockpt: %v", err)
+	}
+	n, err := unix.IoctlGetUint32(sp.fd, unix.TIOCGPTN)
+	if err != nil {
+		t.Fatalf("ptsname: %v", err)
+	}
+	slave, err := unix.Open(fmt.Sprintf("/dev/pts/%d", n), unix.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatalf("open slave: %v", err)
+	}
+	if _, err = unix.Write(slave, []byte("bye")); err != nil {
+		t.Fatalf("write slave: %v", err)
+	}
+	unix.Close(slave)
+
+	// Data written before the close is still delivered, then EOF.
+	buf := make([]byte, 8)
+	if n, err := sp.Read(buf); string(buf[:n]) != "bye" || err != nil {
+		t.Fatalf("Read = %q, %v; want \"bye\", nil", buf[:n], err)
+	}
+	if n, err := sp.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Read after close = %v, %v; want 0, EOF", n, err)
+	}
+}
+
+func TestReadUntilLine(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if _, err = unix.Write(master, []byte("+CSQ: 20,0\r\n\r\nOK\r\nRING")); err != nil {
+		t.Fatalf("write master: %v", err)
+	}
+
+	lines, err := sp.ReadUntilLine("OK")
+	if err != nil {
+		t.Fatalf("ReadUntilLine: %v", err)
+	}
+	if want := []string{"+CSQ: 20,0", "", "OK"}; !reflect.DeepEqual(lines, want) {
+		t.Fatalf("ReadUntilLine = %q, want %q", lines, want)
+	}
+
+	// Bytes read ahead are not lost for raw reads.
+	buf := make([]byte, 8)
+	if n, err := sp.Read(buf); string(buf[:n]) != "RING" || err != nil {
+		t.Fatalf("Read = %q, %v; want \"RING\", nil", buf[:n], err)
+	}
+
+	if lines, err = sp.ReadLines(1); len(lines) != 0 || err != ErrTimeout {
+		t.Fatalf("ReadLines(1) = %q, %v; want none, ErrTimeout", lines, err)
+	}
+}
+
+func TestConcurrentReadWrite(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	in := bytes.Repeat([]byte("0123456789"), 2000)
+	out := bytes.Repeat([]byte("abcdefghij"), 2000)
+
+	// The peer drains what sp writes while feeding what sp reads.
+	peerGot := make(chan []byte, 1)
+	go func() {
+		buf := make([]byte, len(out))
+		n := 0
+		for n < len(buf) {
+			m, err := unix.Read(master, buf[n:])
+			if err != nil {
+				break
+			}
+			n += m
+		}
+		peerGot <- buf[:n]
+	}()
+	go func() {
+		for p := in; len(p) > 0; {
+			m, err := unix.Write(master, p)
+			if err != nil {
+				return
+			}
+			p = p[m:]
+		}
+	}()
+
+	writeErr := make(chan error, 1)
+	go func() {
+		_, err := sp.Write(out)
+		writeErr <- err
+	}()
+	got := make([]byte, len(in))
+	for n := 0; n < len(got); {
+		m, err := sp.Read(got[n:])
 		if err != nil {
 			t.Fatalf("Read: %v", err)
 		}
-		if n == 0 {
-			break
+		n += m
+	}
+
+	if err := <-writeErr; err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if !bytes.Equal(got, in) {
+		t.Fatalf("read data differs from what the peer sent")
+	}
+	if p := <-peerGot; !bytes.Equal(p, out) {
+		t.Fatalf("peer received %v bytes, want the %v bytes written", len(p), len(out))
+	}
+}
+
+func TestWriteString(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if n, err := sp.WriteString("AT\r\n"); n != 4 || err != nil {
+		t.Fatalf("WriteString = %v, %v; want 4, nil", n, err)
+	}
+	buf := make([]byte, 8)
+	if n, err := unix.Read(master, buf); string(buf[:n]) != "AT\r\n" || err != nil {
+		t.Fatalf("master read = %q, %v; want \"AT\\r\\n\"", buf[:n], err)
+	}
+}
+
+func TestReadLine(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	unix.Write(master, []byte("$GPGGA,1*47\r\n$GPRMC,2"))
+	if line, err := sp.ReadLine(); line != "$GPGGA,1*47" || err != nil {
+		t.Fatalf("ReadLine = %q, %v; want \"$GPGGA,1*47\", nil", line, err)
+	}
+
+	// A half-received line times out and is completed by the next ReadLine.
+	if line, err := sp.ReadLine(); line != "" || err != ErrTimeout {
+		t.Fatalf("ReadLine of a partial line = %q, %v; want \"\", ErrTimeout", line, err)
+	}
+	unix.Write(master, []byte("*4B\nraw"))
+	if line, err := sp.ReadLine(); line != "$GPRMC,2*4B" || err != nil {
+		t.Fatalf("ReadLine = %q, %v; want \"$GPRMC,2*4B\", nil", line, err)
+	}
+
+	// Switching back to Read loses nothing.
+	buf := make([]byte, 8)
+	if n, err := sp.Read(buf); string(buf[:n]) != "raw" || err != nil {
+		t.Fatalf("Read = %q, %v; want \"raw\", nil", buf[:n], err)
+	}
+}
+
+func TestReadFull(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	// The bytes arrive in several reads, well within the Timeout in total.
+	go func() {
+		unix.Write(master, []byte("abc"))
+		time.Sleep(30 * time.Millisecond)
+		unix.Write(master, []byte("def"))
+	}()
+	buf := make([]byte, 6)
+	if n, err := sp.ReadFull(buf); string(buf[:n]) != "abcdef" || err != nil {
+		t.Fatalf("ReadFull = %q, %v; want \"abcdef\", nil", buf[:n], err)
+	}
+
+	// The Timeout bounds the whole call, not each read.
+	go func() {
+		for _, c := range []byte("wxyz") {
+			unix.Write(master, []byte{c})
+			time.Sleep(40 * time.Millisecond)
+		}
+	}()
+	start := time.Now()
+	n, err := sp.ReadFull(make([]byte, 8))
+	if elapsed := time.Since(start); n == 0 || n == 4 || err != io.ErrUnexpectedEOF || elapsed > 150*time.Millisecond {
+		t.Fatalf("partial ReadFull = %v, %v after %v; want some bytes, ErrUnexpectedEOF after the Timeout", n, err, elapsed)
+	}
+	time.Sleep(150 * time.Millisecond)
+	sp.Flush()
+
+	if n, err := sp.ReadFull(buf); n != 0 || err != ErrTimeout {
+		t.Fatalf("idle ReadFull = %v, %v; want 0, ErrTimeout", n, err)
+	}
+}
+
+func TestOpenNoResetOnOpen(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.NoResetOnOpen = true
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	// The fd must be back in blocking mode.
+	flags, err := unix.FcntlInt(uintptr(sp.fd), unix.F_GETFL, 0)
+	if err != nil {
+		t.Fatalf("F_GETFL: %v", err)
+	}
+	if flags&unix.O_NONBLOCK != 0 {
+		t.Fatalf("fd left in non-blocking mode")
+	}
+}
+
+func TestManagerReconnect(t *testing.T) {
+	master, slave := openPTY(t)
+
+	m := NewManager(10 * time.Millisecond)
+	defer m.CloseAll()
+
+	if err := m.Register(slave, DefaultConfig()); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if err := m.Register(slave, DefaultConfig()); err == nil {
+		t.Fatalf("Register twice succeeded")
+	}
+
+	ev := <-m.Events()
+	if !ev.Connected || ev.Name != slave {
+		t.Fatalf("first event = %+v, want connected", ev)
+	}
+	if sp, ok := m.Get(slave); !ok || sp != ev.Port {
+		t.Fatalf("Get = %v, %v; want connected port", sp, ok)
+	}
+
+	// Closing the master hangs up the slave, like unplugging an adapter.
+	unix.Close(master)
+	ev = <-m.Events()
+	if ev.Connected || ev.Err == nil {
+		t.Fatalf("second event = %+v, want disconnected with error", ev)
+	}
+	if _, ok := m.Get(slave); ok {
+		t.Fatalf("Get succeeded after disconnect")
+	}
+}
+
+func TestCloseManagedPort(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	base := runtime.NumGoroutine()
+
+	m := NewManager(10 * time.Millisecond)
+	if err := m.Register(slave, DefaultConfig()); err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	ev := <-m.Events()
+	if !ev.Connected {
+		t.Fatalf("first event = %+v, want connected", ev)
+	}
+
+	// Concurrent closes must not race: exactly one of them closes the port.
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func() { errs <- ev.Port.Close() }()
+	}
+	var closed int
+	for i := 0; i < cap(errs); i++ {
+		switch err := <-errs; err {
+		case nil:
+			closed++
+		case ErrClosed:
+		default:
+			t.Fatalf("Close: %v", err)
+		}
+	}
+	if closed != 1 {
+		t.Fatalf("%d concurrent closes succeeded, want 1", closed)
+	}
+
+	// The Manager gives up on the port instead of reopening it.
+	ev = <-m.Events()
+	if ev.Connected || ev.Err != ErrClosed {
+		t.Fatalf("second event = %+v, want disconnected with ErrClosed", ev)
+	}
+	if _, ok := m.Get(slave); ok {
+		t.Fatalf("Get succeeded after Close")
+	}
+	m.CloseAll()
+
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > base {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines running after CloseAll, want %d", runtime.NumGoroutine(), base)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWriteLarge(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	want := make([]byte, 1<<20)
+	for i := range want {
+		want[i] = byte(i * 7)
+	}
+
+	got := make(chan []byte, 1)
+	go func() {
+		b := make([]byte, 0, len(want))
+		buf := make([]byte, 4096)
+		for len(b) < len(want) {
+			n, err := unix.Read(master, buf)
+			if err != nil {
+				break
+			}
+			b = append(b, buf[:n]...)
+		}
+		got <- b
+	}()
+
+	if n, err := sp.Write(want); n != len(want) || err != nil {
+		t.Fatalf("Write = %v, %v; want %v, nil", n, err, len(want))
+	}
+	if b := <-got; !bytes.Equal(b, want) {
+		t.Fatalf("received %d bytes, differing from the %d written", len(b), len(want))
+	}
+}
+
+func TestReadersKeepPartialData(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	readers := []struct {
+		name string
+		read func() ([]byte, error)
+	}{
+		{"ReadUntil", func() ([]byte, error) { return sp.ReadUntil('\n') }},
+		{"Expect", func() ([]byte, error) { return sp.Expect(nil, regexp.MustCompile(`OK`), 100*time.Millisecond) }},
+	}
+	for _, r := range readers {
+		// Nothing received: empty, not nil.
+		if got, err := r.read(); got == nil || len(got) != 0 || err != ErrTimeout {
+			t.Fatalf("%s on an idle line = %#v, %v; want empty, ErrTimeout", r.name, got, err)
+		}
+
+		unix.Write(master, []byte("AB"))
+		if got, err := r.read(); string(got) != "AB" || err != ErrTimeout {
+			t.Fatalf("%s = %q, %v; want \"AB\", ErrTimeout", r.name, got, err)
 		}
-		t.Logf("Read %v bytes: %v", n, string(buf[:n]))
+	}
+
+	// Line readers keep the partial line for the next call instead.
+	unix.Write(master, []byte("AB"))
+	if lines, err := sp.ReadLines(1); len(lines) != 0 || err != ErrTimeout {
+		t.Fatalf("ReadLines = %q, %v; want none, ErrTimeout", lines, err)
+	}
+	unix.Write(master, []byte("C\nD"))
+	if got, err := sp.ReadUntil('\n'); string(got) != "ABC\n" || err != nil {
+		t.Fatalf("ReadUntil = %q, %v; want \"ABC\\n\", nil", got, err)
+	}
+	if got, err := sp.ReadUntil('\n'); string(got) != "D" || err != ErrTimeout {
+		t.Fatalf("ReadUntil = %q, %v; want \"D\", ErrTimeout", got, err)
+	}
+}
+
+func TestCommErrorsSince(t *testing.T) {
+	prev := serialIcounter{Rx: 10, Frame: 1, Brk: 2}
+	cur := serialIcounter{Rx: 99, Frame: 3, Brk: 2, Parity: 1}
+	if flags := commErrorsSince(prev, cur); flags != CommFrame|CommParity {
+		t.Fatalf("commErrorsSince = %#x, want CommFrame|CommParity", flags)
+	}
+	if flags := commErrorsSince(cur, cur); flags != 0 {
+		t.Fatalf("commErrorsSince without new errors = %#x, want 0", flags)
+	}
+}
+
+func TestLineErrorsSince(t *testing.T) {
+	prev := serialIcounter{Rx: 10, Frame: 1, Brk: 2, Overrun: 1}
+	cur := serialIcounter{Rx: 99, Frame: 3, Brk: 2, Parity: 1, Overrun: 2, BufOverrun: 4}
+	want := LineErrorStats{Parity: 1, Framing: 2, Overrun: 5}
+	if got := lineErrorsSince(prev, cur); got != want {
+		t.Fatalf("lineErrorsSince = %+v, want %+v", got, want)
+	}
+}
+
+func TestDecodeModemBits(t *testing.T) {
+	m := decodeModemBits(unix.TIOCM_DSR | unix.TIOCM_RNG | unix.TIOCM_DTR)
+	if m != ModemDSR|ModemRI {
+		t.Fatalf("decodeModemBits = %v, want DSR|RI", m)
+	}
+}
+
+func TestPollMode(t *testing.T) {
+	master, slave := openPTY(t)
+
+	// VTIME cannot express 30 ms, poll mode can.
+	cfg := DefaultConfig()
+	cfg.Timeout = 30 * time.Millisecond
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		unix.Close(master)
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+	if err := sp.SetPollMode(true); err != nil {
+		t.Fatalf("SetPollMode: %v", err)
+	}
+
+	buf := make([]byte, 8)
+	start := time.Now()
+	if n, err := sp.Read(buf); n != 0 || err != nil {
+		t.Fatalf("Read on an idle line = %v, %v; want 0, nil", n, err)
+	}
+	if d := time.Since(start); d < 30*time.Millisecond || d > 90*time.Millisecond {
+		t.Fatalf("Read timed out after %v, want 30 ms", d)
+	}
+
+	if n, err := sp.Write([]byte("ping")); n != 4 || err != nil {
+		t.Fatalf("Write = %v, %v; want 4, nil", n, err)
+	}
+	if n, _ := unix.Read(master, buf); string(buf[:n]) != "ping" {
+		t.Fatalf("master read %q, want \"ping\"", buf[:n])
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		unix.Write(master, []byte("pong"))
+	}()
+	if got, err := sp.ReadExact(4); string(got) != "pong" || err != nil {
+		t.Fatalf("ReadExact = %q, %v; want \"pong\", nil", got, err)
+	}
+
+	unix.Close(master)
+	if n, err := sp.Read(buf); n != 0 || err != io.EOF {
+		t.Fatalf("Read after close = %v, %v; want 0, EOF", n, err)
+	}
+}
+
+func TestSendBreak(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	start := time.Now()
+	if err := sp.SendBreak(20 * time.Millisecond); err != nil {
+		t.Skipf("SendBreak on a pty: %v", err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond {
+		t.Fatalf("SendBreak returned after %v, want at least 20 ms", d)
+	}
+}
+
+func TestDrain(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if _, err := sp.Write([]byte("drained")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := sp.Drain(); err != nil {
+		t.Fatalf("Drain: %v", err)
+	}
+	if n, err := sp.outWaiting(); err != nil || n != 0 {
+		t.Fatalf("outWaiting after Drain = %v, %v; want 0", n, err)
+	}
+
+	// Unlike Flush, Drain discards nothing.
+	buf := make([]byte, 16)
+	n, err := unix.Read(master, buf)
+	if err != nil || string(buf[:n]) != "drained" {
+		t.Fatalf("master read %q, %v; want %q", buf[:n], err, "drained")
+	}
+}
+
+// openXMODEMPair opens the slave of a pseudo-terminal and wraps its master in a SerialPort,
+// so that both ends of a transfer can run the package's own implementation.
+func openXMODEMPair(t *testing.T) (sp, peer *SerialPort) {
+	t.Helper()
+
+	master, slave := openPTY(t)
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		unix.Close(master)
+		t.Fatalf("Open: %v", err)
+	}
+	return sp, &SerialPort{fd: master, name: "/dev/ptmx"}
+}
+
+func TestXMODEM(t *testing.T) {
+	sp, peer := openXMODEMPair(t)
+	defer sp.Close()
+	defer peer.Close()
+
+	data := bytes.Repeat([]byte("0123456789abcdef"), 20) // 320 bytes, the last block is padded
+	done := make(chan error, 1)
+	go func() { done <- peer.SendXMODEM(bytes.NewReader(data)) }()
+
+	var got bytes.Buffer
+	if err := sp.ReceiveXMODEM(&got); err != nil {
+		t.Fatalf("ReceiveXMODEM: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("SendXMODEM: %v", err)
+	}
+	want := append(append([]byte(nil), data...), bytes.Repeat([]byte{xmSUB}, 3*128-len(data))...)
+	if !bytes.Equal(got.Bytes(), want) {
+		t.Fatalf("received %q, want %q", got.Bytes(), want)
+	}
+}
+
+func TestYMODEM(t *testing.T) {
+	sp, peer := openXMODEMPair(t)
+	defer sp.Close()
+	defer peer.Close()
+
+	data := bytes.Repeat([]byte{0x00, 0xff, xmSOH, xmEOT, xmCAN}, 500) // 2500 bytes: two 1024-byte blocks and a 128-byte one
+	done := make(chan error, 1)
+	go func() { done <- peer.SendYMODEM("fw.bin", int64(len(data)), bytes.NewReader(data)) }()
+
+	var got bytes.Buffer
+	name, size, err := sp.ReceiveYMODEM(&got)
+	if err != nil {
+		t.Fatalf("ReceiveYMODEM: %v", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("SendYMODEM: %v", err)
+	}
+	if name != "fw.bin" || size != int64(len(data)) {
+		t.Fatalf("ReceiveYMODEM = %q, %v; want %q, %v", name, size, "fw.bin", len(data))
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Fatalf("received %v bytes, want the %v sent", got.Len(), len(data))
+	}
+}
+
+func TestXMODEMBadBlock(t *testing.T) {
+	sp, peer := openXMODEMPair(t)
+	defer sp.Close()
+	defer peer.Close()
+
+	done := make(chan error, 1)
+	var got bytes.Buffer
+	go func() { done <- sp.ReceiveXMODEM(&got) }()
+
+	if c, err := (&xmodem{sp: peer}).readByte(time.Second); err != nil || c != xmCRC {
+		t.Fatalf("receiver requested %#02x, %v; want 'C'", c, err)
+	}
+	x := &xmodem{sp: peer, crc: true}
+	block := x.block(1, []byte("payload"), 128, xmSUB)
+	bad := append([]byte(nil), block...)
+	bad[10] ^= 0xff
+	if err := x.write(bad); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	if c, err := x.readByte(5 * time.Second); err != nil || c != xmNAK {
+		t.Fatalf("reply to a damaged block = %#02x, %v; want NAK", c, err)
+	}
+	if err := x.sendBlock(1, block); err != nil {
+		t.Fatalf("sendBlock: %v", err)
+	}
+	if err := x.write([]byte{xmEOT}); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("ReceiveXMODEM: %v", err)
+	}
+	if !bytes.HasPrefix(got.Bytes(), []byte("payload")) || got.Len() != 128 {
+		t.Fatalf("received %q, want the block once", got.Bytes())
+	}
+}
+
+func TestString(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.FlowControl = FlowSoftware
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if s, want := sp.String(), slave+" 115200 8N1 xonxoff timeout=100ms"; s != want {
+		t.Fatalf("String() = %q, want %q", s, want)
+	}
+}
+
+func TestNameAndAppliedConfig(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if name := sp.Name(); name != slave {
+		t.Fatalf("Name() = %q, want %q", name, slave)
+	}
+
+	cfg := DefaultConfig()
+	cfg.BaudRate = BR9600
+	if err := sp.SetConfig(cfg); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	bad := cfg
+	bad.DataBits = 9
+	if err := sp.SetConfig(bad); err == nil {
+		t.Fatalf("SetConfig(9 data bits) succeeded")
+	}
+	if got := sp.AppliedConfig(); got != cfg {
+		t.Fatalf("AppliedConfig() = %+v, want %+v", got, cfg)
+	}
+}
+
+func TestAccessMode(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.Mode = ModeReadOnly
+	cfg.Timeout = time.Second
+	ro, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open(read-only): %v", err)
+	}
+	defer ro.Close()
+
+	if _, err := ro.Write([]byte("x")); err == nil {
+		t.Fatalf("Write on a read-only port succeeded")
+	}
+	if _, err := unix.Write(master, []byte("hi")); err != nil {
+		t.Fatalf("write master: %v", err)
+	}
+	buf := make([]byte, 8)
+	if n, err := ro.Read(buf); err != nil || string(buf[:n]) != "hi" {
+		t.Fatalf("Read = %q, %v; want \"hi\", nil", buf[:n], err)
+	}
+	if got, err := ro.Config(); err != nil || got.Mode != ModeReadOnly {
+		t.Fatalf("Config().Mode = %v, %v; want read-only", got.Mode, err)
+	}
+
+	cfg.Mode = ModeWriteOnly
+	wo, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open(write-only): %v", err)
+	}
+	defer wo.Close()
+
+	if _, err := wo.Write([]byte("ok")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	cfg.Mode = 3
+	if _, err := Open(slave, cfg); !errors.As(err, new(*ConfigError)) {
+		t.Fatalf("Open(mode 3) = %v, want a ConfigError", err)
+	}
+}
+
+func TestExclusive(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.Exclusive = true
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if got, err := sp.Config(); err != nil || !got.Exclusive {
+		t.Fatalf("Config().Exclusive = %v, %v; want true", got.Exclusive, err)
+	}
+
+	if _, err := Open(slave, cfg); !errors.Is(err, ErrPortBusy) {
+		t.Fatalf("second exclusive Open = %v, want ErrPortBusy", err)
+	}
+
+	if err := sp.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	sp, err = Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open after Close: %v", err)
+	}
+	sp.Close()
+}
+
+func TestMarkParityErrors(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.MarkParityErrors = true
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if got, err := sp.Config(); err != nil || !got.MarkParityErrors {
+		t.Fatalf("Config().MarkParityErrors = %v, %v; want true", got.MarkParityErrors, err)
+	}
+
+	// A pty cannot produce parity errors, but the driver still escapes a valid 0xFF.
+	if _, err := unix.Write(master, []byte{'a', 0xff, 'b'}); err != nil {
+		t.Fatalf("write master: %v", err)
+	}
+	buf := make([]byte, 8)
+	n, err := sp.Read(buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	out, rest := DecodeParityMarks(buf[:n])
+	want := []MarkedByte{{Value: 'a'}, {Value: 0xff}, {Value: 'b'}}
+	if !reflect.DeepEqual(out, want) || len(rest) != 0 {
+		t.Fatalf("DecodeParityMarks(%x) = %v, %x; want %v", buf[:n], out, rest, want)
+	}
+}
+
+func TestTransaction(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	// A stale reply to an earlier request must not be taken for the response.
+	unix.Write(master, []byte("STALE\n"))
+	time.Sleep(50 * time.Millisecond)
+
+	go func() {
+		buf := make([]byte, 64)
+		unix.Read(master, buf)
+		unix.Write(master, []byte("OK\nNEXT"))
+	}()
+	resp, err := sp.Transaction([]byte("AT\r"), '\n', time.Second)
+	if string(resp) != "OK\n" || err != nil {
+		t.Fatalf("Transaction = %q, %v; want \"OK\\n\", nil", resp, err)
+	}
+	buf := make([]byte, 8)
+	if n, err := sp.Read(buf); string(buf[:n]) != "NEXT" || err != nil {
+		t.Fatalf("Read after Transaction = %q, %v; want \"NEXT\", nil", buf[:n], err)
+	}
+
+	// No response: the partial data and ErrTimeout.
+	go func() {
+		buf := make([]byte, 64)
+		unix.Read(master, buf)
+		unix.Write(master, []byte("PART"))
+	}()
+	resp, err = sp.Transaction([]byte("AT\r"), '\n', 200*time.Millisecond)
+	if string(resp) != "PART" || err != ErrTimeout {
+		t.Fatalf("Transaction without delimiter = %q, %v; want \"PART\", ErrTimeout", resp, err)
+	}
+}
+
+func TestOpenClearsCookedMode(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	// Leave the line in cooked mode, as a terminal program would.
+	fd, err := unix.Open(slave, unix.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatalf("open slave: %v", err)
+	}
+	defer unix.Close(fd)
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS2)
+	if err != nil {
+		t.Fatalf("TCGETS2: %v", err)
+	}
+	termios.Lflag |= unix.ICANON | unix.ECHO | unix.ECHOE | unix.ISIG
+	termios.Oflag |= unix.OPOST | unix.ONLCR
+	termios.Iflag |= unix.ICRNL | unix.INLCR | unix.IGNCR | unix.IXON
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS2, termios); err != nil {
+		t.Fatalf("TCSETS2: %v", err)
+	}
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	termios, err = unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
+	if err != nil {
+		t.Fatalf("TCGETS2: %v", err)
+	}
+	if termios.Lflag&(unix.ICANON|unix.ECHO|unix.ECHOE|unix.ISIG) != 0 ||
+		termios.Oflag&unix.OPOST != 0 ||
+		termios.Iflag&(unix.ICRNL|unix.INLCR|unix.IGNCR|unix.IXON) != 0 {
+		t.Fatalf("not raw after Open: iflag %#x oflag %#x lflag %#x", termios.Iflag, termios.Oflag, termios.Lflag)
+	}
+
+	// Data passes through unchanged, without waiting for a line ending.
+	unix.Write(master, []byte("a\rb"))
+	buf := make([]byte, 8)
+	if n, err := sp.Read(buf); string(buf[:n]) != "a\rb" || err != nil {
+		t.Fatalf("Read = %q, %v; want \"a\\rb\", nil", buf[:n], err)
+	}
+}
+
+func TestHangupOnClose(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	for _, hupcl := range []bool{true, false, true} {
+		cfg := DefaultConfig()
+		cfg.HangupOnClose = hupcl
+		sp, err := Open(slave, cfg)
+		if err != nil {
+			t.Fatalf("Open: %v", err)
+		}
+		termios, err := unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
+		sp.Close()
+		if err != nil {
+			t.Fatalf("TCGETS2: %v", err)
+		}
+		// HUPCL is kept by the tty across opens, so clearing it must undo a previous Open.
+		if got := termios.Cflag&unix.HUPCL != 0; got != hupcl {
+			t.Fatalf("HUPCL = %v with HangupOnClose %v", got, hupcl)
+		}
+	}
+}
+
+func TestIsOpen(t *testing.T) {
+	master, slave := openPTY(t)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		unix.Close(master)
+		t.Fatalf("Open: %v", err)
+	}
+	if !sp.IsOpen() {
+		t.Fatalf("IsOpen() = false after Open")
+	}
+
+	// Closing the master hangs up the slave, like unplugging a USB adapter.
+	unix.Close(master)
+	if sp.IsOpen() {
+		t.Fatalf("IsOpen() = true after hangup")
+	}
+
+	sp.Close()
+	if sp.IsOpen() {
+		t.Fatalf("IsOpen() = true after Close")
+	}
+}
+
+func TestOpenWithOptions(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := OpenWithOptions(slave, WithBaud(BR9600), WithParity(PE), WithTimeout(time.Second), WithFlowControl(FlowSoftware))
+	if err != nil {
+		t.Fatalf("OpenWithOptions: %v", err)
+	}
+	defer sp.Close()
+
+	want := DefaultConfig()
+	want.BaudRate = BR9600
+	want.Parity = PE
+	want.Timeout = time.Second
+	want.FlowControl = FlowSoftware
+	if got := sp.AppliedConfig(); got != want {
+		t.Fatalf("AppliedConfig() = %+v, want %+v", got, want)
+	}
+
+	if _, err := OpenWithOptions(slave, WithParity(9)); !errors.As(err, new(*ConfigError)) {
+		t.Fatalf("OpenWithOptions(parity 9) = %v, want a ConfigError", err)
+	}
+}
+
+// shortWriter accepts at most n bytes per Write, without an error.
+type shortWriter struct{ n int }
+
+func (w shortWriter) Write(b []byte) (int, error) {
+	if len(b) > w.n {
+		return w.n, nil
+	}
+	return len(b), nil
+}
+
+func TestPacedWriter(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	const gap = 20 * time.Millisecond
+
+	// Per byte: each byte is written on its own, at least gap after the previous one.
+	start := time.Now()
+	if n, err := PacedWriter(sp, gap).Write([]byte("abcd")); n != 4 || err != nil {
+		t.Fatalf("PacedWriter.Write = %v, %v; want 4, nil", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 3*gap {
+		t.Fatalf("PacedWriter wrote 4 bytes in %v, want at least %v", elapsed, 3*gap)
+	}
+	if got := readMaster(t, master, 4); got != "abcd" {
+		t.Fatalf("master read %q, want \"abcd\"", got)
+	}
+
+	// Per call: each Write is forwarded whole, at least gap after the previous one.
+	w := PacedCallWriter(sp, gap)
+	start = time.Now()
+	for i, chunk := range []string{"hello", "world"} {
+		if n, err := w.Write([]byte(chunk)); n != len(chunk) || err != nil {
+			t.Fatalf("PacedCallWriter.Write(%q) = %v, %v", chunk, n, err)
+		}
+		if elapsed := time.Since(start); elapsed < time.Duration(i)*gap {
+			t.Fatalf("PacedCallWriter wrote %q %v after starting, want at least %v", chunk, elapsed, time.Duration(i)*gap)
+		}
+		if got := readMaster(t, master, len(chunk)); got != chunk {
+			t.Fatalf("master read %q, want %q", got, chunk)
+		}
+	}
+
+	// A short write by the port is reported, not passed off as success.
+	for _, pw := range []*pacedWriter{{w: shortWriter{0}, gap: gap}, {w: shortWriter{2}, gap: gap, perWrite: true}} {
+		if n, err := pw.Write([]byte("abc")); err != io.ErrShortWrite {
+			t.Fatalf("Write through a short writer (per write %v) = %v, %v; want io.ErrShortWrite", pw.perWrite, n, err)
+		}
+	}
+}
+
+func TestWriteWithProgressStrictSevenBit(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.DataBits = DB7
+	cfg.StrictSevenBit = true
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	// Chunks are written like Write, which checks them.
+	calls := 0
+	n, err := sp.WriteWithProgress([]byte("ok\x80"), func(written, total int) { calls++ })
+	if n != 0 || err == nil || calls != 1 {
+		t.Fatalf("WriteWithProgress of a high byte = %v, %v after %v calls; want 0, an error after 1", n, err, calls)
+	}
+	if n, err := sp.WriteWithProgress([]byte("ok"), nil); n != 2 || err != nil {
+		t.Fatalf("WriteWithProgress = %v, %v; want 2, nil", n, err)
+	}
+	if got := readMaster(t, master, 2); got != "ok" {
+		t.Fatalf("master read %q, want \"ok\"", got)
+	}
+}
+
+// readMaster reads n bytes from the pty master, failing the test after a second.
+func readMaster(t *testing.T, master int, n int) string {
+	t.Helper()
+
+	var got []byte
+	deadline := time.Now().Add(time.Second)
+	buf := make([]byte, 64)
+	for len(got) < n && time.Now().Before(deadline) {
+		fds := []unix.PollFd{{Fd: int32(master), Events: unix.POLLIN}}
+		if k, _ := unix.Poll(fds, 100); k == 0 {
+			continue
+		}
+		m, err := unix.Read(master, buf[:n-len(got)])
+		if err != nil {
+			t.Fatalf("read master: %v", err)
+		}
+		got = append(got, buf[:m]...)
+	}
+	return string(got)
+}
+
+func TestRS485Config(t *testing.T) {
+	cfg := RS485Config{Enabled: true, RTSOnSend: true, DelayBeforeSend: 1500 * time.Microsecond}
+	rs := rs485ToKernel(cfg)
+	if rs.Flags != serRS485Enabled|serRS485RTSOnSend || rs.DelayRTSBeforeSend != 2 || rs.DelayRTSAfterSend != 0 {
+		t.Fatalf("rs485ToKernel = %+v", rs)
+	}
+	cfg.DelayBeforeSend = 2 * time.Millisecond
+	if got := rs485FromKernel(&rs); got != cfg {
+		t.Fatalf("rs485FromKernel = %+v, want %+v", got, cfg)
+	}
+
+	// A pty has no RS-485 mode.
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+	if err := sp.SetRS485Config(cfg); err != ErrUnsupported {
+		t.Fatalf("SetRS485Config on a pty = %v, want ErrUnsupported", err)
+	}
+}
+
+func TestSetBufferSizes(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if err := sp.SetBufferSizes(65536, 16384); err != nil {
+		t.Fatalf("SetBufferSizes: %v", err)
+	}
+	if err := sp.SetBufferSizes(0, 16384); err == nil {
+		t.Fatalf("SetBufferSizes(0, 16384) succeeded")
+	}
+}
+
+func TestTxFifoSizeUnsupported(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if n, err := sp.TxFifoSize(); err != ErrUnsupported {
+		t.Fatalf("TxFifoSize on a pty = %v, %v; want ErrUnsupported", n, err)
+	}
+	if err := sp.SetTxFifoSize(16); err != ErrUnsupported {
+		t.Fatalf("SetTxFifoSize on a pty = %v, want ErrUnsupported", err)
+	}
+	if err := sp.SetTxFifoSize(0); err == nil {
+		t.Fatalf("SetTxFifoSize(0) succeeded")
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "ttyUSB9")
+	_, err := Open(name, DefaultConfig())
+	if !errors.Is(err, ErrNotFound) || !errors.Is(err, os.ErrNotExist) || !strings.Contains(err.Error(), name) {
+		t.Fatalf("Open of a missing port = %v, want ErrNotFound naming it", err)
+	}
+	if errors.Is(err, ErrPortBusy) {
+		t.Fatalf("Open of a missing port matches ErrPortBusy")
+	}
+
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+	cfg := DefaultConfig()
+	cfg.DataBits = 9
+	_, err = Open(slave, cfg)
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) || cerr.Field != "DataBits" {
+		t.Fatalf("Open with 9 data bits = %v, want a ConfigError for DataBits", err)
+	}
+}
+
+func TestOpenContext(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if sp, err := OpenContext(ctx, slave, DefaultConfig()); sp != nil || err != context.Canceled {
+		t.Fatalf("OpenContext with a cancelled context = %v, %v; want nil, Canceled", sp, err)
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	sp, err := OpenContext(ctx, slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("OpenContext: %v", err)
+	}
+	defer sp.Close()
+	if _, err := sp.WriteString("ok"); err != nil {
+		t.Fatalf("WriteString: %v", err)
+	}
+}
+
+func TestReopen(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	// A symlink stands in for a by-id name that disappears while the adapter is unplugged.
+	name := filepath.Join(t.TempDir(), "ttyUSB")
+	if err := os.Symlink(slave, name); err != nil {
+		t.Fatalf("Symlink: %v", err)
+	}
+	cfg := DefaultConfig()
+	cfg.BaudRate = BR9600
+	sp, err := Open(name, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	os.Remove(name)
+	if err := sp.Reopen(); err == nil || !strings.Contains(err.Error(), name) {
+		t.Fatalf("Reopen of a missing device = %v, want an error naming it", err)
+	}
+	if _, err := sp.Write([]byte("x")); err == nil {
+		t.Fatalf("Write after a failed Reopen succeeded")
+	}
+	if n, err := sp.Read(make([]byte, 8)); n != 0 || err != io.EOF {
+		t.Fatalf("Read after a failed Reopen = %v, %v; want 0, EOF", n, err)
+	}
+	if sp.IsOpen() {
+		t.Fatalf("IsOpen after a failed Reopen = true")
+	}
+
+	// The fd number is kept, so that goroutines using it meanwhile never touch another file.
+	fd := sp.Fd()
+	stop := make(chan struct{})
+	probed := make(chan struct{})
+	go func() {
+		defer close(probed)
+		for {
+			select {
+			case <-stop:
+				return
+			default:
+				sp.IsOpen()
+			}
+		}
+	}()
+	os.Symlink(slave, name)
+	err = sp.Reopen()
+	close(stop)
+	<-probed
+	if err != nil {
+		t.Fatalf("Reopen: %v", err)
+	}
+	if sp.Fd() != fd {
+		t.Fatalf("Fd after Reopen = %v, want %v", sp.Fd(), fd)
+	}
+	if got, err := sp.Config(); err != nil || got.BaudRate != BR9600 {
+		t.Fatalf("Config after Reopen = %v, %v; want 9600 baud", got.BaudRate, err)
+	}
+	unix.Write(master, []byte("ok"))
+	buf := make([]byte, 8)
+	if n, err := sp.Read(buf); string(buf[:n]) != "ok" || err != nil {
+		t.Fatalf("Read after Reopen = %q, %v; want \"ok\"", buf[:n], err)
+	}
+}
+
+func TestOpenNoConfig(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.BaudRate = BR9600
+	cfg.StopBits = SB2
+	cfg.FlowControl = FlowSoftware
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+	before, err := unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
+	if err != nil {
+		t.Fatalf("TCGETS2: %v", err)
+	}
+
+	monitor, err := OpenNoConfig(slave)
+	if err != nil {
+		t.Fatalf("OpenNoConfig: %v", err)
+	}
+	defer monitor.Close()
+
+	after, err := unix.IoctlGetTermios(monitor.fd, unix.TCGETS2)
+	if err != nil {
+		t.Fatalf("TCGETS2: %v", err)
+	}
+	if *after != *before {
+		t.Fatalf("OpenNoConfig changed the termios:\n%+v\n%+v", before, after)
+	}
+	got, err := monitor.Config()
+	if err != nil {
+		t.Fatalf("Config: %v", err)
+	}
+	if got.BaudRate != BR9600 || got.StopBits != SB2 || got.FlowControl != FlowSoftware {
+		t.Fatalf("Config after OpenNoConfig = %+v, want the settings in place", got)
+	}
+}
+
+func TestInputWaiting(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if n, err := sp.InputWaiting(); n != 0 || err != nil {
+		t.Fatalf("InputWaiting = %v, %v; want 0", n, err)
+	}
+	unix.Write(master, []byte("line\nrest"))
+	time.Sleep(10 * time.Millisecond)
+	if n, err := sp.InputWaiting(); n != 9 || err != nil {
+		t.Fatalf("InputWaiting = %v, %v; want 9", n, err)
+	}
+
+	// Data read ahead by ReadUntil is still waiting to be read.
+	if _, err := sp.ReadUntil('\n'); err != nil {
+		t.Fatalf("ReadUntil: %v", err)
+	}
+	if n, err := sp.InputWaiting(); n != 4 || err != nil {
+		t.Fatalf("InputWaiting after ReadUntil = %v, %v; want 4", n, err)
+	}
+	if n, err := sp.OutputWaiting(); n != 0 || err != nil {
+		t.Fatalf("OutputWaiting = %v, %v; want 0", n, err)
+	}
+}
+
+func TestReadContext(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.Timeout = 0 // Read alone would block forever
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	buf := make([]byte, 8)
+	if n, err := sp.ReadContext(ctx, buf); n != 0 || err != context.DeadlineExceeded {
+		t.Fatalf("ReadContext = %v, %v; want 0, DeadlineExceeded", n, err)
+	}
+
+	// The port is still usable after a cancelled read.
+	unix.Write(master, []byte("ok"))
+	if n, err := sp.ReadContext(context.Background(), buf); string(buf[:n]) != "ok" || err != nil {
+		t.Fatalf("ReadContext after cancel = %q, %v; want \"ok\"", buf[:n], err)
+	}
+}
+
+func TestWriteContext(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	// Nobody reads the master, so the write blocks once the pty buffers are full.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	n, err := sp.WriteContext(ctx, make([]byte, 1<<20))
+	if err != context.DeadlineExceeded || n == 0 || n == 1<<20 {
+		t.Fatalf("WriteContext = %v, %v; want a partial write and DeadlineExceeded", n, err)
+	}
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	if n, err := sp.WriteContext(canceled, []byte("x")); n != 0 || err != context.Canceled {
+		t.Fatalf("WriteContext with a canceled context = %v, %v; want 0, Canceled", n, err)
+	}
+}
+
+func TestDeadlines(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.Timeout = 0
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	buf := make([]byte, 8)
+	sp.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	start := time.Now()
+	n, err := sp.Read(buf)
+	if n != 0 || err != ErrTimeout {
+		t.Fatalf("Read past the deadline = %v, %v; want 0, ErrTimeout", n, err)
+	}
+	if te, ok := err.(interface{ Timeout() bool }); !ok || !te.Timeout() {
+		t.Fatalf("Read error %v does not report Timeout()", err)
+	}
+	if d := time.Since(start); d < 20*time.Millisecond || d > time.Second {
+		t.Fatalf("Read returned after %v, want about 20 ms", d)
+	}
+
+	// A passed deadline fails at once, a cleared one no longer applies.
+	if n, err := sp.Read(buf); n != 0 || err != ErrTimeout {
+		t.Fatalf("Read after the deadline = %v, %v; want 0, ErrTimeout", n, err)
+	}
+	sp.SetReadDeadline(time.Time{})
+	unix.Write(master, []byte("ok"))
+	if n, err := sp.Read(buf); string(buf[:n]) != "ok" || err != nil {
+		t.Fatalf("Read without deadline = %q, %v; want \"ok\"", buf[:n], err)
+	}
+
+	// Nobody reads the master, so the write blocks once the pty buffers are full.
+	sp.SetDeadline(time.Now().Add(50 * time.Millisecond))
+	if n, err := sp.Write(make([]byte, 1<<20)); err != ErrTimeout || n == 0 || n == 1<<20 {
+		t.Fatalf("Write past the deadline = %v, %v; want a partial write and ErrTimeout", n, err)
+	}
+}
+
+func TestReadAfterClose(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.Timeout = 0 // the Read would block forever
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	base := runtime.NumGoroutine()
+	done := make(chan error, 1)
+	go func() {
+		_, err := sp.Read(make([]byte, 8))
+		done <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+	// A Read without a context waits on the wake pipe of the port, without a goroutine of its own.
+	if n := runtime.NumGoroutine(); n > base+1 {
+		t.Fatalf("%d goroutines running during a Read, want %d", n, base+1)
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	select {
+	case err := <-done:
+		if err != ErrClosed {
+			t.Fatalf("Read of a port closed mid-read = %v, want ErrClosed", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Read still blocked after Close")
+	}
+	if n, err := sp.Read(make([]byte, 8)); n != 0 || err != io.EOF {
+		t.Fatalf("Read after Close = %v, %v; want 0, EOF", n, err)
+	}
+}
+
+func TestCloseInterruptsExchanges(t *testing.T) {
+	for name, exchange := range map[string]func(sp *SerialPort) error{
+		"Query": func(sp *SerialPort) error {
+			_, err := sp.Query([]byte("?"), make([]byte, 8))
+			return err
+		},
+		"Transaction": func(sp *SerialPort) error {
+			_, err := sp.Transaction([]byte("?"), '\n', 0)
+			return err
+		},
+		"ReadExact": func(sp *SerialPort) error {
+			_, err := sp.ReadExact(4)
+			return err
+		},
+		"Resync": func(sp *SerialPort) error {
+			return sp.Resync(time.Hour, 2*time.Hour)
+		},
+	} {
+		master, slave := openPTY(t)
+		cfg := DefaultConfig()
+		cfg.Timeout = 0 // the reads would block forever
+		sp, err := Open(slave, cfg)
+		if err != nil {
+			unix.Close(master)
+			t.Fatalf("Open: %v", err)
+		}
+
+		done := make(chan error, 1)
+		go func() { done <- exchange(sp) }()
+		time.Sleep(20 * time.Millisecond)
+		// Keep the line busy so that Resync does not see it quiet.
+		unix.Write(master, []byte("x"))
+		if err := sp.Close(); err != nil {
+			t.Fatalf("%s: Close: %v", name, err)
+		}
+
+		select {
+		case err := <-done:
+			if err != ErrClosed {
+				t.Fatalf("%s of a port closed midway = %v, want ErrClosed", name, err)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s still blocked after Close", name)
+		}
+		if err := exchange(sp); err != ErrClosed {
+			t.Fatalf("%s after Close = %v, want ErrClosed", name, err)
+		}
+		unix.Close(master)
+	}
+}
+
+func TestReadTimeoutSemantics(t *testing.T) {
+	for _, timeout := range []time.Duration{0, 30 * time.Millisecond, 200 * time.Millisecond} {
+		master, slave := openPTY(t)
+		cfg := DefaultConfig()
+		cfg.Timeout = timeout
+		sp, err := Open(slave, cfg)
+		if err != nil {
+			unix.Close(master)
+			t.Fatalf("Open: %v", err)
+		}
+
+		// A read returns what is available without waiting to fill the buffer.
+		go func() {
+			time.Sleep(20 * time.Millisecond)
+			unix.Write(master, []byte("ab"))
+		}()
+		buf := make([]byte, 8)
+		if n, err := sp.Read(buf); string(buf[:n]) != "ab" || err != nil {
+			t.Errorf("Timeout %v: Read = %q, %v; want \"ab\"", timeout, buf[:n], err)
+		}
+
+		// Without data, a read with a timeout returns no data once it has elapsed.
+		if timeout > 0 {
+			start := time.Now()
+			n, err := sp.Read(buf)
+			if elapsed := time.Since(start); n != 0 || err != nil || elapsed < timeout || elapsed > timeout+100*time.Millisecond {
+				t.Errorf("Timeout %v: idle Read = %v, %v after %v; want 0, nil after the timeout", timeout, n, err, elapsed)
+			}
+		}
+
+		sp.Close()
+		unix.Close(master)
+	}
+}
+
+func TestVTIME(t *testing.T) {
+	for _, tt := range []struct {
+		timeout time.Duration
+		vtime   uint8
+		exact   bool
+	}{
+		{30 * time.Millisecond, 1, false},
+		{100 * time.Millisecond, 1, true},
+		{150 * time.Millisecond, 2, false},
+		{25500 * time.Millisecond, 255, true},
+		{time.Minute, 255, false},
+	} {
+		if got := vtime(tt.timeout); got != tt.vtime {
+			t.Errorf("vtime(%v) = %v, want %v", tt.timeout, got, tt.vtime)
+		}
+		if got := exactVTIME(tt.timeout); got != tt.exact {
+			t.Errorf("exactVTIME(%v) = %v, want %v", tt.timeout, got, tt.exact)
+		}
+	}
+}
+
+func TestFd(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	// A pty has no modem lines, so use the fd for plain I/O and a queue ioctl instead.
+	unix.Write(int(sp.Fd()), []byte("abc"))
+	if n, err := unix.IoctlGetInt(int(sp.Fd()), unix.TIOCINQ); err != nil || n != 0 {
+		t.Fatalf("TIOCINQ on Fd = %v, %v; want 0, nil", n, err)
+	}
+	buf := make([]byte, 8)
+	if n, err := unix.Read(master, buf); string(buf[:n]) != "abc" || err != nil {
+		t.Fatalf("master read = %q, %v; want \"abc\"", buf[:n], err)
+	}
+}
+
+func TestSampleFramingErrors(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	unix.Write(master, []byte("0123456789"))
+	framingErrs, bytesRx, err := sp.SampleFramingErrors(50 * time.Millisecond)
+	if err != nil {
+		t.Skipf("no error counters on a pty: %v", err)
+	}
+	if framingErrs != 0 || bytesRx != 10 {
+		t.Fatalf("SampleFramingErrors = %v, %v; want 0, 10", framingErrs, bytesRx)
+	}
+}
+
+func TestWriteFile(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	want := bytes.Repeat([]byte("firmware"), 1000)
+	path := filepath.Join(t.TempDir(), "fw.bin")
+	if err := os.WriteFile(path, want, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(chan []byte, 1)
+	go func() {
+		var b []byte
+		buf := make([]byte, 4096)
+		for len(b) < len(want) {
+			n, err := unix.Read(master, buf)
+			if err != nil {
+				break
+			}
+			b = append(b, buf[:n]...)
+		}
+		got <- b
+	}()
+
+	var calls int
+	var last int64
+	err = sp.WriteFile(path, 1024, func(written, total int64) {
+		calls++
+		if total != int64(len(want)) || written <= last {
+			t.Errorf("progress(%v, %v) after %v", written, total, last)
+		}
+		last = written
+	})
+	if err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+	if calls != 8 || last != int64(len(want)) {
+		t.Fatalf("progress called %v times up to %v, want 8 times up to %v", calls, last, len(want))
+	}
+	if b := <-got; !bytes.Equal(b, want) {
+		t.Fatalf("received %d bytes, differing from the file", len(b))
+	}
+
+	if err := sp.WriteFile(filepath.Join(t.TempDir(), "missing"), 0, nil); !os.IsNotExist(err) {
+		t.Fatalf("WriteFile of a missing file = %v, want a not-exist error", err)
+	}
+}
+
+func TestBaudRateErrorWithoutUART(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.BaudRate = 250000
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	// A pseudo-terminal has no baud_base, so the rate set in termios is trusted.
+	requested, actual, percentErr, err := sp.BaudRateError()
+	if err != nil {
+		t.Fatalf("BaudRateError: %v", err)
+	}
+	if requested != 250000 || actual != 250000 || percentErr != 0 {
+		t.Fatalf("BaudRateError = %v, %v, %v; want 250000, 250000, 0", requested, actual, percentErr)
+	}
+}
+
+func TestExpect(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	// Answer the login prompt from the other end.
+	go func() {
+		buf := make([]byte, 16)
+		n, _ := unix.Read(master, buf)
+		if string(buf[:n]) == "\r" {
+			unix.Write(master, []byte("\r\nlogin: root"))
+		}
+	}()
+
+	got, err := sp.Expect([]byte("\r"), regexp.MustCompile(`login: `), time.Second)
+	if string(got) != "\r\nlogin: " || err != nil {
+		t.Fatalf("Expect = %q, %v; want \"\\r\\nlogin: \", nil", got, err)
+	}
+
+	got, err = sp.Expect(nil, regexp.MustCompile(`Password:`), 100*time.Millisecond)
+	if string(got) != "root" || err != ErrTimeout {
+		t.Fatalf("Expect = %q, %v; want \"root\", ErrTimeout", got, err)
+	}
+}
+
+func TestApplyConfigDataBits(t *testing.T) {
+	// Start wide so that any CS8 bits left over would corrupt the narrower sizes.
+	termios := &unix.Termios{Cflag: unix.CS8}
+
+	for _, db := range []DataBits{DB5, DB7, DB6, DB8, DB5} {
+		cfg := DefaultConfig()
+		cfg.DataBits = db
+		applyConfig(termios, cfg)
+		if got := configFromTermios(termios); got.DataBits != db {
+			t.Fatalf("DataBits = %v after applying %v (Cflag %#o)", got.DataBits, db, termios.Cflag)
+		}
+	}
+}
+
+func TestOneAndHalfStopBits(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.DataBits = DB5
+	cfg.StopBits = SB1_5
+	if err := checkConfigParam(cfg); err != nil {
+		t.Fatalf("checkConfigParam(5 data bits, 1.5 stop bits): %v", err)
+	}
+	termios := &unix.Termios{}
+	applyConfig(termios, cfg)
+	if termios.Cflag&unix.CSTOPB == 0 {
+		t.Fatalf("CSTOPB not set for 1.5 stop bits")
+	}
+	if got := configFromTermios(termios); got.StopBits != SB1_5 {
+		t.Fatalf("StopBits = %v, want SB1_5", got.StopBits)
+	}
+
+	cfg.DataBits = DB8
+	if err := checkConfigParam(cfg); err == nil || !strings.Contains(err.Error(), "5 data bits") {
+		t.Fatalf("checkConfigParam(8 data bits, 1.5 stop bits) = %v, want an error about 5 data bits", err)
+	}
+}
+
+func TestApplyConfigFlowControl(t *testing.T) {
+	termios := &unix.Termios{}
+	for _, fc := range []int{FlowHardware, FlowSoftware, FlowNone, FlowSoftware, FlowHardware} {
+		cfg := DefaultConfig()
+		cfg.FlowControl = fc
+		applyConfig(termios, cfg)
+		if got := configFromTermios(termios); got.FlowControl != fc {
+			t.Fatalf("FlowControl = %v after applying %v (Cflag %#o, Iflag %#o)", got.FlowControl, fc, termios.Cflag, termios.Iflag)
+		}
+	}
+}
+
+func TestXonXoffChars(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	for _, chars := range [][2]byte{{0, 0}, {0x11, 0x14}, {0x01, 0}} {
+		cfg := DefaultConfig()
+		cfg.FlowControl = FlowSoftware
+		cfg.XonChar, cfg.XoffChar = chars[0], chars[1]
+		if err := sp.SetConfig(cfg); err != nil {
+			t.Fatalf("SetConfig: %v", err)
+		}
+		if got, err := sp.Config(); !got.Equal(cfg) || err != nil {
+			t.Fatalf("Config = %+v, %v; want %+v", got, err, cfg)
+		}
+	}
+
+	cfg := DefaultConfig()
+	cfg.XonChar = 0x13
+	if err := sp.SetConfig(cfg); err == nil {
+		t.Fatalf("SetConfig with XonChar equal to XoffChar succeeded")
+	}
+}
+
+func TestApplyConfigParity(t *testing.T) {
+	termios := &unix.Termios{}
+	for _, p := range []Parity{PM, PO, PS, PE, PN, PS, PM} {
+		cfg := DefaultConfig()
+		cfg.Parity = p
+		applyConfig(termios, cfg)
+		if got := configFromTermios(termios); got.Parity != p {
+			t.Fatalf("Parity = %v after applying %v (Cflag %#o)", got.Parity, p, termios.Cflag)
+		}
+	}
+}
+
+func TestSetParityBit(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if err := sp.SetParityBit(true); err == nil {
+		t.Fatalf("SetParityBit without mark or space parity succeeded")
+	}
+
+	cfg := DefaultConfig()
+	cfg.Parity = PS
+	if err := sp.SetConfig(cfg); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+	if got, _ := sp.Config(); got.Parity != PS {
+		t.Skipf("pseudo-terminal does not keep mark/space parity, got %v", got.Parity)
+	}
+	for _, mark := range []bool{true, false, true} {
+		if err := sp.SetParityBit(mark); err != nil {
+			t.Fatalf("SetParityBit(%v): %v", mark, err)
+		}
+		want := PS
+		if mark {
+			want = PM
+		}
+		if got, err := sp.Config(); got.Parity != want || err != nil {
+			t.Fatalf("Config after SetParityBit(%v) = %v, %v; want parity %v", mark, got.Parity, err, want)
+		}
+	}
+}
+
+func TestWatchModemLinesUnsupported(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	// Pseudo-terminals have no modem lines, so there is nothing to watch.
+	ch, err := sp.WatchModemLines(context.Background())
+	if err == nil || ch != nil {
+		t.Fatalf("WatchModemLines on a pty = %v, %v; want an error", ch, err)
+	}
+}
+
+func TestSelfTestPartialEcho(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.BaudRate = BR115200
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	done := make(chan SelfTestResult, 1)
+	go func() {
+		res, err := sp.SelfTest()
+		if err != nil {
+			t.Errorf("SelfTest: %v", err)
+		}
+		done <- res
+	}()
+
+	// Echo only the start of the pattern: SelfTest must give up at its deadline.
+	unix.Write(master, []byte(readMaster(t, master, 16)))
+	select {
+	case res := <-done:
+		if res.Sent != 256 || res.Received != 16 || res.Corrupted != 0 || res.Passed || res.ModemLines {
+			t.Fatalf("SelfTest = %+v, want 16 of 256 bytes echoed, no modem lines", res)
+		}
+	case <-time.After(3 * time.Second):
+		t.Fatalf("SelfTest still running after its deadline")
+	}
+}
+
+func TestWatchModemLinesClose(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	// A pty has no modem lines: fake steady ones.
+	saved := getModemBits
+	getModemBits = func(int) (int, error) { return unix.TIOCM_CTS, nil }
+	defer func() { getModemBits = saved }()
+
+	base := runtime.NumGoroutine()
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	ch, err := sp.WatchModemLines(context.Background())
+	if err != nil {
+		t.Fatalf("WatchModemLines: %v", err)
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// Close waits for the watcher, which closes the channel on its way out.
+	select {
+	case _, ok := <-ch:
+		if ok {
+			t.Fatalf("WatchModemLines sent a change of steady lines")
+		}
+	default:
+		t.Fatalf("WatchModemLines channel still open after Close")
+	}
+	// The watcher may still be on its way out after release.
+	deadline := time.Now().Add(time.Second)
+	for runtime.NumGoroutine() > base {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines running after Close, want %d", runtime.NumGoroutine(), base)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestWaitForModemChange(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+
+	// Pseudo-terminals have no modem lines, so there is nothing to wait for.
+	if _, err := sp.WaitForModemChange(context.Background()); err == nil {
+		t.Fatalf("WaitForModemChange on a pty succeeded")
+	}
+
+	sp.Close()
+	if _, err := sp.WaitForModemChange(context.Background()); err != ErrClosed {
+		t.Fatalf("WaitForModemChange after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestPacketPort(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.Timeout = 200 * time.Millisecond
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	pp := NewPacketPort(sp, LengthPrefixFraming())
+
+	// Two messages and the start of a third arrive in a single chunk.
+	unix.Write(master, []byte("\x00\x02hi\x00\x03abc\x00\x05xy"))
+	for _, want := range []string{"hi", "abc"} {
+		msg, err := pp.ReadMessage()
+		if string(msg) != want || err != nil {
+			t.Fatalf("ReadMessage = %q, %v; want %q, nil", msg, err, want)
+		}
+	}
+	if msg, err := pp.ReadMessage(); err != ErrTimeout {
+		t.Fatalf("ReadMessage of a partial message = %q, %v; want ErrTimeout", msg, err)
+	}
+	unix.Write(master, []byte("zzz"))
+	if msg, err := pp.ReadMessage(); string(msg) != "xyzzz" || err != nil {
+		t.Fatalf("ReadMessage = %q, %v; want \"xyzzz\", nil", msg, err)
+	}
+
+	if err := pp.WriteMessage([]byte("ok")); err != nil {
+		t.Fatalf("WriteMessage: %v", err)
+	}
+	buf := make([]byte, 16)
+	if n, _ := unix.Read(master, buf); string(buf[:n]) != "\x00\x02ok" {
+		t.Fatalf("wire data = %q, want \"\\x00\\x02ok\"", buf[:n])
+	}
+}
+
+func TestCookedMode(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if err := sp.SetCookedMode(); err != nil {
+		t.Fatalf("SetCookedMode: %v", err)
+	}
+	// The mode survives reconfiguration.
+	if err := sp.SetConfig(DefaultConfig()); err != nil {
+		t.Fatalf("SetConfig: %v", err)
+	}
+
+	unix.Write(master, []byte("AT\r"))
+	buf := make([]byte, 16)
+	if n, err := sp.Read(buf); string(buf[:n]) != "AT\n" || err != nil {
+		t.Fatalf("cooked Read = %q, %v; want \"AT\\n\", nil", buf[:n], err)
+	}
+	if n, _ := unix.Read(master, buf); string(buf[:n]) != "AT\r\n" {
+		t.Fatalf("echo = %q, want \"AT\\r\\n\"", buf[:n])
+	}
+
+	sp.Write([]byte("OK\n"))
+	if n, _ := unix.Read(master, buf); string(buf[:n]) != "OK\r\n" {
+		t.Fatalf("cooked output = %q, want \"OK\\r\\n\"", buf[:n])
+	}
+
+	if err := sp.SetRawMode(); err != nil {
+		t.Fatalf("SetRawMode: %v", err)
+	}
+	sp.Write([]byte("OK\n"))
+	if n, _ := unix.Read(master, buf); string(buf[:n]) != "OK\n" {
+		t.Fatalf("raw output = %q, want \"OK\\n\"", buf[:n])
+	}
+}
+
+func TestReadBatch(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if b, err := sp.ReadBatch(8, 50*time.Millisecond); len(b) != 0 || err != ErrTimeout {
+		t.Fatalf("ReadBatch on an idle line = %q, %v; want none, ErrTimeout", b, err)
+	}
+
+	unix.Write(master, []byte("0123456789"))
+	time.Sleep(10 * time.Millisecond)
+	if b, err := sp.ReadBatch(8, time.Second); string(b) != "01234567" || err != nil {
+		t.Fatalf("ReadBatch = %q, %v; want \"01234567\", nil", b, err)
+	}
+	start := time.Now()
+	if b, err := sp.ReadBatch(8, time.Second); string(b) != "89" || err != nil {
+		t.Fatalf("ReadBatch = %q, %v; want \"89\", nil", b, err)
+	}
+	if d := time.Since(start); d > 500*time.Millisecond {
+		t.Fatalf("ReadBatch waited %v after the available data", d)
+	}
+}
+
+// fakeSysfs points sysClassTTY at a fake sysfs tree for the duration of the test, with
+// an FTDI adapter (ttyUSB0, a usb-serial port below a USB interface), a platform UART (ttyS0),
+// a UART without hardware (ttyS1), a virtual terminal (tty0) and a tty0tty null-modem port (tnt0),
+// with their device nodes in a fake /dev.
+func fakeSysfs(t *testing.T) {
+	t.Helper()
+
+	root := t.TempDir()
+	usbDev := filepath.Join(root, "devices/pci0000:00/0000:00:14.0/usb1/1-1")
+	port := filepath.Join(usbDev, "1-1:1.0/ttyUSB0")
+	uart := filepath.Join(root, "devices/platform/serial8250")
+	for _, link := range []struct{ dir, name, target string }{
+		{usbDev, "subsystem", filepath.Join(root, "bus/usb")},
+		{port, "subsystem", filepath.Join(root, "bus/usb-serial")},
+		{port, "driver", filepath.Join(root, "bus/usb-serial/drivers/ftdi_sio")},
+		{uart, "subsystem", filepath.Join(root, "bus/platform")},
+		{uart, "driver", filepath.Join(root, "bus/platform/drivers/serial8250")},
+		{filepath.Join(root, "class/tty/ttyUSB0"), "device", port},
+		{filepath.Join(root, "class/tty/ttyS0"), "device", uart},
+		{filepath.Join(root, "class/tty/ttyS1"), "device", uart},
+	} {
+		if err := os.MkdirAll(link.dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(link.target, filepath.Join(link.dir, link.name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for file, content := range map[string]string{
+		"idVendor":     "0403\n",
+		"idProduct":    "6001\n",
+		"manufacturer": "FTDI\n",
+		"product":      "FT232R USB UART\n",
+		"serial":       "A50285BI\n",
+	} {
+		if err := os.WriteFile(filepath.Join(usbDev, file), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, tty := range []string{"tty0", "tnt0"} {
+		if err := os.MkdirAll(filepath.Join(root, "class/tty", tty), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// PORT_16550A and PORT_UNKNOWN.
+	for tty, typ := range map[string]string{"ttyS0": "4\n", "ttyS1": "0\n"} {
+		if err := os.WriteFile(filepath.Join(root, "class/tty", tty, "type"), []byte(typ), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dev := filepath.Join(root, "dev")
+	if err := os.MkdirAll(dev, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, tty := range []string{"ttyUSB0", "ttyS0", "ttyS1", "tty0", "tnt0"} {
+		if err := os.WriteFile(filepath.Join(dev, tty), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	savedTTY, savedDev := sysClassTTY, devDir
+	sysClassTTY, devDir = filepath.Join(root, "class/tty"), dev
+	t.Cleanup(func() { sysClassTTY, devDir = savedTTY, savedDev })
+}
+
+func TestDriverNameAndBusType(t *testing.T) {
+	fakeSysfs(t)
+
+	sp := &SerialPort{fd: -1, name: "/dev/ttyUSB0"}
+	if driver, err := sp.DriverName(); driver != "ftdi_sio" || err != nil {
+		t.Fatalf("DriverName = %q, %v; want \"ftdi_sio\", nil", driver, err)
+	}
+	if bus, err := sp.BusType(); bus != BusUSB || err != nil {
+		t.Fatalf("BusType = %q, %v; want %q, nil", bus, err, BusUSB)
+	}
+
+	sp.name = "/dev/ttyS9"
+	if _, err := sp.DriverName(); err == nil {
+		t.Fatalf("DriverName of a port without a device succeeded")
+	}
+}
+
+func TestEnsureConfig(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.Timeout = 150 * time.Millisecond // not a whole number of VTIME deciseconds
+	cfg.RetryEmptyReads = true
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if changed, err := sp.EnsureConfig(cfg); changed || err != nil {
+		t.Fatalf("EnsureConfig(same) = %v, %v; want false, nil", changed, err)
+	}
+
+	cfg.BaudRate = BR9600
+	if changed, err := sp.EnsureConfig(cfg); !changed || err != nil {
+		t.Fatalf("EnsureConfig(new baud rate) = %v, %v; want true, nil", changed, err)
+	}
+	if got, _ := sp.Config(); !got.Equal(cfg) {
+		t.Fatalf("Config() = %+v, want %+v", got, cfg)
+	}
+	if changed, err := sp.EnsureConfig(cfg); changed || err != nil {
+		t.Fatalf("EnsureConfig(same) = %v, %v; want false, nil", changed, err)
+	}
+}
+
+func TestCaseMapping(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	cfg := DefaultConfig()
+	cfg.LowercaseInput = true
+	cfg.UppercaseOutput = true
+	sp, err := Open(slave, cfg)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if got, _ := sp.Config(); !got.LowercaseInput || !got.UppercaseOutput {
+		t.Fatalf("Config() = %+v, want case mapping enabled", got)
+	}
+
+	unix.Write(master, []byte("HELLO\n"))
+	buf := make([]byte, 16)
+	if n, err := sp.Read(buf); string(buf[:n]) != "hello\n" || err != nil {
+		t.Fatalf("Read = %q, %v; want \"hello\\n\", nil", buf[:n], err)
+	}
+
+	sp.Write([]byte("ok\n"))
+	if n, _ := unix.Read(master, buf); string(buf[:n]) != "OK\n" {
+		t.Fatalf("output = %q, want \"OK\\n\"", buf[:n])
+	}
+}
+
+func TestBufferedWriter(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	bw := NewBufferedWriter(sp, 4, 50*time.Millisecond)
+	readMaster := func() string {
+		fds := []unix.PollFd{{Fd: int32(master), Events: unix.POLLIN}}
+		if n, _ := unix.Poll(fds, 10); n == 0 {
+			return ""
+		}
+		buf := make([]byte, 16)
+		n, _ := unix.Read(master, buf)
+		return string(buf[:n])
+	}
+
+	bw.Write([]byte("a"))
+	bw.Write([]byte("b"))
+	if got := readMaster(); got != "" {
+		t.Fatalf("unflushed data sent: %q", got)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if got := readMaster(); got != "ab" {
+		t.Fatalf("after flushEvery got %q, want \"ab\"", got)
+	}
+
+	bw.Write([]byte("cd"))
+	bw.Write([]byte("efg")) // fills the buffer once
+	if got := readMaster(); got != "cdef" {
+		t.Fatalf("after a full buffer got %q, want \"cdef\"", got)
+	}
+	if err := bw.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if got := readMaster(); got != "g" {
+		t.Fatalf("after Close got %q, want \"g\"", got)
+	}
+	if _, err := bw.Write([]byte("h")); err == nil {
+		t.Fatalf("Write after Close succeeded")
+	}
+}
+
+func TestProbe(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	// The device only answers the second probe.
+	go func() {
+		buf := make([]byte, 16)
+		for {
+			n, err := unix.Read(master, buf)
+			if err != nil {
+				return
+			}
+			if string(buf[:n]) == "ATI\r" {
+				unix.Write(master, []byte("Quectel\r\nOK\r\n"))
+			}
+		}
+	}()
+
+	isOK := func(b []byte) bool { return bytes.Contains(b, []byte("OK\r\n")) }
+	found, err := sp.Probe([][]byte{[]byte("?\r"), []byte("ATI\r")}, isOK, 100*time.Millisecond)
+	if !found || err != nil {
+		t.Fatalf("Probe = %v, %v; want true, nil", found, err)
+	}
+
+	found, err = sp.Probe([][]byte{[]byte("?\r")}, isOK, 50*time.Millisecond)
+	if found || err != nil {
+		t.Fatalf("Probe without an answer = %v, %v; want false, nil", found, err)
+	}
+}
+
+func TestConsolePort(t *testing.T) {
+	root := t.TempDir()
+	savedTTY, savedConsoles := sysClassTTY, procConsoles
+	sysClassTTY = filepath.Join(root, "class/tty")
+	procConsoles = filepath.Join(root, "consoles")
+	defer func() { sysClassTTY, procConsoles = savedTTY, savedConsoles }()
+
+	// Without /sys/class/tty/console/active, /proc/consoles is used.
+	consoles := "tty0                 -WU (E  p  )    4:1\nttyAMA0              -W- (EC p a)  204:64\n"
+	if err := os.WriteFile(procConsoles, []byte(consoles), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := ConsolePort(); name != "/dev/ttyAMA0" || err != nil {
+		t.Fatalf("ConsolePort from /proc/consoles = %q, %v; want \"/dev/ttyAMA0\", nil", name, err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(sysClassTTY, "console"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(sysClassTTY, "console/active"), []byte("ttyS0 tty0\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := ConsolePort(); name != "/dev/ttyS0" || err != nil {
+		t.Fatalf("ConsolePort = %q, %v; want \"/dev/ttyS0\", nil", name, err)
+	}
+}
+
+func TestListPorts(t *testing.T) {
+	fakeSysfs(t)
+
+	ports, err := ListPorts()
+	if err != nil {
+		t.Fatalf("ListPorts: %v", err)
+	}
+	want := []PortInfo{
+		{Name: "/dev/ttyS0"},
+		{Name: "/dev/ttyUSB0", IsUSB: true, VID: 0x0403, PID: 0x6001,
+			Manufacturer: "FTDI", Product: "FT232R USB UART", SerialNumber: "A50285BI"},
+	}
+	if !reflect.DeepEqual(ports, want) {
+		t.Fatalf("ListPorts = %+v, want %+v", ports, want)
+	}
+
+	if name, err := FindBySerial("A50285BI"); name != "/dev/ttyUSB0" || err != nil {
+		t.Fatalf("FindBySerial = %q, %v; want \"/dev/ttyUSB0\", nil", name, err)
+	}
+	if _, err := FindBySerial("nope"); err == nil {
+		t.Fatalf("FindBySerial of an unknown serial number succeeded")
+	}
+
+	// A tty without a device node cannot be opened.
+	if err := os.Remove(filepath.Join(devDir, "ttyUSB0")); err != nil {
+		t.Fatal(err)
+	}
+	if ports, err := ListPorts(); len(ports) != 1 || ports[0].Name != "/dev/ttyS0" || err != nil {
+		t.Fatalf("ListPorts without /dev/ttyUSB0 = %+v, %v; want only /dev/ttyS0", ports, err)
+	}
+}
+
+func TestListPortsWithOptions(t *testing.T) {
+	fakeSysfs(t)
+
+	pts := t.TempDir()
+	for _, name := range []string{"0", "3", "ptmx"} {
+		if err := os.WriteFile(filepath.Join(pts, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	saved := devPts
+	devPts = pts
+	defer func() { devPts = saved }()
+
+	ports, err := ListPortsWithOptions(ListPortsOptions{IncludeVirtual: true, IncludePTY: true})
+	if err != nil {
+		t.Fatalf("ListPortsWithOptions: %v", err)
+	}
+	var names []string
+	for _, p := range ports {
+		names = append(names, p.Name)
+		if p.IsVirtual != (p.Name == "/dev/tnt0" || strings.HasPrefix(p.Name, pts)) {
+			t.Errorf("%v: IsVirtual = %v", p.Name, p.IsVirtual)
+		}
+	}
+	want := []string{"/dev/tnt0", "/dev/ttyS0", "/dev/ttyUSB0", filepath.Join(pts, "0"), filepath.Join(pts, "3")}
+	sort.Strings(want)
+	if !reflect.DeepEqual(names, want) {
+		t.Fatalf("ListPortsWithOptions = %q, want %q", names, want)
+	}
+}
+
+func TestWithBaud(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	errFn := fmt.Errorf("upload failed")
+	err = sp.WithBaud(921600, func() error {
+		if cfg, _ := sp.Config(); cfg.BaudRate != 921600 {
+			t.Errorf("BaudRate inside WithBaud = %v, want 921600", cfg.BaudRate)
+		}
+		return errFn
+	})
+	if err != errFn {
+		t.Fatalf("WithBaud = %v, want %v", err, errFn)
+	}
+	if cfg, _ := sp.Config(); cfg.BaudRate != BR115200 {
+		t.Fatalf("BaudRate after WithBaud = %v, want %v", cfg.BaudRate, BR115200)
+	}
+
+	func() {
+		defer func() { recover() }()
+		sp.WithBaud(921600, func() error { panic("boom") })
+	}()
+	if cfg, _ := sp.Config(); cfg.BaudRate != BR115200 {
+		t.Fatalf("BaudRate after a panic = %v, want %v", cfg.BaudRate, BR115200)
+	}
+}
+
+func TestTimestampedReader(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	tr := NewTimestampedReader(sp, 0)
+	go func() {
+		unix.Write(master, []byte("a"))
+		time.Sleep(50 * time.Millisecond)
+		unix.Write(master, []byte("b"))
+	}()
+
+	first, t1, err := tr.ReadTimestamped()
+	if string(first) != "a" || err != nil {
+		t.Fatalf("ReadTimestamped = %q, %v; want \"a\", nil", first, err)
+	}
+	second, t2, err := tr.ReadTimestamped()
+	if string(second) != "b" || err != nil {
+		t.Fatalf("ReadTimestamped = %q, %v; want \"b\", nil", second, err)
+	}
+	if gap := t2.Sub(t1); gap < 40*time.Millisecond || gap > 500*time.Millisecond {
+		t.Fatalf("gap = %v, want about 50ms", gap)
+	}
+
+	if b, _, err := tr.ReadTimestamped(); b != nil || err != ErrTimeout {
+		t.Fatalf("ReadTimestamped on an idle line = %q, %v; want nil, ErrTimeout", b, err)
+	}
+}
+
+func TestComputeReadParams(t *testing.T) {
+	for _, tt := range []struct {
+		latency     time.Duration
+		batch       int
+		vmin, vtime uint8
+	}{
+		{250 * time.Millisecond, 64, 64, 3},
+		{time.Minute, 1000, 255, 255},
+		{50 * time.Millisecond, 64, 1, 0}, // below VTIME resolution
+		{time.Second, 1, 1, 0},
+	} {
+		vmin, vtime := ComputeReadParams(tt.latency, tt.batch)
+		if vmin != tt.vmin || vtime != tt.vtime {
+			t.Errorf("ComputeReadParams(%v, %v) = %v, %v; want %v, %v", tt.latency, tt.batch, vmin, vtime, tt.vmin, tt.vtime)
+		}
+	}
+}
+
+func TestSetReadParams(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer sp.Close()
+
+	if err := sp.SetReadParams(ComputeReadParams(200*time.Millisecond, 4)); err != nil {
+		t.Fatalf("SetReadParams: %v", err)
+	}
+	termios, err := unix.IoctlGetTermios(sp.fd, unix.TCGETS2)
+	if err != nil {
+		t.Fatalf("TCGETS2: %v", err)
+	}
+	if termios.Cc[unix.VMIN] != 4 || termios.Cc[unix.VTIME] != 2 {
+		t.Fatalf("VMIN, VTIME = %v, %v; want 4, 2", termios.Cc[unix.VMIN], termios.Cc[unix.VTIME])
+	}
+
+	// More than VMIN bytes already received are returned at once.
+	unix.Write(master, []byte("0123456"))
+	buf := make([]byte, 16)
+	if n, err := sp.Read(buf); string(buf[:n]) != "0123456" || err != nil {
+		t.Fatalf("Read = %q, %v; want \"0123456\", nil", buf[:n], err)
+	}
+}
+
+func TestWriteAfterClose(t *testing.T) {
+	master, slave := openPTY(t)
+	defer unix.Close(master)
+
+	sp, err := Open(slave, DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	if err := sp.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if n, err := sp.Write([]byte("x")); n != 0 || err != ErrClosed {
+		t.Fatalf("Write after Close = %d, %v; want 0, ErrClosed", n, err)
+	}
+	if n, err := sp.WriteContext(context.Background(), []byte("x")); n != 0 || err != ErrClosed {
+		t.Fatalf("WriteContext after Close = %d, %v; want 0, ErrClosed", n, err)
 	}
 }
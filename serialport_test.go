@@ -0,0 +1,244 @@
+package serialport
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestRetryEmptyReads(t *testing.T) {
+	// A driver that spuriously returns no data a few times before the data arrives.
+	calls := 0
+	read := func(b []byte) (int, error) {
+		calls++
+		if calls < 4 {
+			return 0, nil
+		}
+		return copy(b, "OK"), nil
+	}
+
+	buf := make([]byte, 8)
+	n, err := retryEmptyReads(read, buf, time.Second)
+	if string(buf[:n]) != "OK" || err != nil {
+		t.Fatalf("retryEmptyReads = %q, %v; want \"OK\", nil", buf[:n], err)
+	}
+	if calls != 4 {
+		t.Fatalf("read called %v times, want 4", calls)
+	}
+}
+
+func TestRetryEmptyReadsTimeout(t *testing.T) {
+	// A genuinely idle line still returns once the timeout has elapsed.
+	calls := 0
+	read := func(b []byte) (int, error) {
+		calls++
+		time.Sleep(10 * time.Millisecond)
+		return 0, nil
+	}
+
+	start := time.Now()
+	n, err := retryEmptyReads(read, make([]byte, 8), 50*time.Millisecond)
+	if n != 0 || err != nil {
+		t.Fatalf("retryEmptyReads = %v, %v; want 0, nil", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond || elapsed > time.Second {
+		t.Fatalf("retryEmptyReads returned after %v, want about 50ms", elapsed)
+	}
+	if calls < 2 {
+		t.Fatalf("read called %v times, want retries", calls)
+	}
+}
+
+func TestFlowControlStalled(t *testing.T) {
+	for _, tt := range []struct {
+		before, written, after int
+		want                   bool
+	}{
+		{4096, 0, 4096, true}, // queue full and not moving
+		{0, 10, 10, true},     // queued but none sent
+		{4096, 0, 4000, false},
+		{0, 10, 4, false},
+		{0, 0, 0, false}, // nothing queued, e.g. pseudo-terminals
+	} {
+		if got := flowControlStalled(tt.before, tt.written, tt.after); got != tt.want {
+			t.Errorf("flowControlStalled(%v, %v, %v) = %v, want %v", tt.before, tt.written, tt.after, got, tt.want)
+		}
+	}
+}
+
+func TestErrorValues(t *testing.T) {
+	if !errors.Is(ErrFlowControlStall, ErrTimeout) {
+		t.Errorf("ErrFlowControlStall does not match ErrTimeout")
+	}
+	err := configErrorf("StopBits", "invalid Config.StopBits %v", 3)
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) || cerr.Field != "StopBits" || err.Error() != "serialport: invalid Config.StopBits 3" {
+		t.Errorf("configErrorf = %#v", err)
+	}
+}
+
+func TestDefaultErrorFilter(t *testing.T) {
+	other := errors.New("other")
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{syscall.EAGAIN, nil},
+		{os.NewSyscallError("read", syscall.EAGAIN), nil},
+		{syscall.EINTR, ErrRetry},
+		{ErrTimeout, ErrTimeout},
+		{other, other},
+	}
+	for _, test := range tests {
+		if got := DefaultErrorFilter(test.err); got != test.want {
+			t.Errorf("DefaultErrorFilter(%v) = %v, want %v", test.err, got, test.want)
+		}
+	}
+}
+
+func TestCheckSevenBit(t *testing.T) {
+	if err := checkSevenBit([]byte("AT+CSQ\r\n")); err != nil {
+		t.Fatalf("checkSevenBit(ASCII) = %v, want nil", err)
+	}
+	if err := checkSevenBit([]byte("caf\xc3\xa9")); err == nil {
+		t.Fatalf("checkSevenBit(UTF-8) = nil, want an error")
+	}
+}
+
+func TestMatchPorts(t *testing.T) {
+	names := []string{"/dev/ttyACM0", "/dev/ttyS0", "/dev/ttyUSB0", "/dev/ttyUSB1", "COM3"}
+	tests := []struct {
+		pattern string
+		want    []string
+	}{
+		{"ttyUSB*", []string{"/dev/ttyUSB0", "/dev/ttyUSB1"}},
+		{"/dev/ttyS?", []string{"/dev/ttyS0"}},
+		{"usbserial|ttyACM", []string{"/dev/ttyACM0"}},
+		{"^COM[0-9]+$", []string{"COM3"}},
+		{"cu.usbserial-*", nil},
+	}
+	for _, test := range tests {
+		got, err := matchPorts(names, test.pattern)
+		if err != nil || !reflect.DeepEqual(got, test.want) {
+			t.Errorf("matchPorts(%q) = %q, %v; want %q, nil", test.pattern, got, err, test.want)
+		}
+	}
+
+	if _, err := matchPorts(names, "[ttyUSB"); err == nil {
+		t.Errorf("matchPorts with an invalid pattern succeeded")
+	}
+}
+
+func TestModemBits(t *testing.T) {
+	m := ModemCTS | ModemDCD
+	if !m.CTS() || m.DSR() || m.RI() || !m.DCD() {
+		t.Fatalf("accessors of %v disagree with the bits", m)
+	}
+	if s := m.String(); s != "CTS|DCD" {
+		t.Fatalf("String = %q, want \"CTS|DCD\"", s)
+	}
+	if s := ModemBits(0).String(); s != "none" {
+		t.Fatalf("String = %q, want \"none\"", s)
+	}
+}
+
+func TestCRC16XMODEM(t *testing.T) {
+	if crc := crc16XMODEM([]byte("123456789")); crc != 0x31c3 {
+		t.Fatalf("crc16XMODEM = %#04x, want 0x31c3", crc)
+	}
+	if sum := checksum8([]byte{0x80, 0x90, 0x01}); sum != 0x11 {
+		t.Fatalf("checksum8 = %#02x, want 0x11", sum)
+	}
+}
+
+func TestParseYMODEMHeader(t *testing.T) {
+	for _, tc := range []struct {
+		header string
+		name   string
+		size   int64
+	}{
+		{"fw.bin\x001234 13706712044 100644\x00\x00", "fw.bin", 1234},
+		{"fw.bin\x00\x00", "fw.bin", -1},
+		{"\x00\x00\x00", "", -1},
+	} {
+		name, size := parseYMODEMHeader([]byte(tc.header))
+		if name != tc.name || size != tc.size {
+			t.Errorf("parseYMODEMHeader(%q) = %q, %v; want %q, %v", tc.header, name, size, tc.name, tc.size)
+		}
+	}
+}
+
+func TestConfigSummary(t *testing.T) {
+	cfg := DefaultConfig()
+	if s := cfg.Summary(); s != "115200 8N1 timeout=100ms" {
+		t.Fatalf("Summary() = %q", s)
+	}
+
+	cfg = Config{BaudRate: BR9600, DataBits: DB7, StopBits: SB1_5, Parity: PE, FlowControl: FlowHardware, WriteTimeout: time.Second}
+	if s := cfg.Summary(); s != "9600 7E1.5 rtscts write-timeout=1s" {
+		t.Fatalf("Summary() = %q", s)
+	}
+}
+
+func TestStringers(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.StopBits = SB1_5
+	cfg.Parity = PE
+	if s := fmt.Sprintf("%v %v %v", cfg.DataBits, cfg.StopBits, cfg.Parity); s != "8 1.5 even" {
+		t.Errorf("Sprintf = %q", s)
+	}
+	if s := fmt.Sprint(StopBits(3), Parity(7)); s != "StopBits(3) Parity(7)" {
+		t.Errorf("invalid values print as %q", s)
+	}
+}
+
+func TestParseStopBits(t *testing.T) {
+	for in, want := range map[string]StopBits{"1": SB1, "1.5": SB1_5, " 2 ": SB2} {
+		if sb, err := ParseStopBits(in); sb != want || err != nil {
+			t.Errorf("ParseStopBits(%q) = %v, %v; want %v", in, sb, err, want)
+		}
+	}
+	for _, in := range []string{"", "3", "15", "1,5"} {
+		if _, err := ParseStopBits(in); err == nil {
+			t.Errorf("ParseStopBits(%q) succeeded", in)
+		}
+	}
+}
+
+func TestPauseBackground(t *testing.T) {
+	var sp SerialPort
+	if !sp.pauseBackground() {
+		t.Fatalf("pauseBackground failed on an open serial port")
+	}
+	acquired := make(chan bool, 1)
+	go func() { acquired <- sp.acquire() }()
+	select {
+	case <-acquired:
+		t.Fatalf("acquire returned while paused")
+	case <-time.After(50 * time.Millisecond):
+	}
+	sp.resumeBackground()
+	select {
+	case ok := <-acquired:
+		if !ok {
+			t.Fatalf("acquire failed after resumeBackground")
+		}
+		sp.release()
+	case <-time.After(time.Second):
+		t.Fatalf("acquire still waiting after resumeBackground")
+	}
+}
+
+func TestReadNegativeMax(t *testing.T) {
+	var sp SerialPort
+	if b, err := sp.ReadUpTo(-1, time.Millisecond); b != nil || err == nil {
+		t.Fatalf("ReadUpTo(-1) = %q, %v; want an error", b, err)
+	}
+	if b, err := sp.ReadBatch(-1, time.Millisecond); b != nil || err == nil {
+		t.Fatalf("ReadBatch(-1) = %q, %v; want an error", b, err)
+	}
+}
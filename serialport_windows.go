@@ -1,8 +1,11 @@
 package serialport
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"math"
+	"sync"
 	"syscall"
 	"time"
 	"unsafe"
@@ -67,6 +70,42 @@ const (
 	win32TWOSTOPBITS  = 2
 )
 
+// DCB bit fields
+const (
+	win32fOutxCtsFlow          = 0x00000004
+	win32DTR_CONTROL_ENABLE    = 0x00000010
+	win32DTR_CONTROL_MASK      = 0x00000030
+	win32fOutX                 = 0x00000100
+	win32fInX                  = 0x00000200
+	win32RTS_CONTROL_ENABLE    = 0x00001000
+	win32RTS_CONTROL_HANDSHAKE = 0x00002000
+	win32RTS_CONTROL_MASK      = 0x00003000
+)
+
+// Input buffer levels at which XON and XOFF are sent
+const (
+	win32XonLim  = 2048
+	win32XoffLim = 512
+)
+
+// EscapeCommFunction functions
+const (
+	win32SETRTS = 3
+	win32CLRRTS = 4
+	win32SETDTR = 5
+	win32CLRDTR = 6
+)
+
+// ClearCommError errors
+const (
+	win32CE_RXOVER   = 0x0001
+	win32CE_OVERRUN  = 0x0002
+	win32CE_RXPARITY = 0x0004
+	win32CE_FRAME    = 0x0008
+	win32CE_BREAK    = 0x0010
+	win32CE_TXFULL   = 0x0100
+)
+
 const (
 	win32PURGE_RXABORT = 0x0002
 	win32PURGE_RXCLEAR = 0x0008
@@ -74,23 +113,48 @@ const (
 	win32PURGE_TXCLEAR = 0x0004
 )
 
+// Reference https://docs.microsoft.com/en-us/windows/win32/api/winbase/ns-winbase-comstat:
+// typedef struct _COMSTAT {
+//   DWORD fCtsHold : 1;
+//   DWORD fDsrHold : 1;
+//   DWORD fRlsdHold : 1;
+//   DWORD fXoffHold : 1;
+//   DWORD fXoffSent : 1;
+//   DWORD fEof : 1;
+//   DWORD fTxim : 1;
+//   DWORD fReserved : 25;
+//   DWORD cbInQue;
+//   DWORD cbOutQue;
+// } COMSTAT, *LPCOMSTAT;
+type win32COMSTAT struct {
+	fxxxxBits uint32
+	InQue     uint32
+	OutQue    uint32
+}
+
 var (
 	modkernel32 = windows.NewLazySystemDLL("kernel32.dll")
 
-	procGetCommState = modkernel32.NewProc("GetCommState")
-	procSetCommState = modkernel32.NewProc("SetCommState")
-	procPurgeComm    = modkernel32.NewProc("PurgeComm")
+	procGetCommState   = modkernel32.NewProc("GetCommState")
+	procSetCommState   = modkernel32.NewProc("SetCommState")
+	procPurgeComm      = modkernel32.NewProc("PurgeComm")
+	procClearCommError = modkernel32.NewProc("ClearCommError")
+	procSetupComm      = modkernel32.NewProc("SetupComm")
+
+	procEscapeCommFunction = modkernel32.NewProc("EscapeCommFunction")
+	procSetCommBreak       = modkernel32.NewProc("SetCommBreak")
+	procClearCommBreak     = modkernel32.NewProc("ClearCommBreak")
 )
 
 // serialport stopbits to win32 stopbits
-var spToWinStopBitsMap = map[int]uint8{
+var spToWinStopBitsMap = map[StopBits]uint8{
 	SB1:   win32ONESTOPBIT,
 	SB1_5: win32ONE5STOPBITS,
 	SB2:   win32TWOSTOPBITS,
 }
 
 // win32 stopbits to serialport stopbits
-var winToSpStopBitsMap = map[uint8]int{
+var winToSpStopBitsMap = map[uint8]StopBits{
 	win32ONESTOPBIT:   SB1,
 	win32ONE5STOPBITS: SB1_5,
 	win32TWOSTOPBITS:  SB2,
@@ -120,58 +184,804 @@ func win32PurgeComm(handle windows.Handle, flags uint32) error {
 	return nil
 }
 
+func win32SetupComm(handle windows.Handle, inQueue, outQueue uint32) error {
+	r1, _, err := syscall.Syscall(procSetupComm.Addr(), 3, uintptr(handle), uintptr(inQueue), uintptr(outQueue))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+func win32EscapeCommFunction(handle windows.Handle, function uint32) error {
+	r1, _, err := syscall.Syscall(procEscapeCommFunction.Addr(), 2, uintptr(handle), uintptr(function), 0)
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+func win32SetCommBreak(handle windows.Handle) error {
+	r1, _, err := syscall.Syscall(procSetCommBreak.Addr(), 1, uintptr(handle), 0, 0)
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+func win32ClearCommBreak(handle windows.Handle) error {
+	r1, _, err := syscall.Syscall(procClearCommBreak.Addr(), 1, uintptr(handle), 0, 0)
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
+func win32ClearCommError(handle windows.Handle, errors *uint32, stat *win32COMSTAT) error {
+	r1, _, err := syscall.Syscall(procClearCommError.Addr(), 3, uintptr(handle), uintptr(unsafe.Pointer(errors)), uintptr(unsafe.Pointer(stat)))
+	if r1 == 0 {
+		return err
+	}
+	return nil
+}
+
 // A SerialPort is a serial port. This must be instantiated by calling Open() and not manually.
 type SerialPort struct {
 	handle windows.Handle
+	name   string
+
+	cmu       sync.Mutex        // guards cfg, linger, cooked, errFilter, the deadlines, dtr and rts
+	cfg       Config            // last applied configuration
+	linger    time.Duration     // see SetLinger
+	cooked    bool              // see SetCookedMode
+	errFilter func(error) error // see SetErrorFilter
+	rdeadline time.Time         // see SetReadDeadline
+	wdeadline time.Time         // see SetWriteDeadline
+	dtr       bool              // last set DTR state, Windows cannot read it back
+	rts       bool              // last set RTS state, Windows cannot read it back
+
+	rmu sync.Mutex // serializes reads
+	wmu sync.Mutex // serializes writes
+
+	bmu  sync.Mutex // guards rbuf and line
+	rbuf []byte     // data read ahead by the line reader, returned before new data
+	line []byte     // cooked mode input not yet returned by read, see SetCookedMode
+
+	umu      sync.Mutex // guards userData
+	userData interface{}
+
+	lmu    sync.Mutex          // guards closed, done, paused, rov and handle swaps
+	closed bool                // set by Close
+	paused chan struct{}       // closed when Reopen resumes, see pauseBackground
+	done   chan struct{}       // closed by Close to stop background goroutines
+	bg     sync.WaitGroup      // background goroutines using handle, waited for by Close
+	rov    *windows.Overlapped // read in progress, cancelled by Close
+
+	emu      sync.Mutex     // guards commErrs and lineErrs
+	commErrs uint32         // CE_ flags cleared by outWaiting, not yet reported by CommErrors
+	lineErrs LineErrorStats // number of times ClearCommError reported each error since Open
 }
 
 // Open opens a serial port.
+// COM ports are always opened for exclusive access, whatever Config.Exclusive says:
+// Open fails with ErrPortBusy while another process has the port open.
 func Open(name string, cfg Config) (sp *SerialPort, err error) {
+	handle, err := windows.CreateFile(
+		windows.StringToUTF16Ptr(name),
+		accessRights(cfg.Mode),
+		0, // no sharing: exclusive access
+		nil,
+		windows.OPEN_EXISTING,
+		windows.FILE_FLAG_OVERLAPPED,
+		0)
+	if err != nil {
+		return nil, newOpenError(name, err)
+	}
+	sp = &SerialPort{handle: handle, name: name}
+
+	if err = sp.SetConfig(cfg); err != nil {
+		sp.Close()
+		return nil, err
+	}
+	// Errors latched before Open are not reported by CommErrors.
+	win32ClearCommError(sp.handle, nil, nil)
+
+	return
+}
+
+// accessRights returns the desired access of CreateFile for mode.
+func accessRights(mode AccessMode) uint32 {
+	switch mode {
+	case ModeReadOnly:
+		return windows.GENERIC_READ
+	case ModeWriteOnly:
+		return windows.GENERIC_WRITE
+	}
+	return windows.GENERIC_READ | windows.GENERIC_WRITE
+}
+
+// openErrorKind returns the sentinel error that err of CreateFile matches, if any.
+// COM ports cannot be shared, so a port in use fails with ERROR_ACCESS_DENIED.
+func openErrorKind(err error) error {
+	switch err {
+	case windows.ERROR_FILE_NOT_FOUND, windows.ERROR_PATH_NOT_FOUND:
+		return ErrNotFound
+	case windows.ERROR_ACCESS_DENIED, windows.ERROR_SHARING_VIOLATION:
+		return ErrPortBusy
+	}
+	return nil
+}
+
+// OpenNoConfig opens a serial port without changing its settings, e.g. to monitor a link
+// configured by another tool. Config reports the DCB and COMMTIMEOUTS found in place,
+// and they are kept until SetConfig is called.
+func OpenNoConfig(name string) (sp *SerialPort, err error) {
 	handle, err := windows.CreateFile(
 		windows.StringToUTF16Ptr(name),
 		windows.GENERIC_READ|windows.GENERIC_WRITE,
 		0,
 		nil,
 		windows.OPEN_EXISTING,
-		0,
+		windows.FILE_FLAG_OVERLAPPED,
 		0)
 	if err != nil {
-		return
+		return nil, newOpenError(name, err)
 	}
-	sp = &SerialPort{handle: handle}
+	sp = &SerialPort{handle: handle, name: name}
 
-	if err = sp.SetConfig(cfg); err != nil {
+	dcb := win32DCB{DCBlength: uint32(unsafe.Sizeof(win32DCB{}))}
+	if err = win32GetCommState(sp.handle, &dcb); err != nil {
 		sp.Close()
+		return nil, err
 	}
+	cfg, err := sp.Config()
+	if err != nil {
+		sp.Close()
+		return nil, err
+	}
+	sp.setConfig(cfg)
+	sp.dtr, sp.rts = modemLinesFromDCB(&dcb)
+	win32ClearCommError(sp.handle, nil, nil)
 
 	return
 }
 
 // Close close the serial port.
+// Pending output is handled according to SetLinger. Background goroutines using the serial port,
+// such as WatchModemLines and a Manager supervising it, are stopped before the handle is closed.
+// A Read waiting for data returns ErrClosed at once: it is cancelled with CancelIoEx.
+// Closing an already closed serial port returns ErrClosed.
 func (sp *SerialPort) Close() error {
+	if !sp.stopBackground() {
+		return ErrClosed
+	}
+	sp.lingerDrain()
 	return windows.CloseHandle(sp.handle)
 }
 
-// Read reads up to len(b) bytes from the serial port.
-// It returns the number of bytes (0 <= n <= len(b)) read from the serial port and any errors encountered.
-// Note:
-//     Timeout < 1 ms: Read blocks until len(b) bytes are readable;
-//     Timeout > 1 ms: Read blocks until at least one byte is read or timeout.
-func (sp *SerialPort) Read(b []byte) (n int, err error) {
-	return windows.Read(sp.handle, b)
+// Fd returns the HANDLE of the serial port, e.g. to call EscapeCommFunction or DeviceIoControl
+// codes that this package does not wrap. It is an escape hatch and unsafe: the handle is shared
+// with the SerialPort and opened with FILE_FLAG_OVERLAPPED, so I/O on it needs an OVERLAPPED,
+// changing its DCB or COMMTIMEOUTS can break later calls, and it is only valid until Close.
+func (sp *SerialPort) Fd() uintptr {
+	sp.lmu.Lock()
+	defer sp.lmu.Unlock()
+	return uintptr(sp.handle)
+}
+
+// reopen closes the handle and replaces it with that of name opened again with cfg,
+// or with InvalidHandle if that fails. The old handle is closed first, as Windows does not share COM ports.
+// Reads and writes are held off by the caller; the background is paused and a pending WaitCommEvent
+// cancelled, so that no WatchModemLines, ModemStatus or Close uses the handle while it is swapped.
+func (sp *SerialPort) reopen(cfg Config) error {
+	if !sp.pauseBackground() {
+		return ErrClosed
+	}
+	defer sp.resumeBackground()
+	windows.CancelIoEx(sp.handle, nil)
+	sp.bg.Wait()
+
+	windows.CloseHandle(sp.handle)
+	fresh, err := Open(sp.name, cfg)
+	sp.lmu.Lock()
+	if err != nil {
+		sp.handle = windows.InvalidHandle
+	} else {
+		sp.handle = fresh.handle
+	}
+	sp.lmu.Unlock()
+	if err != nil {
+		return err
+	}
+	sp.cmu.Lock()
+	sp.dtr, sp.rts = fresh.dtr, fresh.rts
+	sp.cmu.Unlock()
+	sp.emu.Lock()
+	sp.commErrs, sp.lineErrs = 0, LineErrorStats{}
+	sp.emu.Unlock()
+	sp.bmu.Lock()
+	sp.line = nil
+	sp.bmu.Unlock()
+	return nil
+}
+
+// read reads up to len(b) bytes from the serial port, see SetCookedMode.
+func (sp *SerialPort) read(b []byte) (n int, err error) {
+	return sp.readContext(context.Background(), b)
+}
+
+// readContext is read, cancelling the read and returning ctx.Err() as soon as ctx is done.
+func (sp *SerialPort) readContext(ctx context.Context, b []byte) (n int, err error) {
+	if sp.cookedMode() {
+		return sp.readCooked(ctx, b)
+	}
+	return sp.readRaw(ctx, b)
+}
+
+// readRaw reads up to len(b) bytes from the serial port.
+// It returns io.EOF if the device reports end of file or a broken connection.
+func (sp *SerialPort) readRaw(ctx context.Context, b []byte) (n int, err error) {
+	ov, err := newOverlapped()
+	if err != nil {
+		return
+	}
+	defer windows.CloseHandle(ov.HEvent)
+
+	var done uint32
+	err = windows.ReadFile(sp.handle, b, &done, ov)
+	if err == windows.ERROR_IO_PENDING && !sp.setRead(ov) {
+		// Closed before Close could see the read.
+		windows.CancelIoEx(sp.handle, ov)
+	}
+	err = sp.waitOverlapped(ctx, ov, &done, err)
+	if sp.setRead(nil); err == windows.ERROR_OPERATION_ABORTED && sp.isClosed() {
+		err = ErrClosed
+	}
+	return readResult(done, err)
+}
+
+// setRead records ov as the read in progress, which interrupt cancels, or clears it if ov is nil.
+// It reports false if the serial port is already closed.
+func (sp *SerialPort) setRead(ov *windows.Overlapped) bool {
+	sp.lmu.Lock()
+	defer sp.lmu.Unlock()
+	sp.rov = ov
+	return !sp.closed
+}
+
+// interrupt cancels the read in progress, called by Close before it waits for reads to return.
+// Writes are left to complete, see SetLinger.
+func (sp *SerialPort) interrupt() {
+	sp.lmu.Lock()
+	defer sp.lmu.Unlock()
+	if sp.rov != nil {
+		windows.CancelIoEx(sp.handle, sp.rov)
+	}
+}
+
+// readResult returns the outcome of a ReadFile that transferred done bytes and failed with err, if not nil.
+// The bytes transferred are always returned. A read that timed out succeeds with what it got, like on Linux,
+// although some USB drivers fail it with ERROR_SEM_TIMEOUT or ERROR_COUNTER_TIMEOUT.
+func readResult(done uint32, err error) (int, error) {
+	switch {
+	case isTimeoutErrno(err):
+		err = nil
+	case err == windows.ERROR_HANDLE_EOF || err == windows.ERROR_BROKEN_PIPE:
+		err = io.EOF
+	}
+	return int(done), err
+}
+
+// isTimeoutErrno reports whether err is how some drivers fail an I/O request that timed out.
+func isTimeoutErrno(err error) bool {
+	return err == windows.ERROR_SEM_TIMEOUT || err == windows.ERROR_COUNTER_TIMEOUT
+}
+
+// newOverlapped returns an Overlapped with its own manual-reset event, which the caller must close.
+// The handle is opened with FILE_FLAG_OVERLAPPED so that a pending WaitCommEvent does not block reads and writes.
+func newOverlapped() (*windows.Overlapped, error) {
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &windows.Overlapped{HEvent: event}, nil
+}
+
+// waitOverlapped waits for the overlapped operation that returned err to complete.
+// If ctx is done first, the operation is cancelled with CancelIoEx and ctx.Err() is returned,
+// together with what was transferred until then.
+func (sp *SerialPort) waitOverlapped(ctx context.Context, ov *windows.Overlapped, done *uint32, err error) error {
+	if err != windows.ERROR_IO_PENDING {
+		return err
+	}
+	if ctx.Done() == nil {
+		return windows.GetOverlappedResult(sp.handle, ov, done, true)
+	}
+
+	result := make(chan error, 1)
+	go func() {
+		result <- windows.GetOverlappedResult(sp.handle, ov, done, true)
+	}()
+	select {
+	case err = <-result:
+		return err
+	case <-ctx.Done():
+	}
+	// The operation must be over before ov and its buffer can be released.
+	windows.CancelIoEx(sp.handle, ov)
+	if err = <-result; err == windows.ERROR_OPERATION_ABORTED {
+		err = ctx.Err()
+	}
+	return err
+}
+
+// readExact fills b by looping on read until all bytes have arrived.
+func (sp *SerialPort) readExact(b []byte) (n int, err error) {
+	for n < len(b) {
+		var m int
+		m, err = sp.read(b[n:])
+		n += m
+		if err != nil {
+			return
+		}
+	}
+	return
+}
+
+// readTimeout waits up to timeout for at least one byte, then reads up to len(b) bytes.
+// It returns ErrTimeout if no data arrives in time. A negative timeout waits forever.
+func (sp *SerialPort) readTimeout(b []byte, timeout time.Duration) (n int, err error) {
+	// Close waits for the read to return before closing the handle.
+	if !sp.acquire() {
+		return 0, ErrClosed
+	}
+	defer sp.release()
+	if timeout < 0 {
+		timeout = (math.MaxUint32 - 1) * time.Millisecond
+	}
+	restore, err := sp.setTimeouts(timeout, sp.config().WriteTimeout, -1)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if rerr := restore(); err == nil {
+			err = rerr
+		}
+	}()
+
+	n, err = sp.read(b)
+	if err == nil && n == 0 {
+		err = ErrTimeout
+	}
+	return
+}
+
+// setTimeouts changes only the COMMTIMEOUTS of the serial port, without the cost of rewriting the DCB,
+// and returns a function that restores the previous ones for one-shot operations.
+// A negative interval makes ReadFile return as soon as any byte is available,
+// waiting up to readTotal for the first one (or not at all if readTotal is 0);
+// otherwise interval is the maximum gap between bytes. A zero writeTotal means writes do not time out.
+func (sp *SerialPort) setTimeouts(readTotal, writeTotal, interval time.Duration) (restore func() error, err error) {
+	var saved windows.CommTimeouts
+	if err = windows.GetCommTimeouts(sp.handle, &saved); err != nil {
+		return
+	}
+
+	t := windows.CommTimeouts{
+		ReadTotalTimeoutConstant:  durationToMs(readTotal),
+		WriteTotalTimeoutConstant: durationToMs(writeTotal),
+	}
+	if interval < 0 {
+		t.ReadIntervalTimeout = math.MaxUint32
+		if readTotal > 0 {
+			t.ReadTotalTimeoutMultiplier = math.MaxUint32
+		}
+	} else {
+		t.ReadIntervalTimeout = durationToMs(interval)
+	}
+	if err = windows.SetCommTimeouts(sp.handle, &t); err != nil {
+		return
+	}
+
+	return func() error {
+		return windows.SetCommTimeouts(sp.handle, &saved)
+	}, nil
+}
+
+// readForever is the ReadTotalTimeoutConstant of a read without Config.Timeout, about 49 days.
+// MAXDWORD itself is not allowed together with a MAXDWORD interval and multiplier.
+const readForever = math.MaxUint32 - 1
+
+// commTimeoutsFor returns the COMMTIMEOUTS of cfg. In both cases a read returns as soon as
+// any byte is available, like on Linux: a MAXDWORD interval and multiplier make ReadFile
+// wait up to the constant for the first byte, which is Config.Timeout rounded up to 1 ms,
+// or readForever without a timeout.
+func commTimeoutsFor(cfg Config) windows.CommTimeouts {
+	t := windows.CommTimeouts{
+		ReadIntervalTimeout:        math.MaxUint32,
+		ReadTotalTimeoutMultiplier: math.MaxUint32,
+		ReadTotalTimeoutConstant:   readForever,
+		WriteTotalTimeoutConstant:  durationToMs(cfg.WriteTimeout),
+	}
+	if cfg.Timeout > 0 {
+		t.ReadTotalTimeoutConstant = durationToMs(cfg.Timeout)
+	}
+	return t
+}
+
+// durationToMs converts d to whole milliseconds for COMMTIMEOUTS, rounding up and
+// saturating below MAXDWORD, which has a special meaning.
+func durationToMs(d time.Duration) uint32 {
+	if d <= 0 {
+		return 0
+	}
+	ms := (d + time.Millisecond - 1) / time.Millisecond
+	if ms >= math.MaxUint32 {
+		return math.MaxUint32 - 1
+	}
+	return uint32(ms)
+}
+
+// write writes len(b) bytes to the serial port, see SetCookedMode.
+func (sp *SerialPort) write(b []byte) (n int, err error) {
+	return sp.writeContext(context.Background(), b)
 }
 
-// Write writes len(b) bytes to the serial port.
-// It returns the number of bytes (0 <= n <= len(b)) written to the serial port and any errors encountered.
-func (sp *SerialPort) Write(b []byte) (n int, err error) {
-	return windows.Write(sp.handle, b)
+// writeContext is write, cancelling the write and returning ctx.Err() as soon as ctx is done.
+func (sp *SerialPort) writeContext(ctx context.Context, b []byte) (n int, err error) {
+	if sp.cookedMode() {
+		return sp.writeCooked(ctx, b)
+	}
+	return sp.writeRaw(ctx, b)
+}
+
+// writeRaw writes len(b) bytes to the serial port, honoring Config.WriteTimeout.
+func (sp *SerialPort) writeRaw(ctx context.Context, b []byte) (n int, err error) {
+	ov, err := newOverlapped()
+	if err != nil {
+		return
+	}
+	defer windows.CloseHandle(ov.HEvent)
+
+	var done uint32
+	err = sp.waitOverlapped(ctx, ov, &done, windows.WriteFile(sp.handle, b, &done, ov))
+	n = int(done)
+	if (err == nil && n < len(b)) || isTimeoutErrno(err) {
+		err = ErrTimeout
+	}
+	return
 }
 
 // Flush flushes both data received but not read, and data written but not transmitted.
 func (sp *SerialPort) Flush() error {
+	sp.discardBuffered()
+	sp.discardLine()
 	return win32PurgeComm(sp.handle, win32PURGE_RXABORT|win32PURGE_RXCLEAR|win32PURGE_TXABORT|win32PURGE_TXCLEAR)
 }
 
+// flushInput flushes data received but not read.
+func (sp *SerialPort) flushInput() error {
+	sp.discardBuffered()
+	sp.discardLine()
+	return win32PurgeComm(sp.handle, win32PURGE_RXABORT|win32PURGE_RXCLEAR)
+}
+
+// flushOutput flushes data written but not transmitted.
+func (sp *SerialPort) flushOutput() error {
+	return win32PurgeComm(sp.handle, win32PURGE_TXABORT|win32PURGE_TXCLEAR)
+}
+
+// drain waits until all data written has been transmitted.
+func (sp *SerialPort) drain() error {
+	return windows.FlushFileBuffers(sp.handle)
+}
+
+// inWaiting returns the number of bytes received but not yet read.
+func (sp *SerialPort) inWaiting() (int, error) {
+	var stat win32COMSTAT
+	if err := sp.clearCommError(&stat); err != nil {
+		return 0, err
+	}
+	return int(stat.InQue), nil
+}
+
+// outWaiting returns the number of bytes written but not yet transmitted.
+func (sp *SerialPort) outWaiting() (int, error) {
+	var stat win32COMSTAT
+	if err := sp.clearCommError(&stat); err != nil {
+		return 0, err
+	}
+	return int(stat.OutQue), nil
+}
+
+// clearCommError calls ClearCommError, keeping the errors it clears for CommErrors.
+func (sp *SerialPort) clearCommError(stat *win32COMSTAT) error {
+	sp.emu.Lock()
+	defer sp.emu.Unlock()
+
+	var errs uint32
+	if err := win32ClearCommError(sp.handle, &errs, stat); err != nil {
+		return err
+	}
+	sp.commErrs |= errs
+	countLineErrors(&sp.lineErrs, errs)
+	return nil
+}
+
+// countLineErrors adds the errors reported by one ClearCommError call to stats.
+func countLineErrors(stats *LineErrorStats, errs uint32) {
+	if errs&win32CE_RXPARITY != 0 {
+		stats.Parity++
+	}
+	if errs&win32CE_FRAME != 0 {
+		stats.Framing++
+	}
+	if errs&(win32CE_OVERRUN|win32CE_RXOVER) != 0 {
+		stats.Overrun++
+	}
+	if errs&win32CE_BREAK != 0 {
+		stats.Break++
+	}
+}
+
+// framingErrorCount returns the number of times ClearCommError reported framing errors.
+func (sp *SerialPort) framingErrorCount() (int, error) {
+	var stat win32COMSTAT
+	if err := sp.clearCommError(&stat); err != nil {
+		return 0, err
+	}
+
+	sp.emu.Lock()
+	defer sp.emu.Unlock()
+	return sp.lineErrs.Framing, nil
+}
+
+// LineErrors returns the number of parity, framing, overrun and break errors since Open.
+// Windows latches errors until ClearCommError is called, e.g. by CommErrors or OutputWaiting,
+// so each count is the number of calls that found the error, not the number of bad characters.
+func (sp *SerialPort) LineErrors() (LineErrorStats, error) {
+	var stat win32COMSTAT
+	if err := sp.clearCommError(&stat); err != nil {
+		return LineErrorStats{}, err
+	}
+
+	sp.emu.Lock()
+	defer sp.emu.Unlock()
+	return sp.lineErrs, nil
+}
+
+// CommErrors returns the line errors that occurred since the last call, or since Open,
+// and clears them in the driver, which latches them until ClearCommError is called.
+func (sp *SerialPort) CommErrors() (CommErrorFlags, error) {
+	var stat win32COMSTAT
+	if err := sp.clearCommError(&stat); err != nil {
+		return 0, err
+	}
+
+	sp.emu.Lock()
+	errs := sp.commErrs
+	sp.commErrs = 0
+	sp.emu.Unlock()
+
+	return decodeCommErrors(errs), nil
+}
+
+// win32CommErrors maps ClearCommError errors to CommErrorFlags.
+var win32CommErrors = []struct {
+	ce   uint32
+	flag CommErrorFlags
+}{
+	{win32CE_RXOVER, CommRxOver},
+	{win32CE_OVERRUN, CommOverrun},
+	{win32CE_RXPARITY, CommParity},
+	{win32CE_FRAME, CommFrame},
+	{win32CE_BREAK, CommBreak},
+	{win32CE_TXFULL, CommTxFull},
+}
+
+func decodeCommErrors(errs uint32) (flags CommErrorFlags) {
+	for _, e := range win32CommErrors {
+		if errs&e.ce != 0 {
+			flags |= e.flag
+		}
+	}
+	return
+}
+
+// writeChunkSize returns how many bytes write should be given at once:
+// the driver's current output buffer size, if it reports one.
+func (sp *SerialPort) writeChunkSize() int {
+	prop := win32COMMPROP{}
+	if err := win32GetCommProperties(sp.handle, &prop); err != nil || prop.CurrentTxQueue == 0 {
+		return defaultWriteChunkSize
+	}
+	return int(prop.CurrentTxQueue)
+}
+
+// TxFifoSize returns the size of the UART transmit FIFO on Linux.
+// Windows does not report it, so it always returns ErrUnsupported.
+func (sp *SerialPort) TxFifoSize() (int, error) {
+	return 0, ErrUnsupported
+}
+
+// SetTxFifoSize sets how many bytes of the transmit FIFO the driver uses on Linux.
+// Windows does not support it, so it always returns ErrUnsupported.
+func (sp *SerialPort) SetTxFifoSize(n int) error {
+	return ErrUnsupported
+}
+
+// SetBufferSizes asks the driver for input and output buffers of rx and tx bytes with SetupComm,
+// e.g. larger ones so that no data is lost at high baud rates when reads are delayed.
+// The sizes are recommendations that the driver may round or ignore; until SetBufferSizes
+// is called the driver's defaults are kept. See Capabilities for the maximum sizes.
+func (sp *SerialPort) SetBufferSizes(rx, tx int) error {
+	if rx <= 0 || tx <= 0 {
+		return fmt.Errorf("serialport: invalid buffer sizes %v and %v", rx, tx)
+	}
+	return win32SetupComm(sp.handle, uint32(rx), uint32(tx))
+}
+
+// DTR reports whether the DTR (Data Terminal Ready) output line is asserted.
+// Windows cannot read output lines back, so this is the state last set by this SerialPort.
+func (sp *SerialPort) DTR() (bool, error) {
+	sp.cmu.Lock()
+	defer sp.cmu.Unlock()
+	return sp.dtr, nil
+}
+
+// RTS reports whether the RTS (Request To Send) output line is asserted.
+// Windows cannot read output lines back, so this is the state last set by this SerialPort.
+func (sp *SerialPort) RTS() (bool, error) {
+	sp.cmu.Lock()
+	defer sp.cmu.Unlock()
+	return sp.rts, nil
+}
+
+// SetParityBit switches between mark (true) and space (false) parity without a full SetConfig,
+// e.g. to send the address byte of a 9-bit multidrop protocol with the 9th bit set and the data with it clear.
+// Config.Parity must be PM or PS. Pending output is transmitted first, so every byte goes out with
+// the parity it was written with. Each switch costs system calls and a drain of the output,
+// so group consecutive bytes that share the 9th bit into one Write.
+func (sp *SerialPort) SetParityBit(mark bool) error {
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+
+	dcb := win32DCB{DCBlength: uint32(unsafe.Sizeof(win32DCB{}))}
+	if err := win32GetCommState(sp.handle, &dcb); err != nil {
+		return err
+	}
+	if dcb.Parity != uint8(PM) && dcb.Parity != uint8(PS) {
+		return fmt.Errorf("serialport: SetParityBit requires mark or space parity")
+	}
+
+	dcb.Parity = uint8(PS)
+	if mark {
+		dcb.Parity = uint8(PM)
+	}
+	if err := sp.drain(); err != nil {
+		return err
+	}
+	if err := win32SetCommState(sp.handle, &dcb); err != nil {
+		return err
+	}
+
+	sp.cmu.Lock()
+	sp.cfg.Parity = Parity(dcb.Parity)
+	sp.cmu.Unlock()
+	return nil
+}
+
+// setBreak starts (SetCommBreak) or ends (ClearCommBreak) the break condition.
+func (sp *SerialPort) setBreak(on bool) error {
+	if on {
+		return win32SetCommBreak(sp.handle)
+	}
+	return win32ClearCommBreak(sp.handle)
+}
+
+// SetDTR asserts (true) or deasserts (false) the DTR (Data Terminal Ready) output line,
+// without touching the rest of the configuration. SetConfig keeps the state set here.
+func (sp *SerialPort) SetDTR(on bool) error {
+	if !sp.acquire() {
+		return ErrClosed
+	}
+	defer sp.release()
+	sp.cmu.Lock()
+	defer sp.cmu.Unlock()
+
+	function := uint32(win32CLRDTR)
+	if on {
+		function = win32SETDTR
+	}
+	if err := win32EscapeCommFunction(sp.handle, function); err != nil {
+		return err
+	}
+	sp.dtr = on
+	return nil
+}
+
+// SetRTS asserts (true) or deasserts (false) the RTS (Request To Send) output line,
+// without touching the rest of the configuration. SetConfig keeps the state set here.
+func (sp *SerialPort) SetRTS(on bool) error {
+	if !sp.acquire() {
+		return ErrClosed
+	}
+	defer sp.release()
+	sp.cmu.Lock()
+	defer sp.cmu.Unlock()
+
+	function := uint32(win32CLRRTS)
+	if on {
+		function = win32SETRTS
+	}
+	if err := win32EscapeCommFunction(sp.handle, function); err != nil {
+		return err
+	}
+	sp.rts = on
+	return nil
+}
+
+// modemControlBits returns the DCB fDtrControl and fRtsControl bits that keep DTR and RTS as last set.
+func (sp *SerialPort) modemControlBits() uint32 {
+	sp.cmu.Lock()
+	defer sp.cmu.Unlock()
+
+	var bits uint32
+	if sp.dtr {
+		bits |= win32DTR_CONTROL_ENABLE
+	}
+	if sp.rts {
+		bits |= win32RTS_CONTROL_ENABLE
+	}
+	return bits
+}
+
+// modemLinesFromDCB returns whether dcb asserts DTR and RTS, as opposed to deasserting them or leaving them to handshaking.
+func modemLinesFromDCB(dcb *win32DCB) (dtr, rts bool) {
+	return dcb.fxxxxBits&win32DTR_CONTROL_MASK == win32DTR_CONTROL_ENABLE,
+		dcb.fxxxxBits&win32RTS_CONTROL_MASK == win32RTS_CONTROL_ENABLE
+}
+
+// applyFlowControl sets the flow control of cfg in dcb. With hardware flow control the driver drives RTS.
+func applyFlowControl(dcb *win32DCB, cfg Config) {
+	switch cfg.FlowControl {
+	case FlowHardware:
+		dcb.fxxxxBits &^= win32RTS_CONTROL_MASK
+		dcb.fxxxxBits |= win32fOutxCtsFlow | win32RTS_CONTROL_HANDSHAKE
+	case FlowSoftware:
+		dcb.fxxxxBits |= win32fOutX | win32fInX
+		dcb.XonLim = win32XonLim
+		dcb.XoffLim = win32XoffLim
+	}
+	dcb.XonChar = int8(cfg.xonChar())
+	dcb.XoffChar = int8(cfg.xoffChar())
+}
+
+// flowControl returns the flow control set in dcb.
+func flowControl(dcb *win32DCB) int {
+	switch {
+	case dcb.fxxxxBits&win32fOutxCtsFlow != 0:
+		return FlowHardware
+	case dcb.fxxxxBits&(win32fOutX|win32fInX) != 0:
+		return FlowSoftware
+	}
+	return FlowNone
+}
+
+// probe checks that the serial port is still usable.
+func (sp *SerialPort) probe() error {
+	dcb := win32DCB{DCBlength: uint32(unsafe.Sizeof(win32DCB{}))}
+	return win32GetCommState(sp.handle, &dcb)
+}
+
+// actualBaudRate returns the baud rate the driver reports having set for requested.
+func (sp *SerialPort) actualBaudRate(requested int) (int, error) {
+	dcb := win32DCB{DCBlength: uint32(unsafe.Sizeof(win32DCB{}))}
+	if err := win32GetCommState(sp.handle, &dcb); err != nil {
+		return 0, err
+	}
+	return int(dcb.BaudRate), nil
+}
+
 // Config returns the configuration of the serial port.
 func (sp *SerialPort) Config() (cfg Config, err error) {
 	dcb := win32DCB{DCBlength: uint32(unsafe.Sizeof(win32DCB{}))}
@@ -184,11 +994,33 @@ func (sp *SerialPort) Config() (cfg Config, err error) {
 	}
 
 	cfg = Config{
-		BaudRate: int(dcb.BaudRate),
-		DataBits: int(dcb.ByteSize),
-		StopBits: winToSpStopBitsMap[dcb.StopBits],
-		Parity:   int(dcb.Parity),
-		Timeout:  time.Duration(timeouts.ReadTotalTimeoutConstant) * time.Millisecond,
+		BaudRate:     int(dcb.BaudRate),
+		DataBits:     DataBits(dcb.ByteSize),
+		StopBits:     winToSpStopBitsMap[dcb.StopBits],
+		Parity:       Parity(dcb.Parity),
+		FlowControl:  flowControl(&dcb),
+		Timeout:      time.Duration(timeouts.ReadTotalTimeoutConstant) * time.Millisecond,
+		WriteTimeout: time.Duration(timeouts.WriteTotalTimeoutConstant) * time.Millisecond,
+	}
+	if timeouts.ReadTotalTimeoutConstant == readForever {
+		cfg.Timeout = 0
+	}
+
+	// Settings with no DCB equivalent are those of the last SetConfig.
+	cached := sp.config()
+	cfg.HangupOnClose = cached.HangupOnClose
+	cfg.NoResetOnOpen = cached.NoResetOnOpen
+	cfg.Mode = cached.Mode
+	cfg.Exclusive = cached.Exclusive
+	cfg.RetryEmptyReads = cached.RetryEmptyReads
+	cfg.StrictSevenBit = cached.StrictSevenBit
+	cfg.XonChar, cfg.XoffChar = flowChars(byte(dcb.XonChar), byte(dcb.XoffChar), cached)
+	// COMMTIMEOUTS only have millisecond resolution, keep the exact timeouts if they still hold them.
+	if cached.Timeout > 0 && timeouts.ReadTotalTimeoutConstant == durationToMs(cached.Timeout) {
+		cfg.Timeout = cached.Timeout
+	}
+	if timeouts.WriteTotalTimeoutConstant == durationToMs(cached.WriteTimeout) {
+		cfg.WriteTimeout = cached.WriteTimeout
 	}
 
 	return
@@ -196,19 +1028,39 @@ func (sp *SerialPort) Config() (cfg Config, err error) {
 
 func checkConfigParam(cfg Config) error {
 	if cfg.BaudRate < 0 {
-		return fmt.Errorf("serialport: Config.BaudRate cannot be negative %v", cfg.BaudRate)
+		return configErrorf("BaudRate", "Config.BaudRate cannot be negative %v", cfg.BaudRate)
 	}
 
 	if cfg.DataBits != DB5 && cfg.DataBits != DB6 && cfg.DataBits != DB7 && cfg.DataBits != DB8 {
-		return fmt.Errorf("serialport: invalid Config.DataBits %v", cfg.DataBits)
+		return configErrorf("DataBits", "invalid Config.DataBits %v", cfg.DataBits)
 	}
 
 	if cfg.StopBits != SB1 && cfg.StopBits != SB1_5 && cfg.StopBits != SB2 {
-		return fmt.Errorf("serialport: invalid Config.StopBits %v", cfg.StopBits)
+		return configErrorf("StopBits", "invalid Config.StopBits %v", cfg.StopBits)
 	}
 
 	if cfg.Parity != PN && cfg.Parity != PO && cfg.Parity != PE && cfg.Parity != PM && cfg.Parity != PS {
-		return fmt.Errorf("serialport: invalid Config.Parity %v", cfg.Parity)
+		return configErrorf("Parity", "invalid Config.Parity %v", cfg.Parity)
+	}
+
+	if cfg.LowercaseInput || cfg.UppercaseOutput {
+		return configErrorf("LowercaseInput", "Config.LowercaseInput and Config.UppercaseOutput are not supported on Windows")
+	}
+
+	if cfg.MarkParityErrors {
+		return configErrorf("MarkParityErrors", "Config.MarkParityErrors is not supported on Windows")
+	}
+
+	if cfg.FlowControl != FlowNone && cfg.FlowControl != FlowHardware && cfg.FlowControl != FlowSoftware {
+		return configErrorf("FlowControl", "invalid Config.FlowControl %v", cfg.FlowControl)
+	}
+
+	if cfg.xonChar() == cfg.xoffChar() {
+		return configErrorf("XonChar", "Config.XonChar and Config.XoffChar cannot both be %#02x", cfg.xonChar())
+	}
+
+	if cfg.Mode != ModeReadWrite && cfg.Mode != ModeReadOnly && cfg.Mode != ModeWriteOnly {
+		return configErrorf("Mode", "invalid Config.Mode %v", cfg.Mode)
 	}
 
 	return nil
@@ -219,6 +1071,12 @@ func (sp *SerialPort) SetConfig(cfg Config) error {
 	if err := checkConfigParam(cfg); err != nil {
 		return err
 	}
+	// Not every driver implements GetCommProperties, in which case SetCommState has the final say.
+	if caps, err := sp.Capabilities(); err == nil {
+		if err := checkCapabilities(cfg, caps); err != nil {
+			return err
+		}
+	}
 
 	dcb := win32DCB{
 		DCBlength: uint32(unsafe.Sizeof(win32DCB{})),
@@ -226,26 +1084,31 @@ func (sp *SerialPort) SetConfig(cfg Config) error {
 		ByteSize:  uint8(cfg.DataBits),
 		Parity:    uint8(cfg.Parity),
 		StopBits:  spToWinStopBitsMap[cfg.StopBits],
+		// DTR and RTS stay as last set by SetDTR and SetRTS, which is deasserted after Open,
+		// so they are never asserted by Open, which is what Config.NoResetOnOpen asks for.
+		fxxxxBits: sp.modemControlBits(),
+	}
+	applyFlowControl(&dcb, cfg)
+	prev := win32DCB{DCBlength: uint32(unsafe.Sizeof(win32DCB{}))}
+	if err := win32GetCommState(sp.handle, &prev); err != nil {
+		return err
 	}
 	if err := win32SetCommState(sp.handle, &dcb); err != nil {
 		return err
 	}
-
-	var commTimeouts windows.CommTimeouts
-	timeoutMs := uint32(cfg.Timeout.Milliseconds())
-	if timeoutMs > 0 {
-		commTimeouts = windows.CommTimeouts{
-			ReadIntervalTimeout:        math.MaxUint32,
-			ReadTotalTimeoutMultiplier: math.MaxUint32,
-			ReadTotalTimeoutConstant:   timeoutMs,
-			WriteTotalTimeoutConstant:  timeoutMs,
-		}
-	} else {
-		commTimeouts = windows.CommTimeouts{}
+	// The rate is passed through as is, but some drivers round it to one they support without failing,
+	// which can only be seen once it is set: the previous settings are restored, so that on error
+	// the port keeps running as Config reports it.
+	if actual, err := sp.actualBaudRate(cfg.BaudRate); err == nil && actual != cfg.BaudRate {
+		win32SetCommState(sp.handle, &prev)
+		return configErrorf("BaudRate", "driver set %v baud instead of the requested %v", actual, cfg.BaudRate)
 	}
+
+	commTimeouts := commTimeoutsFor(cfg)
 	if err := windows.SetCommTimeouts(sp.handle, &commTimeouts); err != nil {
 		return err
 	}
+	sp.setConfig(cfg)
 
 	return nil
 }
@@ -1,76 +1,338 @@
 package serialport
 
 import (
+	"io"
+	"math"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
 	"time"
+
+	"golang.org/x/sys/windows"
 )
 
-func TestHelloWorld(t *testing.T) {
-	sp, err := Open("COM3", DefaultConfig())
+// openTestPair opens both ends of the null-modem pair named by SERIALPORT_TEST_PAIR, e.g. "COM3,COM4"
+// as created by com0com, or skips the test if it is not set.
+func openTestPair(t *testing.T, cfg Config) (peer, sp *SerialPort) {
+	t.Helper()
+
+	names := strings.Split(os.Getenv("SERIALPORT_TEST_PAIR"), ",")
+	if len(names) != 2 {
+		t.Skip("SERIALPORT_TEST_PAIR is not set to a pair of COM ports")
+	}
+	peer, err := Open(names[0], DefaultConfig())
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	sp, err = Open(names[1], cfg)
 	if err != nil {
+		peer.Close()
 		t.Fatalf("Open: %v", err)
 	}
+	return peer, sp
+}
+
+func TestHelloWorld(t *testing.T) {
+	peer, sp := openTestPair(t, DefaultConfig())
+	defer peer.Close()
 	defer sp.Close()
 
 	n, err := sp.Write([]byte("Hello, World"))
 	if err != nil {
 		t.Fatalf("Write: %v", err)
 	}
-
 	t.Logf("Write %v bytes to serial port", n)
+
+	buf := make([]byte, n)
+	if _, err := peer.ReadFull(buf); err != nil || string(buf) != "Hello, World" {
+		t.Fatalf("peer ReadFull = %q, %v; want \"Hello, World\"", buf, err)
+	}
 }
 
 func TestEcho(t *testing.T) {
 	cfg := DefaultConfig()
-	//cfg.Timeout = 0
 	cfg.Timeout = 1000 * time.Millisecond
-	sp, err := Open("COM3", cfg)
-	if err != nil {
-		t.Fatalf("Open: %v", err)
-	}
+	peer, sp := openTestPair(t, cfg)
+	defer peer.Close()
 	defer sp.Close()
 
-	buf := make([]byte, 64)
-	for {
+	for _, msg := range []string{"ping", "hello", "\x00\xff"} {
+		if _, err := peer.WriteString(msg); err != nil {
+			t.Fatalf("peer Write: %v", err)
+		}
+
+		buf := make([]byte, 64)
 		n, err := sp.Read(buf)
 		if err != nil {
 			t.Fatalf("Read: %v", err)
 		}
 		t.Logf("Read(%v): %v", n, buf[:n])
-
-		n, err = sp.Write(buf[:n])
-		if err != nil {
+		if _, err = sp.Write(buf[:n]); err != nil {
 			t.Fatalf("Write: %v", err)
 		}
-		t.Logf("Write(%v): %v", n, buf[:n])
+
+		echo := make([]byte, len(msg))
+		if _, err := peer.ReadFull(echo); err != nil || string(echo) != msg {
+			t.Fatalf("echo = %q, %v; want %q", echo, err, msg)
+		}
 	}
 }
 
 func TestFlush(t *testing.T) {
-	cfg := DefaultConfig()
-	cfg.Timeout = 12 * time.Second
-	sp, err := Open("COM3", cfg)
-	if err != nil {
-		t.Fatalf("Open: %v", err)
+	peer, sp := openTestPair(t, DefaultConfig())
+	defer peer.Close()
+	defer sp.Close()
+
+	peer.WriteString("stale")
+	time.Sleep(20 * time.Millisecond)
+	if err := sp.Flush(); err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+
+	buf := make([]byte, 8)
+	if n, err := sp.Read(buf); n != 0 || err != nil {
+		t.Fatalf("Read after Flush = %q, %v; want nothing", buf[:n], err)
+	}
+	peer.WriteString("fresh")
+	if n, err := sp.Read(buf); string(buf[:n]) != "fresh" || err != nil {
+		t.Fatalf("Read = %q, %v; want \"fresh\"", buf[:n], err)
 	}
+}
+
+func TestPartialReadTimeout(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Timeout = 200 * time.Millisecond
+	peer, sp := openTestPair(t, cfg)
+	defer peer.Close()
 	defer sp.Close()
 
-	buf := make([]byte, 2)
-	n, err := sp.Read(buf)
+	peer.WriteString("abc")
+	buf := make([]byte, 64)
+	if n, err := sp.Read(buf); string(buf[:n]) != "abc" || err != nil {
+		t.Fatalf("Read = %q, %v; want \"abc\", nil", buf[:n], err)
+	}
+}
+
+func TestReadResult(t *testing.T) {
+	for _, tt := range []struct {
+		err   error
+		n     int
+		isErr error
+	}{
+		{nil, 3, nil},
+		{windows.ERROR_SEM_TIMEOUT, 3, nil},
+		{windows.ERROR_COUNTER_TIMEOUT, 0, nil},
+		{windows.ERROR_BROKEN_PIPE, 2, io.EOF},
+		{windows.ERROR_OPERATION_ABORTED, 1, windows.ERROR_OPERATION_ABORTED},
+	} {
+		if n, err := readResult(uint32(tt.n), tt.err); n != tt.n || err != tt.isErr {
+			t.Errorf("readResult(%v, %v) = %v, %v; want %v, %v", tt.n, tt.err, n, err, tt.n, tt.isErr)
+		}
+	}
+}
+
+func TestReadEmptyBuffer(t *testing.T) {
+	// An invalid handle makes any syscall fail.
+	sp := &SerialPort{handle: windows.InvalidHandle}
+
+	for _, b := range [][]byte{nil, {}} {
+		n, err := sp.Read(b)
+		if n != 0 || err != nil {
+			t.Fatalf("Read(%v) = %v, %v; want 0, nil", b, n, err)
+		}
+	}
+}
+
+func TestDecodeCommProp(t *testing.T) {
+	// Values reported by a typical 16550 UART driver.
+	prop := win32COMMPROP{
+		MaxTxQueue:         0,
+		MaxRxQueue:         0,
+		SettableBaud:       0x10077ff2,
+		SettableData:       0x000f,
+		SettableStopParity: 0x1f07,
+	}
+
+	caps := decodeCommProp(&prop)
+
+	if !caps.CustomBaud {
+		t.Errorf("CustomBaud = false, want true")
+	}
+	if !reflect.DeepEqual(caps.DataBits, []DataBits{DB5, DB6, DB7, DB8}) {
+		t.Errorf("DataBits = %v", caps.DataBits)
+	}
+	if !reflect.DeepEqual(caps.StopBits, []StopBits{SB1, SB1_5, SB2}) {
+		t.Errorf("StopBits = %v", caps.StopBits)
+	}
+	if !reflect.DeepEqual(caps.Parities, []Parity{PN, PO, PE, PM, PS}) {
+		t.Errorf("Parities = %v", caps.Parities)
+	}
+	if !containsInt(caps.BaudRates, BR115200) || containsInt(caps.BaudRates, 75) {
+		t.Errorf("BaudRates = %v", caps.BaudRates)
+	}
+
+	// A USB adapter that only does 7 and 8 data bits and no 1.5 stop bits.
+	prop = win32COMMPROP{SettableData: 0x000c, SettableStopParity: 0x0705}
+	caps = decodeCommProp(&prop)
+
+	cfg := DefaultConfig()
+	cfg.DataBits = DB5
+	if err := checkCapabilities(cfg, caps); err == nil {
+		t.Errorf("checkCapabilities accepted 5 data bits")
+	}
+	cfg = DefaultConfig()
+	cfg.StopBits = SB1_5
+	if err := checkCapabilities(cfg, caps); err == nil {
+		t.Errorf("checkCapabilities accepted 1.5 stop bits")
+	}
+	cfg = DefaultConfig()
+	cfg.Parity = PM
+	if err := checkCapabilities(cfg, caps); err == nil {
+		t.Errorf("checkCapabilities accepted mark parity")
+	}
+	if err := checkCapabilities(DefaultConfig(), caps); err != nil {
+		t.Errorf("checkCapabilities(DefaultConfig()): %v", err)
+	}
+
+	// Odd rates such as 250000 (3D printers) and 31250 (MIDI) need BAUD_USER.
+	prop = win32COMMPROP{SettableBaud: 0x00077ff2}
+	caps = decodeCommProp(&prop)
+	cfg = DefaultConfig()
+	cfg.BaudRate = 250000
+	if err := checkCapabilities(cfg, caps); err == nil {
+		t.Errorf("checkCapabilities accepted 250000 baud without BAUD_USER")
+	}
+	caps.CustomBaud = true
+	if err := checkCapabilities(cfg, caps); err != nil {
+		t.Errorf("checkCapabilities(250000 baud) with BAUD_USER: %v", err)
+	}
+}
+
+func TestCustomBaudRoundTrip(t *testing.T) {
+	sp, err := Open("COM3", DefaultConfig())
 	if err != nil {
-		t.Fatalf("Read: %v", err)
+		t.Skipf("Open: %v", err)
 	}
-	t.Logf("Read %v bytes: %v", n, string(buf[:n]))
+	defer sp.Close()
 
-	sp.Flush()
-	for {
-		n, err = sp.Read(buf)
+	for _, baud := range []int{31250, 250000} {
+		cfg := DefaultConfig()
+		cfg.BaudRate = baud
+		if err := sp.SetConfig(cfg); err != nil {
+			t.Logf("SetConfig(%v baud): %v", baud, err)
+			continue
+		}
+		got, err := sp.Config()
 		if err != nil {
-			t.Fatalf("Read: %v", err)
+			t.Fatalf("Config: %v", err)
+		}
+		if got.BaudRate != baud {
+			t.Errorf("Config().BaudRate = %v, want %v", got.BaudRate, baud)
+		}
+	}
+}
+
+func TestExpandNL(t *testing.T) {
+	if got := string(expandNL([]byte("a\nb\n"))); got != "a\r\nb\r\n" {
+		t.Fatalf("expandNL = %q, want \"a\\r\\nb\\r\\n\"", got)
+	}
+}
+
+func TestUSBSerialNumber(t *testing.T) {
+	for key, want := range map[string]string{
+		`SYSTEM\CurrentControlSet\Enum\FTDIBUS\VID_0403+PID_6001+A50285BIA\0000`: "A50285BI",
+		`SYSTEM\CurrentControlSet\Enum\USB\VID_10C4&PID_EA60\0001`:               "0001",
+		`SYSTEM\CurrentControlSet\Enum\USB\VID_2341&PID_0043\5&2B8A1F3C&0&2`:     "",
+	} {
+		if got := usbSerialNumber(key); got != want {
+			t.Errorf("usbSerialNumber(%q) = %q, want %q", key, got, want)
+		}
+	}
+
+	if got := registryDisplayString("@oem12.inf,%ftdi%;FTDI"); got != "FTDI" {
+		t.Errorf("registryDisplayString = %q, want \"FTDI\"", got)
+	}
+}
+
+func TestComputeReadParams(t *testing.T) {
+	if p := ComputeReadParams(250*time.Millisecond, 64); p != (ReadParams{ReadIntervalTimeout: 250}) {
+		t.Errorf("ComputeReadParams(250ms, 64) = %+v", p)
+	}
+	if p := ComputeReadParams(time.Second, 1); p.ReadIntervalTimeout != math.MaxUint32 || p.ReadTotalTimeoutMultiplier != math.MaxUint32 {
+		t.Errorf("ComputeReadParams(1s, 1) = %+v, want MAXDWORD interval and multiplier", p)
+	}
+}
+
+func TestCommTimeoutsFor(t *testing.T) {
+	// Like on Linux, every read returns as soon as any byte is available.
+	for _, tt := range []struct {
+		timeout  time.Duration
+		constant uint32
+	}{
+		{0, readForever},
+		{500 * time.Microsecond, 1},
+		{100 * time.Millisecond, 100},
+	} {
+		cfg := DefaultConfig()
+		cfg.Timeout = tt.timeout
+		ct := commTimeoutsFor(cfg)
+		if ct.ReadIntervalTimeout != math.MaxUint32 || ct.ReadTotalTimeoutMultiplier != math.MaxUint32 || ct.ReadTotalTimeoutConstant != tt.constant {
+			t.Errorf("commTimeoutsFor(Timeout %v) = %+v, want MAXDWORD interval and multiplier and constant %v", tt.timeout, ct, tt.constant)
 		}
-		if n == 0 {
-			break
+	}
+}
+
+func TestModemControlBits(t *testing.T) {
+	// SetConfig must keep DTR and RTS as set by SetDTR and SetRTS.
+	sp := &SerialPort{dtr: true}
+	if bits := sp.modemControlBits(); bits != win32DTR_CONTROL_ENABLE {
+		t.Fatalf("modemControlBits with DTR = %#x, want %#x", bits, win32DTR_CONTROL_ENABLE)
+	}
+	sp = &SerialPort{rts: true}
+	if bits := sp.modemControlBits(); bits != win32RTS_CONTROL_ENABLE {
+		t.Fatalf("modemControlBits with RTS = %#x, want %#x", bits, win32RTS_CONTROL_ENABLE)
+	}
+}
+
+func TestModemLinesFromDCB(t *testing.T) {
+	dcb := win32DCB{fxxxxBits: win32DTR_CONTROL_ENABLE | win32RTS_CONTROL_HANDSHAKE}
+	if dtr, rts := modemLinesFromDCB(&dcb); !dtr || rts {
+		t.Fatalf("modemLinesFromDCB = %v, %v; want true, false", dtr, rts)
+	}
+}
+
+func TestDecodeCommErrors(t *testing.T) {
+	flags := decodeCommErrors(win32CE_OVERRUN | win32CE_FRAME | 0x8000)
+	if flags != CommOverrun|CommFrame {
+		t.Fatalf("decodeCommErrors = %#x, want CommOverrun|CommFrame", flags)
+	}
+}
+
+func TestCountLineErrors(t *testing.T) {
+	var stats LineErrorStats
+	countLineErrors(&stats, win32CE_RXPARITY|win32CE_OVERRUN|win32CE_RXOVER)
+	countLineErrors(&stats, win32CE_FRAME|win32CE_BREAK)
+	countLineErrors(&stats, win32CE_FRAME)
+	want := LineErrorStats{Parity: 1, Framing: 2, Overrun: 1, Break: 1}
+	if stats != want {
+		t.Fatalf("countLineErrors = %+v, want %+v", stats, want)
+	}
+}
+
+func TestDecodeModemBits(t *testing.T) {
+	m := decodeModemBits(win32MS_CTS_ON | win32MS_RLSD_ON)
+	if m != ModemCTS|ModemDCD {
+		t.Fatalf("decodeModemBits = %v, want CTS|DCD", m)
+	}
+}
+
+func TestApplyFlowControl(t *testing.T) {
+	for _, fc := range []int{FlowNone, FlowHardware, FlowSoftware} {
+		dcb := win32DCB{fxxxxBits: win32RTS_CONTROL_ENABLE}
+		applyFlowControl(&dcb, Config{FlowControl: fc})
+		if got := flowControl(&dcb); got != fc {
+			t.Fatalf("flowControl = %v after applying %v (bits %#x)", got, fc, dcb.fxxxxBits)
 		}
-		t.Logf("Read %v bytes: %v", n, string(buf[:n]))
 	}
 }
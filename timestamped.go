@@ -0,0 +1,42 @@
+package serialport
+
+import "time"
+
+// A TimestampedReader reads from a SerialPort, recording when each chunk was received,
+// for analyzing the inter-byte and inter-frame timing of a protocol.
+type TimestampedReader struct {
+	sp  *SerialPort
+	buf []byte
+}
+
+// NewTimestampedReader returns a TimestampedReader for sp that returns chunks of at most size bytes
+// (4096 if size <= 0). Small chunks give finer timing at the cost of more system calls.
+func NewTimestampedReader(sp *SerialPort, size int) *TimestampedReader {
+	if size <= 0 {
+		size = 4096
+	}
+	return &TimestampedReader{sp: sp, buf: make([]byte, size)}
+}
+
+// ReadTimestamped waits up to the configured Timeout (forever if Timeout is 0) for data
+// and returns the chunk received together with the time it was read, which carries a monotonic
+// clock reading, so gaps computed with Sub are immune to wall clock changes.
+// If no data arrives in time, it returns ErrTimeout.
+// Data left over from the line reader is returned first and stamped with the time of the call.
+func (tr *TimestampedReader) ReadTimestamped() ([]byte, time.Time, error) {
+	sp := tr.sp
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	n := sp.takeBuffered(tr.buf)
+	var err error
+	if n == 0 {
+		n, err = sp.readTimeout(tr.buf, sp.readTimeoutBudget())
+	}
+	now := time.Now()
+	if n == 0 {
+		return nil, time.Time{}, err
+	}
+
+	return append([]byte(nil), tr.buf[:n]...), now, err
+}
@@ -0,0 +1,44 @@
+package serialport
+
+// Chipset names reported by SerialPort.Chipset.
+const (
+	ChipsetFTDI    = "FTDI"    // FTDI FT232R, FT2232, FT4232, FT-X, etc.
+	ChipsetCP210x  = "CP210x"  // Silicon Labs CP2102, CP2104, CP2105, etc.
+	ChipsetCH340   = "CH340"   // WCH CH340, CH341
+	ChipsetPL2303  = "PL2303"  // Prolific PL2303
+	ChipsetUnknown = "unknown" // USB adapter with an unrecognized VID/PID
+	ChipsetNative  = "native"  // not a USB adapter
+)
+
+// usbChipsets maps USB vendor IDs to the product IDs of known serial adapter chipsets.
+// A nil product map matches every product of that vendor.
+var usbChipsets = map[uint16]struct {
+	name     string
+	products map[uint16]bool
+}{
+	0x0403: {ChipsetFTDI, nil},
+	0x10c4: {ChipsetCP210x, map[uint16]bool{0xea60: true, 0xea61: true, 0xea63: true, 0xea70: true, 0xea71: true}},
+	0x1a86: {ChipsetCH340, map[uint16]bool{0x5523: true, 0x7522: true, 0x7523: true}},
+	0x067b: {ChipsetPL2303, map[uint16]bool{0x2303: true, 0x23a3: true, 0x23b3: true, 0x23c3: true, 0x23d3: true, 0x23e3: true, 0x23f3: true}},
+}
+
+func chipsetFromUSBID(vid, pid uint16) string {
+	chip, ok := usbChipsets[vid]
+	if !ok || (chip.products != nil && !chip.products[pid]) {
+		return ChipsetUnknown
+	}
+	return chip.name
+}
+
+// Chipset identifies the USB serial adapter chipset behind the serial port from its USB VID/PID.
+// It returns ChipsetUnknown for unrecognized USB adapters and ChipsetNative for non-USB ports.
+func (sp *SerialPort) Chipset() (string, error) {
+	vid, pid, ok, err := usbID(sp.name)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return ChipsetNative, nil
+	}
+	return chipsetFromUSBID(vid, pid), nil
+}
@@ -0,0 +1,78 @@
+package serialport
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sysClassTTY is where the kernel exposes tty devices in sysfs.
+var sysClassTTY = "/sys/class/tty"
+
+// ttyDevice returns the sysfs device directory of the serial port name,
+// following symlinks such as /dev/serial/by-id/... to the real tty.
+// ok is false if the tty has no backing device (e.g. a pseudo-terminal).
+func ttyDevice(name string) (dir string, ok bool, err error) {
+	if path, err := filepath.EvalSymlinks(name); err == nil {
+		name = path
+	}
+
+	dir, err = filepath.EvalSymlinks(filepath.Join(sysClassTTY, filepath.Base(name), "device"))
+	if os.IsNotExist(err) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+
+	return dir, true, nil
+}
+
+// usbDevice walks up from the sysfs device directory of the serial port name
+// to the USB device it belongs to. ok is false if it is not a USB device.
+func usbDevice(name string) (dir string, ok bool, err error) {
+	dir, ok, err = ttyDevice(name)
+	if !ok || err != nil {
+		return
+	}
+
+	for ; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if _, err := os.Stat(filepath.Join(dir, "idVendor")); err == nil {
+			return dir, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+func usbID(name string) (vid, pid uint16, ok bool, err error) {
+	dir, ok, err := usbDevice(name)
+	if !ok || err != nil {
+		return
+	}
+
+	if vid, err = readSysfsHex(filepath.Join(dir, "idVendor")); err != nil {
+		return
+	}
+	pid, err = readSysfsHex(filepath.Join(dir, "idProduct"))
+
+	return
+}
+
+func readSysfsString(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+func readSysfsHex(path string) (uint16, error) {
+	s, err := readSysfsString(path)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(s, 16, 16)
+	return uint16(v), err
+}
@@ -0,0 +1,85 @@
+package serialport
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/windows/registry"
+)
+
+// usbEnumerators are the device enumerators under HKLM\SYSTEM\CurrentControlSet\Enum
+// that USB serial adapters are registered with. FTDI's VCP driver uses its own bus.
+var usbEnumerators = []string{`USB`, `FTDIBUS`}
+
+var usbIDPattern = regexp.MustCompile(`(?i)VID_([0-9a-f]{4}).PID_([0-9a-f]{4})`)
+
+// usbDeviceKey finds the registry key of the USB device that provides the serial port name.
+// ok is false if it is not a USB device.
+func usbDeviceKey(name string) (path string, ok bool, err error) {
+	port := strings.TrimPrefix(name, `\\.\`)
+
+	for _, enum := range usbEnumerators {
+		enumPath := `SYSTEM\CurrentControlSet\Enum\` + enum
+		devices, err := registrySubKeys(enumPath)
+		if err != nil {
+			return "", false, err
+		}
+		for _, device := range devices {
+			instances, err := registrySubKeys(enumPath + `\` + device)
+			if err != nil {
+				return "", false, err
+			}
+			for _, instance := range instances {
+				key := enumPath + `\` + device + `\` + instance
+				if strings.EqualFold(registryString(key+`\Device Parameters`, "PortName"), port) {
+					return key, true, nil
+				}
+			}
+		}
+	}
+
+	return "", false, nil
+}
+
+func usbID(name string) (vid, pid uint16, ok bool, err error) {
+	path, ok, err := usbDeviceKey(name)
+	if !ok || err != nil {
+		return
+	}
+
+	m := usbIDPattern.FindStringSubmatch(path)
+	if m == nil {
+		return 0, 0, false, nil
+	}
+	v, _ := strconv.ParseUint(m[1], 16, 16)
+	p, _ := strconv.ParseUint(m[2], 16, 16)
+
+	return uint16(v), uint16(p), true, nil
+}
+
+// registrySubKeys returns the names of the subkeys of HKLM\path, or nil if it does not exist.
+func registrySubKeys(path string) ([]string, error) {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.ENUMERATE_SUB_KEYS)
+	if err == registry.ErrNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer k.Close()
+
+	return k.ReadSubKeyNames(-1)
+}
+
+// registryString returns the string value HKLM\path\name, or "" if it cannot be read.
+func registryString(path, name string) string {
+	k, err := registry.OpenKey(registry.LOCAL_MACHINE, path, registry.QUERY_VALUE)
+	if err != nil {
+		return ""
+	}
+	defer k.Close()
+
+	s, _, _ := k.GetStringValue(name)
+	return s
+}
@@ -0,0 +1,583 @@
+package serialport
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// XMODEM and YMODEM control characters.
+const (
+	xmSOH = 0x01 // start of a 128-byte block
+	xmSTX = 0x02 // start of a 1024-byte block
+	xmEOT = 0x04 // end of the file
+	xmACK = 0x06 // block received
+	xmNAK = 0x15 // block rejected, or request for the first block in checksum mode
+	xmCAN = 0x18 // cancel the transfer, sent at least twice
+	xmCRC = 'C'  // request for the first block in CRC-16 mode
+	xmSUB = 0x1a // padding of the last block
+)
+
+const (
+	xmodemRetries        = 10               // attempts per block, and requests for the first one
+	xmodemStartTimeout   = 60 * time.Second // how long the sender waits for the receiver to request the first block
+	xmodemReplyTimeout   = 10 * time.Second // how long the sender waits for the reply to a block
+	xmodemPromptInterval = 3 * time.Second  // how often the receiver requests the first block
+	xmodemBlockTimeout   = 10 * time.Second // how long the receiver waits for a block
+	xmodemPurgeQuiet     = time.Second      // how long the line must be quiet before the receiver rejects a bad block
+)
+
+// ErrTransferCancelled is returned by the XMODEM and YMODEM transfers when the peer cancels the transfer.
+var ErrTransferCancelled = errors.New("serialport: transfer cancelled by the peer")
+
+// errBadBlock is returned by readBlock for a block that was received damaged.
+var errBadBlock = errors.New("serialport: bad block")
+
+// SendXMODEM sends data with XMODEM in 128-byte blocks, using CRC-16 or the arithmetic checksum as the receiver requests.
+// The last block is padded with SUB (0x1a), XMODEM does not transmit the length of the data.
+// The transfer holds the read and write sides of the serial port, and each block is tried up to 10 times.
+func (sp *SerialPort) SendXMODEM(data io.Reader) error {
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	x := &xmodem{sp: sp}
+	if err := x.waitStart(); err != nil {
+		return err
+	}
+	return x.sendData(data, 128)
+}
+
+// ReceiveXMODEM receives a file sent with XMODEM or XMODEM-1K and writes it to w.
+// It requests CRC-16 and falls back to the arithmetic checksum if the sender does not answer.
+// Since XMODEM does not transmit the length of the data, w also receives the padding of the last block.
+// The transfer holds the read and write sides of the serial port.
+func (sp *SerialPort) ReceiveXMODEM(w io.Writer) error {
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	x := &xmodem{sp: sp}
+	c, err := x.start(true)
+	if err != nil {
+		return err
+	}
+	return x.receiveData(w, c, -1, false)
+}
+
+// SendYMODEM sends data as the file name with YMODEM batch, in 1024-byte blocks with CRC-16.
+// size is announced to the receiver, which uses it to strip the padding of the last block;
+// a negative size leaves it out. name is sent as is, it should not contain a directory.
+// The transfer holds the read and write sides of the serial port.
+func (sp *SerialPort) SendYMODEM(name string, size int64, data io.Reader) error {
+	header := append([]byte(name), 0)
+	if size >= 0 {
+		header = strconv.AppendInt(header, size, 10)
+	}
+	if name == "" || len(header) > 128 {
+		return fmt.Errorf("serialport: invalid YMODEM file name %q", name)
+	}
+
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	x := &xmodem{sp: sp}
+	if err := x.waitStart(); err != nil {
+		return err
+	}
+	if err := x.sendBlock(0, x.block(0, header, 128, 0)); err != nil {
+		return err
+	}
+	if err := x.waitStart(); err != nil {
+		return err
+	}
+	if err := x.sendData(data, 1024); err != nil {
+		return err
+	}
+
+	// An empty file name ends the batch.
+	if err := x.waitStart(); err != nil {
+		return err
+	}
+	return x.sendBlock(0, x.block(0, nil, 128, 0))
+}
+
+// ReceiveYMODEM receives one file sent with YMODEM batch and writes it to w, returning its name and size.
+// If the sender announced no size, size is -1 and w also receives the padding of the last block.
+// If the sender ends the batch without a file, name is empty. A batch of several files is cancelled after the first one.
+// The transfer holds the read and write sides of the serial port.
+func (sp *SerialPort) ReceiveYMODEM(w io.Writer) (name string, size int64, err error) {
+	sp.wmu.Lock()
+	defer sp.wmu.Unlock()
+	sp.rmu.Lock()
+	defer sp.rmu.Unlock()
+
+	x := &xmodem{sp: sp}
+	c, err := x.start(false)
+	if err != nil {
+		return "", 0, err
+	}
+	header, err := x.receiveHeader(c)
+	if err != nil {
+		return "", 0, err
+	}
+	if err = x.reply(xmACK); err != nil {
+		return "", 0, err
+	}
+	name, size = parseYMODEMHeader(header)
+	if name == "" {
+		return "", 0, nil
+	}
+
+	if c, err = x.start(false); err != nil {
+		return
+	}
+	if err = x.receiveData(w, c, size, true); err != nil {
+		return
+	}
+
+	if c, err = x.start(false); err != nil {
+		return
+	}
+	if header, err = x.receiveHeader(c); err != nil {
+		return
+	}
+	if header[0] != 0 {
+		x.cancel()
+		return name, size, fmt.Errorf("serialport: YMODEM batch holds more than one file, only %q was received", name)
+	}
+	err = x.reply(xmACK)
+	return
+}
+
+// parseYMODEMHeader decodes the file name and size of block 0, where the name is followed by NUL
+// and then by the decimal size and other optional fields separated by spaces.
+func parseYMODEMHeader(header []byte) (name string, size int64) {
+	i := bytes.IndexByte(header, 0)
+	if i < 0 {
+		i = len(header)
+	}
+	name = string(header[:i])
+
+	size = -1
+	if i < len(header) {
+		info := header[i+1:]
+		if j := bytes.IndexByte(info, 0); j >= 0 {
+			info = info[:j]
+		}
+		if fields := strings.Fields(string(info)); len(fields) > 0 {
+			if n, err := strconv.ParseInt(fields[0], 10, 64); err == nil && n >= 0 {
+				size = n
+			}
+		}
+	}
+	return
+}
+
+// xmodem runs one XMODEM or YMODEM transfer over sp, whose read and write sides the caller holds.
+type xmodem struct {
+	sp  *SerialPort
+	crc bool // CRC-16 rather than the arithmetic checksum
+}
+
+// waitStart waits for the receiver to request the next file with 'C' (CRC-16) or NAK (checksum).
+func (x *xmodem) waitStart() error {
+	deadline := time.Now().Add(xmodemStartTimeout)
+	for {
+		c, err := x.readByte(remaining(deadline))
+		if err != nil {
+			return err
+		}
+		switch c {
+		case xmCRC:
+			x.crc = true
+			return nil
+		case xmNAK:
+			x.crc = false
+			return nil
+		case xmCAN:
+			if x.cancelled() {
+				return ErrTransferCancelled
+			}
+		}
+	}
+}
+
+// sendData sends data in blocks of size bytes numbered from 1, then ends the file with EOT.
+// With 1024-byte blocks, a last block that fits is sent as a 128-byte one.
+func (x *xmodem) sendData(data io.Reader, size int) error {
+	buf := make([]byte, size)
+	for seq := byte(1); ; seq++ {
+		n, err := io.ReadFull(data, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			x.cancel()
+			return err
+		}
+
+		blockSize := size
+		if n <= 128 {
+			blockSize = 128
+		}
+		if err := x.sendBlock(seq, x.block(seq, buf[:n], blockSize, xmSUB)); err != nil {
+			return err
+		}
+		if n < size {
+			break
+		}
+	}
+
+	// YMODEM receivers reject the first EOT to make sure it is not line noise.
+	for try := 0; try < xmodemRetries; try++ {
+		if err := x.write([]byte{xmEOT}); err != nil {
+			return err
+		}
+		c, err := x.waitReply()
+		if err != nil {
+			return err
+		}
+		if c == xmACK {
+			return nil
+		}
+	}
+	x.cancel()
+	return fmt.Errorf("serialport: end of transfer not acknowledged after %v attempts", xmodemRetries)
+}
+
+// block encodes data as block seq of size bytes, padded with pad.
+func (x *xmodem) block(seq byte, data []byte, size int, pad byte) []byte {
+	start := byte(xmSOH)
+	if size == 1024 {
+		start = xmSTX
+	}
+	b := make([]byte, 0, 3+size+2)
+	b = append(b, start, seq, ^seq)
+	b = append(b, data...)
+	for len(b) < 3+size {
+		b = append(b, pad)
+	}
+
+	if x.crc {
+		crc := crc16XMODEM(b[3:])
+		return append(b, byte(crc>>8), byte(crc))
+	}
+	return append(b, checksum8(b[3:]))
+}
+
+// sendBlock sends block until the receiver acknowledges it.
+func (x *xmodem) sendBlock(seq byte, block []byte) error {
+	for try := 0; try < xmodemRetries; try++ {
+		if err := x.write(block); err != nil {
+			return err
+		}
+		c, err := x.waitReply()
+		if err != nil {
+			return err
+		}
+		if c == xmACK {
+			return nil
+		}
+	}
+	x.cancel()
+	return fmt.Errorf("serialport: block %v not acknowledged after %v attempts", seq, xmodemRetries)
+}
+
+// waitReply waits for the receiver to answer ACK or NAK, skipping anything else.
+// A timeout is reported as NAK, to send again.
+func (x *xmodem) waitReply() (byte, error) {
+	deadline := time.Now().Add(xmodemReplyTimeout)
+	for {
+		c, err := x.readByte(remaining(deadline))
+		if err == ErrTimeout {
+			return xmNAK, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case xmACK, xmNAK:
+			return c, nil
+		case xmCAN:
+			if x.cancelled() {
+				return 0, ErrTransferCancelled
+			}
+		}
+	}
+}
+
+// start requests the first block of a file, with 'C' for CRC-16, or after three unanswered requests
+// with NAK for the checksum if allowChecksum is set. It returns the first byte the sender answers with.
+func (x *xmodem) start(allowChecksum bool) (byte, error) {
+	for try := 0; try < xmodemRetries; try++ {
+		x.crc = !allowChecksum || try < 3
+		prompt := byte(xmNAK)
+		if x.crc {
+			prompt = xmCRC
+		}
+		if err := x.reply(prompt); err != nil {
+			return 0, err
+		}
+
+		c, err := x.readByte(xmodemPromptInterval)
+		if err == ErrTimeout {
+			continue
+		}
+		if err != nil {
+			return 0, err
+		}
+		switch c {
+		case xmSOH, xmSTX, xmEOT:
+			return c, nil
+		case xmCAN:
+			if x.cancelled() {
+				return 0, ErrTransferCancelled
+			}
+		}
+	}
+	return 0, ErrTimeout
+}
+
+// receiveHeader receives YMODEM block 0, of which c is the first byte, and returns its data
+// without acknowledging it.
+func (x *xmodem) receiveHeader(c byte) ([]byte, error) {
+	for fails := 0; fails < xmodemRetries; fails++ {
+		switch c {
+		case xmSOH, xmSTX:
+			seq, data, err := x.readBlock(c)
+			if err == nil && seq == 0 {
+				return data, nil
+			}
+			if err != nil && err != errBadBlock && err != ErrTimeout {
+				return nil, err
+			}
+			if err := x.purge(); err != nil {
+				return nil, err
+			}
+		case xmCAN:
+			if x.cancelled() {
+				return nil, ErrTransferCancelled
+			}
+		}
+
+		if err := x.reply(xmNAK); err != nil {
+			return nil, err
+		}
+		var err error
+		if c, err = x.readByte(xmodemBlockTimeout); err != nil && err != ErrTimeout {
+			return nil, err
+		}
+	}
+	x.cancel()
+	return nil, fmt.Errorf("serialport: YMODEM header not received after %v attempts", xmodemRetries)
+}
+
+// receiveData receives the blocks of a file until EOT and writes them to w, of which c is the first byte.
+// If limit is not negative, at most limit bytes are written. If ymodem is set the first EOT is rejected,
+// as YMODEM requires.
+func (x *xmodem) receiveData(w io.Writer, c byte, limit int64, ymodem bool) error {
+	expected := byte(1)
+	eots := 0
+	for fails := 0; fails < xmodemRetries; {
+		switch c {
+		case xmEOT:
+			if ymodem && eots == 0 {
+				eots++
+				if err := x.reply(xmNAK); err != nil {
+					return err
+				}
+				break
+			}
+			return x.reply(xmACK)
+
+		case xmSOH, xmSTX:
+			seq, data, err := x.readBlock(c)
+			switch {
+			case err == errBadBlock || err == ErrTimeout:
+				fails++
+				if err := x.purge(); err != nil {
+					return err
+				}
+				if err := x.reply(xmNAK); err != nil {
+					return err
+				}
+			case err != nil:
+				return err
+			case seq == expected:
+				if limit >= 0 {
+					if int64(len(data)) > limit {
+						data = data[:limit]
+					}
+					limit -= int64(len(data))
+				}
+				if _, err := w.Write(data); err != nil {
+					x.cancel()
+					return err
+				}
+				expected++
+				fails = 0
+				if err := x.reply(xmACK); err != nil {
+					return err
+				}
+			case seq == expected-1:
+				// The sender missed the ACK of the previous block and sent it again.
+				if err := x.reply(xmACK); err != nil {
+					return err
+				}
+			default:
+				x.cancel()
+				return fmt.Errorf("serialport: expected block %v, received block %v", expected, seq)
+			}
+
+		case xmCAN:
+			if x.cancelled() {
+				return ErrTransferCancelled
+			}
+		}
+
+		var err error
+		c, err = x.readByte(xmodemBlockTimeout)
+		if err == ErrTimeout {
+			fails++
+			c = 0
+			if err := x.reply(xmNAK); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	x.cancel()
+	return fmt.Errorf("serialport: block %v not received after %v attempts", expected, xmodemRetries)
+}
+
+// readBlock reads the rest of the block that starts with start and verifies it.
+// It returns errBadBlock if the block was damaged.
+func (x *xmodem) readBlock(start byte) (seq byte, data []byte, err error) {
+	size := 128
+	if start == xmSTX {
+		size = 1024
+	}
+	trailer := 1
+	if x.crc {
+		trailer = 2
+	}
+
+	b := make([]byte, 2+size+trailer)
+	if err = x.readFull(b, xmodemBlockTimeout); err != nil {
+		return
+	}
+	if b[0] != ^b[1] {
+		return 0, nil, errBadBlock
+	}
+	data = b[2 : 2+size]
+	if x.crc {
+		if crc16XMODEM(data) != uint16(b[2+size])<<8|uint16(b[3+size]) {
+			return 0, nil, errBadBlock
+		}
+	} else if checksum8(data) != b[2+size] {
+		return 0, nil, errBadBlock
+	}
+	return b[0], data, nil
+}
+
+// cancelled reports whether a CAN just received is followed by a second one, which cancels the transfer.
+func (x *xmodem) cancelled() bool {
+	c, err := x.readByte(time.Second)
+	return err == nil && c == xmCAN
+}
+
+// cancel asks the peer to abort the transfer.
+func (x *xmodem) cancel() {
+	x.write([]byte{xmCAN, xmCAN})
+}
+
+// purge discards received data until the line is quiet, so that the rest of a damaged block
+// is not taken for the start of the next one.
+func (x *xmodem) purge() error {
+	x.sp.discardBuffered()
+	deadline := time.Now().Add(xmodemBlockTimeout)
+	buf := make([]byte, 1024)
+	for time.Now().Before(deadline) {
+		_, err := x.sp.readTimeout(buf, xmodemPurgeQuiet)
+		if err == ErrTimeout {
+			return nil
+		}
+		if err != nil {
+			if err = x.sp.filterError(err); err != nil && err != ErrRetry {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// reply sends the single control character c.
+func (x *xmodem) reply(c byte) error {
+	return x.write([]byte{c})
+}
+
+func (x *xmodem) write(b []byte) error {
+	_, err := x.sp.writeChunked(b)
+	return err
+}
+
+func (x *xmodem) readByte(timeout time.Duration) (byte, error) {
+	var b [1]byte
+	err := x.readFull(b[:], timeout)
+	return b[0], err
+}
+
+// readFull reads exactly len(b) bytes within timeout, or returns ErrTimeout.
+func (x *xmodem) readFull(b []byte, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	n := x.sp.takeBuffered(b)
+	for n < len(b) {
+		m, err := x.sp.readTimeout(b[n:], remaining(deadline))
+		n += m
+		if err != nil {
+			if err = x.sp.filterError(err); err != nil && err != ErrRetry {
+				return err
+			}
+		}
+		if m == 0 && remaining(deadline) == 0 {
+			return ErrTimeout
+		}
+	}
+	return nil
+}
+
+// crc16XMODEM returns the CRC-16 of XMODEM (polynomial 0x1021, initial value 0).
+func crc16XMODEM(b []byte) uint16 {
+	var crc uint16
+	for _, c := range b {
+		crc ^= uint16(c) << 8
+		for i := 0; i < 8; i++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+// checksum8 returns the arithmetic checksum of XMODEM, the sum of the bytes modulo 256.
+func checksum8(b []byte) (sum byte) {
+	for _, c := range b {
+		sum += c
+	}
+	return
+}